@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rubensayshi/dubby/src/manifest"
+	"github.com/rubensayshi/dubby/src/srcreader"
+)
+
+// lspWorkspace finds the source directories for the language server,
+// those of the units in dubby.yaml or otherwise the parent of a file that has a slots directory.
+type lspWorkspace struct {
+	rep         *reporter
+	m           *manifest.Manifest
+	hasManifest bool
+}
+
+func (w *lspWorkspace) Root() string {
+	return w.m.Dir()
+}
+
+func (w *lspWorkspace) NewReader(file string) (*srcreader.SrcReader, string, error) {
+	for _, unit := range w.m.AllUnits() {
+		srcdir := w.m.SrcDir(unit)
+		if w.hasManifest && isInDir(file, srcdir) {
+			reader, err := newSrcReader(w.rep, w.m, unit, srcdir)
+			if err != nil {
+				return nil, "", errors.WithStack(err)
+			}
+
+			return reader, srcdir, nil
+		}
+	}
+
+	for dir := filepath.Dir(file); filepath.Dir(dir) != dir; dir = filepath.Dir(dir) {
+		slots, err := os.Stat(filepath.Join(dir, w.m.Layout.Slots))
+		if err != nil || !slots.IsDir() {
+			continue
+		}
+
+		reader, err := newSrcReader(w.rep, w.m, w.m.DefaultUnit(), dir)
+		if err != nil {
+			return nil, "", errors.WithStack(err)
+		}
+
+		return reader, dir, nil
+	}
+
+	return nil, "", nil
+}
+
+// isInDir checks if a file is somewhere in a directory
+func isInDir(file string, dir string) bool {
+	rel, err := filepath.Rel(dir, file)
+	if err != nil {
+		return false
+	}
+
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -1,18 +1,38 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/rubensayshi/dubby/src/diagnostics"
+	"github.com/rubensayshi/dubby/src/dustructs"
 	"github.com/rubensayshi/dubby/src/jsonimporter"
+	"github.com/rubensayshi/dubby/src/lsp"
+	"github.com/rubensayshi/dubby/src/luamin"
+	"github.com/rubensayshi/dubby/src/manifest"
+	"github.com/rubensayshi/dubby/src/scriptdiff"
+	"github.com/rubensayshi/dubby/src/srcmerge"
 	"github.com/rubensayshi/dubby/src/srcreader"
+	"github.com/rubensayshi/dubby/src/srcutils"
 	"github.com/rubensayshi/dubby/src/srcwriter"
+	"github.com/rubensayshi/dubby/src/watcher"
+	"github.com/rubensayshi/dubby/src/yamlimporter"
 	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v2"
 )
 
+// STDIO can be used instead of a file to read from stdin or write to stdout
+const STDIO = "-"
+
 func main() {
 	app := cli.NewApp()
 	app.Name = "dubby"
@@ -26,80 +46,853 @@ func main() {
 	app.Commands = []*cli.Command{{
 		Name:      "parse-to-src",
 		Aliases:   []string{},
-		Usage:     "parse a json file into a source directory",
-		ArgsUsage: "inputfile srcdir",
-		Flags:     []cli.Flag{},
-		Action: func(c *cli.Context) error {
+		Usage:     "parse a json file (or auto configure yaml file) into a source directory",
+		ArgsUsage: "inputfile srcdir (use - as inputfile to read from stdin, srcdir defaults to the src of dubby.yaml when it's set)",
+		Flags:     append([]cli.Flag{unitFlag(), filterFlag()}, diagnosticFlags()...),
+		Action: withDiagnostics(os.Stderr, func(c *cli.Context, rep *reporter) error {
+			m, hasManifest, err := loadManifest()
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			for _, filter := range c.StringSlice("filter") {
+				m.Filters = append(m.Filters, parseFilterFlag(filter))
+			}
+
+			unit, err := selectUnit(c, m, c.Args().Get(1))
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
 			inputfile := c.Args().Get(0)
 			if inputfile == "" {
 				cli.ShowCommandHelpAndExit(c, "parse-to-src", 1)
 				return nil
 			}
-			_, err := os.Stat(inputfile)
-			if os.IsNotExist(err) {
-				return errors.Errorf("can't open json file: %s", inputfile)
+			if inputfile != STDIO {
+				_, err := os.Stat(inputfile)
+				if os.IsNotExist(err) {
+					return errors.Errorf("can't open input file: %s", inputfile)
+				}
 			}
 
-			srcdir := c.Args().Get(0)
+			// parsing replaces what's in the srcdir, so it's only taken from the manifest when src is set explicitly
+			srcdir := c.Args().Get(1)
+			if srcdir == "" && hasManifest && unit.Src != "" {
+				srcdir = m.SrcDir(unit)
+			}
 			if srcdir == "" {
+				if hasManifest {
+					return errors.Errorf("no srcdir, set src in %s or pass the srcdir", manifest.MANIFEST_FILE)
+				}
 				cli.ShowCommandHelpAndExit(c, "parse-to-src", 1)
 				return nil
 			}
 
-			return parseToSrc(inputfile, srcdir)
-		},
+			return parseToSrc(rep, m, inputfile, srcdir)
+		}),
 	}, {
 		Name:      "export-to-json",
 		Aliases:   []string{},
 		Usage:     "compile a source directory and export to json",
-		ArgsUsage: "srcdir outputfile",
-		Flags: []cli.Flag{
+		ArgsUsage: "srcdir outputfile (use - as outputfile to write to stdout, both default to dubby.yaml)",
+		Flags:     exportFlags(),
+		Action: withDiagnostics(os.Stderr, func(c *cli.Context, rep *reporter) error {
+			m, unit, srcdir, outputfile, err := exportArgs(c, manifest.FORMAT_JSON)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			if srcdir == "" || outputfile == "" {
+				cli.ShowCommandHelpAndExit(c, "export-to-json", 1)
+				return nil
+			}
+
+			return exportToJson(rep, m, unit, srcdir, outputfile, c.String("sourcemap"))
+		}),
+	}, {
+		Name:      "export-to-yaml",
+		Aliases:   []string{},
+		Usage:     "compile a source directory and export to auto configure yaml",
+		ArgsUsage: "srcdir outputfile (use - as outputfile to write to stdout, both default to dubby.yaml)",
+		Flags: append(exportFlags(), &cli.StringFlag{
+			Name:  "name",
+			Usage: "name of the auto configure module, defaults to the name of the srcdir",
+		}),
+		Action: withDiagnostics(os.Stderr, func(c *cli.Context, rep *reporter) error {
+			m, unit, srcdir, outputfile, err := exportArgs(c, manifest.FORMAT_YAML)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			if srcdir == "" || outputfile == "" {
+				cli.ShowCommandHelpAndExit(c, "export-to-yaml", 1)
+				return nil
+			}
+
+			name := c.String("name")
+			if output := unit.Output(manifest.FORMAT_YAML); name == "" && output != nil && m.Path(output.File) == outputfile {
+				name = output.Name
+			}
+
+			return exportToYaml(rep, m, unit, srcdir, outputfile, name, c.String("sourcemap"))
+		}),
+	}, {
+		Name:    "build",
+		Aliases: []string{},
+		Usage:   "compile the source directory (or all units of the workspace) and export to all outputs in dubby.yaml",
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:  "unit",
+				Usage: "only build this unit of the workspace",
+			},
 			&cli.BoolFlag{
-				Name: "minify",
+				Name:  "minify",
+				Usage: "overrides minify in dubby.yaml",
 			},
-		},
-		Action: func(c *cli.Context) error {
-			srcdir := c.Args().Get(0)
+			&cli.StringFlag{
+				Name:  "minifier",
+				Usage: fmt.Sprintf("overrides minifier in dubby.yaml, `%s` or `%s` (requires the luamin NPM package)", luamin.MINIFIER_NATIVE, luamin.MINIFIER_LUAMIN),
+			},
+			&cli.BoolFlag{
+				Name:  "renumber",
+				Usage: "overrides renumber in dubby.yaml",
+			},
+		}, diagnosticFlags()...),
+		Action: withDiagnostics(os.Stderr, func(c *cli.Context, rep *reporter) error {
+			m, hasManifest, err := loadManifest()
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			if !hasManifest {
+				return errors.Errorf("can't find %s in the working directory or any of its parents", manifest.MANIFEST_FILE)
+			}
+
+			err = configureExport(c, m)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			units := m.AllUnits()
+			if c.String("unit") != "" {
+				unit := m.Unit(c.String("unit"))
+				if unit == nil {
+					return errors.Errorf("unknown unit: %s", c.String("unit"))
+				}
+				units = []*manifest.Unit{unit}
+			}
+
+			return build(rep, m, units)
+		}),
+	}, {
+		Name:      "lint",
+		Aliases:   []string{},
+		Usage:     "check a source directory for problems, listing all of them (on stdout) instead of stopping at the first one",
+		ArgsUsage: "srcdir (defaults to dubby.yaml)",
+		Flags:     append([]cli.Flag{unitFlag(), filterFlag()}, diagnosticFlags()...),
+		// the problems are what lint outputs, so unlike the other commands they go to stdout (eg; to redirect the sarif to a file)
+		Action: withDiagnostics(os.Stdout, func(c *cli.Context, rep *reporter) error {
+			m, unit, srcdir, _, err := exportArgs(c, manifest.FORMAT_JSON)
+			if err != nil {
+				return errors.WithStack(err)
+			}
 			if srcdir == "" {
-				cli.ShowCommandHelpAndExit(c, "parse-to-src", 1)
+				cli.ShowCommandHelpAndExit(c, "lint", 1)
 				return nil
 			}
 
-			outputfile := c.Args().Get(1)
-			if outputfile == "" {
-				cli.ShowCommandHelpAndExit(c, "parse-to-src", 1)
+			return lint(rep, m, unit, srcdir)
+		}),
+	}, {
+		Name:      "watch",
+		Aliases:   []string{},
+		Usage:     "compile a source directory and export to json, and do so again whenever the source files change",
+		ArgsUsage: "srcdir outputfile (both default to dubby.yaml)",
+		Flags: append(exportFlags(), &cli.DurationFlag{
+			Name:  "debounce",
+			Value: 300 * time.Millisecond,
+			Usage: "wait for the source files to stop changing for this long before rebuilding",
+		}),
+		Action: withDiagnostics(os.Stderr, func(c *cli.Context, rep *reporter) error {
+			m, unit, srcdir, outputfile, err := exportArgs(c, manifest.FORMAT_JSON)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			if srcdir == "" || outputfile == "" {
+				cli.ShowCommandHelpAndExit(c, "watch", 1)
 				return nil
 			}
 
-			return exportToJson(srcdir, outputfile, c.Bool("minify"))
-		},
+			return watch(rep, m, unit, srcdir, outputfile, c.String("sourcemap"), c.Duration("debounce"))
+		}),
+	}, {
+		Name:      "diff",
+		Aliases:   []string{},
+		Usage:     "compare two exports and/or source directories per slot and per handler, with a diff of the code of each handler",
+		ArgsUsage: "old new (json files, auto configure yaml files or source directories)",
+		Flags:     append([]cli.Flag{unitFlag(), filterFlag()}, diagnosticFlags()...),
+		Action: withDiagnostics(os.Stderr, func(c *cli.Context, rep *reporter) error {
+			if c.Args().Len() != 2 {
+				cli.ShowCommandHelpAndExit(c, "diff", 1)
+				return nil
+			}
+
+			m, _, err := loadManifest()
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			for _, filter := range c.StringSlice("filter") {
+				m.Filters = append(m.Filters, parseFilterFlag(filter))
+			}
+
+			unit, err := selectUnit(c, m, c.Args().Get(0))
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			return diff(rep, m, unit, c.Args().Get(0), c.Args().Get(1))
+		}),
+	}, {
+		Name:      "merge",
+		Aliases:   []string{},
+		Usage:     "merge the changes between the last built export and an export from the game into the source directory, with conflict markers where the source directory changed as well",
+		ArgsUsage: "base.json ingame.json srcdir (json files or auto configure yaml files, srcdir defaults to the src of dubby.yaml)",
+		Flags:     append([]cli.Flag{unitFlag(), filterFlag()}, diagnosticFlags()...),
+		Action: withDiagnostics(os.Stderr, func(c *cli.Context, rep *reporter) error {
+			if c.Args().Len() != 2 && c.Args().Len() != 3 {
+				cli.ShowCommandHelpAndExit(c, "merge", 1)
+				return nil
+			}
+
+			m, hasManifest, err := loadManifest()
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			for _, filter := range c.StringSlice("filter") {
+				m.Filters = append(m.Filters, parseFilterFlag(filter))
+			}
+
+			srcdir := c.Args().Get(2)
+			unit, err := selectUnit(c, m, srcdir)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			if srcdir == "" && hasManifest {
+				srcdir = m.SrcDir(unit)
+			}
+			if srcdir == "" {
+				cli.ShowCommandHelpAndExit(c, "merge", 1)
+				return nil
+			}
+
+			return merge(rep, m, c.Args().Get(0), c.Args().Get(1), srcdir)
+		}),
+	}, {
+		Name:      "verify",
+		Aliases:   []string{},
+		Usage:     "check that converting an export to a source directory and back (or the other way around) doesn't lose or change anything",
+		ArgsUsage: "input (a json file, an auto configure yaml file or a source directory, defaults to the src of dubby.yaml)",
+		Flags:     append([]cli.Flag{unitFlag(), filterFlag()}, diagnosticFlags()...),
+		Action: withDiagnostics(os.Stderr, func(c *cli.Context, rep *reporter) error {
+			m, hasManifest, err := loadManifest()
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			for _, filter := range c.StringSlice("filter") {
+				m.Filters = append(m.Filters, parseFilterFlag(filter))
+			}
+
+			input := c.Args().Get(0)
+			unit, err := selectUnit(c, m, input)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			if input == "" && hasManifest {
+				input = m.SrcDir(unit)
+			}
+			if input == "" {
+				cli.ShowCommandHelpAndExit(c, "verify", 1)
+				return nil
+			}
+
+			return verify(rep, m, unit, input)
+		}),
+	}, {
+		Name:    "lsp",
+		Aliases: []string{},
+		Usage:   "run a language server on stdin and stdout, for editors to show problems, complete filters and go to the globals of the lib",
+		Flags:   append([]cli.Flag{filterFlag()}, diagnosticFlags()...),
+		Action: withDiagnostics(os.Stderr, func(c *cli.Context, rep *reporter) error {
+			m, hasManifest, err := loadManifest()
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			for _, filter := range c.StringSlice("filter") {
+				m.Filters = append(m.Filters, parseFilterFlag(filter))
+			}
+
+			return lsp.NewServer(&lspWorkspace{rep: rep, m: m, hasManifest: hasManifest}).Serve(os.Stdin, os.Stdout)
+		}),
 	}}
 
 	err := app.Run(os.Args)
 	if err != nil {
-		panic(fmt.Sprintf("%+v", err))
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
+
+func exportFlags() []cli.Flag {
+	return append([]cli.Flag{
+		&cli.BoolFlag{
+			Name: "minify",
+		},
+		&cli.StringFlag{
+			Name:  "minifier",
+			Value: luamin.MINIFIER_NATIVE,
+			Usage: fmt.Sprintf("minifier to use with --minify, `%s` or `%s` (requires the luamin NPM package)", luamin.MINIFIER_NATIVE, luamin.MINIFIER_LUAMIN),
+		},
+		&cli.StringFlag{
+			Name:  "sourcemap",
+			Usage: "write a source map to this file, mapping the lines of the compiled handlers to the source files",
+		},
+		&cli.BoolFlag{
+			Name:  "renumber",
+			Usage: "number the handlers in the order they're read, instead of giving them back their keys from metadata.json",
+		},
+		&cli.StringSliceFlag{
+			Name:  "lib-path",
+			Usage: "include the lib files from this directory (outside of the project), can be used multiple times",
+		},
+		filterFlag(),
+		&cli.StringSliceFlag{
+			Name:  "lib-include",
+			Usage: "only include these files (eg; `utils/strings`, `utils` or `utils/*`) from the --lib-path directories",
+		},
+		unitFlag(),
+	}, diagnosticFlags()...)
+}
+
+func diagnosticFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "strict",
+			Usage: "treat warnings as errors",
+		},
+		&cli.StringFlag{
+			Name:  "format",
+			Value: diagnostics.FORMAT_TEXT,
+			Usage: fmt.Sprintf("format of the errors and warnings, `%s`, `%s` or `%s`", diagnostics.FORMAT_TEXT, diagnostics.FORMAT_JSON, diagnostics.FORMAT_SARIF),
+		},
+	}
+}
+
+func filterFlag() cli.Flag {
+	return &cli.StringSliceFlag{
+		Name:  "filter",
+		Usage: "add a filter which dubby doesn't know about, eg; `opened(id)` or `DoorUnit:opened(id)`, can be used multiple times",
+	}
+}
+
+func unitFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:  "unit",
+		Usage: "the unit of the workspace in dubby.yaml to use",
+	}
+}
+
+// selectUnit picks the unit of the workspace with the --unit flag,
+// when the srcdir is specified there's no need to pick one so the top-level settings of the manifest are used.
+func selectUnit(c *cli.Context, m *manifest.Manifest, srcdir string) (*manifest.Unit, error) {
+	name := c.String("unit")
+	if name != "" {
+		unit := m.Unit(name)
+		if unit == nil {
+			return nil, errors.Errorf("unknown unit: %s", name)
+		}
+
+		return unit, nil
+	}
+
+	if srcdir == "" && len(m.Units) == 1 {
+		return m.Units[0], nil
+	}
+	if srcdir == "" && len(m.Units) > 1 {
+		return nil, errors.Errorf("%s has multiple units, use --unit to pick one", manifest.MANIFEST_FILE)
+	}
+
+	return m.DefaultUnit(), nil
+}
+
+// loadManifest finds the dubby.yaml by walking up from the working directory,
+// when there's none the defaults are used.
+func loadManifest() (*manifest.Manifest, bool, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, false, errors.WithStack(err)
 	}
+
+	m, err := manifest.FindAndLoad(cwd)
+	if err != nil {
+		return nil, false, errors.WithStack(err)
+	}
+
+	if m == nil {
+		return manifest.NewManifest(cwd), false, nil
+	}
+
+	return m, true, nil
 }
 
-func parseToSrc(inputfile string, srcdir string) error {
-	scriptExport, err := jsonimporter.Import(inputfile)
+// exportArgs gets the unit, srcdir and outputfile from the args, falling back to the dubby.yaml
+func exportArgs(c *cli.Context, format string) (*manifest.Manifest, *manifest.Unit, string, string, error) {
+	m, hasManifest, err := loadManifest()
+	if err != nil {
+		return nil, nil, "", "", errors.WithStack(err)
+	}
+
+	err = configureExport(c, m)
+	if err != nil {
+		return nil, nil, "", "", errors.WithStack(err)
+	}
+
+	srcdir := c.Args().Get(0)
+	outputfile := c.Args().Get(1)
+
+	unit, err := selectUnit(c, m, srcdir)
+	if err != nil {
+		return nil, nil, "", "", errors.WithStack(err)
+	}
+
+	for _, libPath := range c.StringSlice("lib-path") {
+		abs, err := filepath.Abs(libPath)
+		if err != nil {
+			return nil, nil, "", "", errors.WithStack(err)
+		}
+
+		m.Libs = append(m.Libs, &manifest.LibPath{
+			Path:    abs,
+			Include: c.StringSlice("lib-include"),
+		})
+	}
+
+	for _, filter := range c.StringSlice("filter") {
+		m.Filters = append(m.Filters, parseFilterFlag(filter))
+	}
+
+	if hasManifest {
+		if srcdir == "" {
+			srcdir = m.SrcDir(unit)
+		}
+		if output := unit.Output(format); outputfile == "" && output != nil {
+			outputfile = m.Path(output.File)
+		}
+	}
+
+	return m, unit, srcdir, outputfile, nil
+}
+
+// configureExport applies the minify and renumber flags on top of the manifest
+func configureExport(c *cli.Context, m *manifest.Manifest) error {
+	if c.IsSet("renumber") {
+		m.Renumber = c.Bool("renumber")
+	}
+	if c.IsSet("minify") {
+		m.Minify = c.Bool("minify")
+	}
+	if c.IsSet("minifier") {
+		m.Minifier = c.String("minifier")
+	}
+
+	if m.Minify {
+		err := luamin.SetMinifier(m.Minifier)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
+
+// parseFilterFlag parses a --filter, which is the signature optionally prefixed by the element class, eg; `DoorUnit:opened(id)`
+func parseFilterFlag(filter string) *manifest.Filter {
+	class := ""
+	if k := strings.Index(filter, ":"); k != -1 && k < strings.Index(filter, "(") {
+		class = filter[:k]
+		filter = filter[k+1:]
+	}
+
+	return &manifest.Filter{
+		Signature: filter,
+		Class:     class,
+	}
+}
+
+// newFilters is the catalogue of filters with the filters of the manifest (and --filter) added to it
+func newFilters(m *manifest.Manifest) (*srcutils.Filters, error) {
+	filters := srcutils.NewFilters()
+	for _, filter := range m.Filters {
+		err := filters.Add(filter.Class, filter.Signature)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid filter in %s", manifest.MANIFEST_FILE)
+		}
+	}
+
+	return filters, nil
+}
+
+func parseToSrc(rep *reporter, m *manifest.Manifest, inputfile string, srcdir string) error {
+	var scriptExport *dustructs.ScriptExport
+	var err error
+
+	if inputfile == STDIO {
+		scriptExport, err = importStdin(rep, m)
+	} else {
+		scriptExport, err = importFile(rep, m, inputfile)
+	}
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	w := srcwriter.NewSrcWriter(scriptExport)
+	w := newSrcWriter(rep, m, scriptExport)
 	err = w.WriteTo(srcdir)
+
+	// the writer only knows the paths within the srcdir
+	name := displayDir(m, srcdir)
+	for _, d := range w.Diagnostics() {
+		d.File = path.Join(name, d.File)
+	}
+	rep.add(w.Diagnostics())
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// newSrcWriter creates a writer for the export, configured with the settings of the manifest
+func newSrcWriter(rep *reporter, m *manifest.Manifest, scriptExport *dustructs.ScriptExport) *srcwriter.SrcWriter {
+	w := srcwriter.NewSrcWriter(scriptExport)
+	w.SetLayout(m.Layout.Slots, m.Layout.Lib)
+	w.SetIndent(string(m.Indent))
+	w.SetLineEnding(m.LineEnding())
+	w.SetProjectFiles([]string{manifest.MANIFEST_FILE, ".git"})
+	w.SetStrict(rep.strict)
+
+	return w
+}
+
+// importer is what the json and the auto configure yaml importers have in common
+type importer interface {
+	SetStrict(strict bool)
+	SetFilters(filters *srcutils.Filters)
+	ReadFrom(inputFile string) (*dustructs.ScriptExport, error)
+	ReadFromReader(r io.Reader) (*dustructs.ScriptExport, error)
+	Diagnostics() []*diagnostics.Diagnostic
+}
+
+// newImporter creates the importer for the format, with the filters of the manifest as known filters
+func newImporter(rep *reporter, m *manifest.Manifest, format string) (importer, error) {
+	filters, err := newFilters(m)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var i importer = jsonimporter.NewImporter()
+	if format == manifest.FORMAT_YAML {
+		i = yamlimporter.NewImporter()
+	}
+	i.SetStrict(rep.strict)
+	i.SetFilters(filters)
+
+	return i, nil
+}
+
+// importFile reads a json file, or an auto configure yaml file when it has a yaml extension
+func importFile(rep *reporter, m *manifest.Manifest, inputfile string) (*dustructs.ScriptExport, error) {
+	i, err := newImporter(rep, m, manifest.FormatFromFile(inputfile))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() { rep.add(i.Diagnostics()) }()
+
+	return i.ReadFrom(inputfile)
+}
+
+// importStdin reads from stdin, there's no file extension to go by so json is detected by its opening `{`
+func importStdin(rep *reporter, m *manifest.Manifest) (*dustructs.ScriptExport, error) {
+	buf, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	format := manifest.FORMAT_YAML
+	if strings.HasPrefix(strings.TrimSpace(string(buf)), "{") {
+		format = manifest.FORMAT_JSON
+	}
+
+	i, err := newImporter(rep, m, format)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() { rep.add(i.Diagnostics()) }()
+
+	return i.ReadFromReader(bytes.NewReader(buf))
+}
+
+func writeOutput(outputfile string, res []byte) error {
+	if outputfile == STDIO {
+		_, err := os.Stdout.Write(res)
+		return errors.WithStack(err)
+	}
+
+	err := ioutil.WriteFile(outputfile, res, 0666)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func readSrc(rep *reporter, m *manifest.Manifest, unit *manifest.Unit, srcdir string) (*srcreader.SrcReader, error) {
+	reader, err := newSrcReader(rep, m, unit, srcdir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	err = reader.Read()
+	rep.add(reader.Diagnostics())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	unit.ApplyToScriptExport(reader.ScriptExport())
+
+	return reader, nil
+}
+
+// newSrcReader creates a reader for the srcdir, configured with the settings of the manifest for the unit
+func newSrcReader(rep *reporter, m *manifest.Manifest, unit *manifest.Unit, srcdir string) (*srcreader.SrcReader, error) {
+	filters, err := newFilters(m)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	reader := srcreader.NewSrcReader(srcdir, m.Minify)
+	reader.SetFilters(filters)
+	reader.SetName(displayDir(m, srcdir))
+	reader.SetStrict(rep.strict)
+	reader.SetRenumber(m.Renumber)
+	reader.SetLayout(m.Layout.Slots, m.Layout.Lib)
+	for slotKey, slot := range unit.Slots {
+		if slot.Class != "" {
+			reader.SetSlotClass(slotKey, slot.Class)
+		}
+	}
+
+	for _, libDir := range m.LibDirs(unit) {
+		reader.AddSharedLib(relToManifest(m, libDir.Path), os.DirFS(libDir.Path), libDir.Include...)
+	}
+	for _, moduleDir := range m.ModuleDirs(unit) {
+		reader.AddModulePath(relToManifest(m, moduleDir), os.DirFS(moduleDir))
+	}
+
+	return reader, nil
+}
+
+// lint reports all problems in the srcdir, it fails when any of them is an error
+func lint(rep *reporter, m *manifest.Manifest, unit *manifest.Unit, srcdir string) error {
+	reader, err := newSrcReader(rep, m, unit, srcdir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	problems, err := reader.Lint()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	rep.add(problems)
+
+	return nil
+}
+
+// diff prints the differences between two exports or source directories,
+// it fails when there are any so it can be used in scripts, the same as `diff`.
+func diff(rep *reporter, m *manifest.Manifest, unit *manifest.Unit, oldInput string, newInput string) error {
+	oldExport, err := loadInput(rep, m, unit, oldInput)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	newExport, err := loadInput(rep, m, unit, newInput)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	d := scriptdiff.Compare(oldExport, newExport)
+
+	err = d.Write(os.Stdout, oldInput, newInput)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if !d.Empty() {
+		return cli.Exit("", 1)
+	}
+
+	return nil
+}
+
+// merge merges the changes from base to ingame into the source directory and prints which files changed,
+// it fails when there are conflicts so they're not missed.
+func merge(rep *reporter, m *manifest.Manifest, baseInput string, ingameInput string, srcdir string) error {
+	base, err := importFile(rep, m, baseInput)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	ingame, err := importFile(rep, m, ingameInput)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	merger := srcmerge.NewSrcMerger(base, ingame)
+	merger.SetLayout(m.Layout.Slots, m.Layout.Lib)
+	merger.SetIndent(string(m.Indent))
+	merger.SetLineEnding(m.LineEnding())
+	merger.SetName(displayDir(m, srcdir))
+	merger.SetOtherName(ingameInput)
+	merger.SetStrict(rep.strict)
+
+	changes, err := merger.MergeInto(srcdir)
+	rep.add(merger.Diagnostics())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	conflicts := 0
+	for _, c := range changes {
+		filePath := path.Join(displayDir(m, srcdir), c.Path)
+		if c.Action == srcmerge.ACTION_RENAME {
+			filePath = path.Join(displayDir(m, srcdir), c.OldPath) + " -> " + filePath
+		}
+
+		switch c.Conflicts {
+		case 0:
+			fmt.Printf("%s: %s\n", c.Action, filePath)
+		case 1:
+			fmt.Printf("%s: %s (1 conflict)\n", c.Action, filePath)
+		default:
+			fmt.Printf("%s: %s (%d conflicts)\n", c.Action, filePath, c.Conflicts)
+		}
+
+		conflicts += c.Conflicts
+	}
+
+	if len(changes) == 0 {
+		fmt.Println("nothing to merge")
+	}
+
+	if conflicts > 0 {
+		return cli.Exit("", 1)
+	}
+
+	return nil
+}
+
+// verify writes the input to a source directory in a temp dir and reads it back, the differences are reported as errors
+func verify(rep *reporter, m *manifest.Manifest, unit *manifest.Unit, input string) error {
+	original, err := loadInput(rep, m, unit, input)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	dir, err := ioutil.TempDir("", "dubby-verify")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w := newSrcWriter(rep, m, original)
+	err = w.WriteTo(dir)
+	rep.add(w.Diagnostics())
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
+	reader, err := newSrcReader(rep, m, unit, dir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	// the files of the temp dir are only referred to by their path in the source directory
+	reader.SetName("")
+
+	// the warnings of reading it back are left out, they're the same as those of the input
+	err = reader.Read()
+	if err != nil {
+		return errors.Wrap(err, "failed to read back the source directory")
+	}
+
+	unit.ApplyToScriptExport(reader.ScriptExport())
+
+	drift := scriptdiff.Drift(original, reader.ScriptExport())
+	for _, d := range drift {
+		d.File = input
+	}
+	rep.add(drift)
+
+	if len(drift) == 0 && rep.format == diagnostics.FORMAT_TEXT {
+		fmt.Printf("%s survives the round trip\n", input)
+	}
+
 	return nil
 }
 
-func exportToJson(srcdir string, outputfile string, minify bool) error {
-	reader := srcreader.NewSrcReader(srcdir, minify)
+// loadInput reads a source directory, or imports a json or auto configure yaml file
+func loadInput(rep *reporter, m *manifest.Manifest, unit *manifest.Unit, input string) (*dustructs.ScriptExport, error) {
+	info, err := os.Stat(input)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if !info.IsDir() {
+		return importFile(rep, m, input)
+	}
+
+	reader, err := readSrc(rep, m, unit, input)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
 
-	err := reader.Read()
+	return reader.ScriptExport(), nil
+}
+
+// displayDir is the path of a directory as it's shown in diagnostics, relative to the manifest
+func displayDir(m *manifest.Manifest, dir string) string {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return dir
+	}
+
+	return relToManifest(m, abs)
+}
+
+// relToManifest makes a path relative to the manifest, to refer to files in messages
+func relToManifest(m *manifest.Manifest, p string) string {
+	rel, err := filepath.Rel(m.Dir(), p)
+	if err != nil {
+		return p
+	}
+
+	return filepath.ToSlash(rel)
+}
+
+func exportToJson(rep *reporter, m *manifest.Manifest, unit *manifest.Unit, srcdir string, outputfile string, sourcemap string) error {
+	reader, err := readSrc(rep, m, unit, srcdir)
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -111,16 +904,157 @@ func exportToJson(srcdir string, outputfile string, minify bool) error {
 		return errors.WithStack(err)
 	}
 
-	err = ioutil.WriteFile(outputfile, res, 0666)
+	err = writeOutput(outputfile, res)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	report := reader.Report()
-	if minify {
-		p := float64(report.SrcLen-report.MinifiedLen) / float64(report.SrcLen) * 100
-		fmt.Printf("minified %d bytes of lua -> %d (%.1f%% saved) \n", report.SrcLen, report.MinifiedLen, p)
+	if sourcemap != "" {
+		err = writeSourceMap(reader.Report().SourceMap, sourcemap)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	if m.Minify {
+		printMinifyReport(reader.Report())
 	}
 
 	return nil
 }
+
+func exportToYaml(rep *reporter, m *manifest.Manifest, unit *manifest.Unit, srcdir string, outputfile string, name string, sourcemap string) error {
+	reader, err := readSrc(rep, m, unit, srcdir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// the name is the one it was imported with, or the name of the source directory
+	if name == "" {
+		name = reader.ScriptExport().Name
+	}
+	if name == "" {
+		abs, err := filepath.Abs(srcdir)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		name = filepath.Base(abs)
+	}
+
+	// the class of a slot comes from its slot file, or from the manifest
+	autoConf, err := dustructs.NewAutoConfFromScriptExport(name, reader.ScriptExport())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	unit.ApplyToAutoConf(reader.ScriptExport(), autoConf)
+
+	res, err := yaml.Marshal(autoConf)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = writeOutput(outputfile, res)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if sourcemap != "" {
+		err = writeSourceMap(reader.Report().SourceMap, sourcemap)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	if m.Minify {
+		printMinifyReport(reader.Report())
+	}
+
+	return nil
+}
+
+// build exports all units to their outputs in the manifest
+func build(rep *reporter, m *manifest.Manifest, units []*manifest.Unit) error {
+	outputs := 0
+	for _, unit := range units {
+		for _, output := range unit.Outputs {
+			var err error
+			switch output.Format {
+			case manifest.FORMAT_JSON:
+				err = exportToJson(rep, m, unit, m.SrcDir(unit), m.Path(output.File), "")
+			case manifest.FORMAT_YAML:
+				err = exportToYaml(rep, m, unit, m.SrcDir(unit), m.Path(output.File), output.Name, "")
+			}
+			if err != nil {
+				return errors.Wrapf(err, "failed to build %s", unit.Name)
+			}
+
+			fmt.Printf("exported %s to %s\n", unit.Name, output.File)
+			outputs++
+		}
+	}
+
+	if outputs == 0 {
+		return errors.Errorf("no outputs in %s", manifest.MANIFEST_FILE)
+	}
+
+	return nil
+}
+
+func watch(rep *reporter, m *manifest.Manifest, unit *manifest.Unit, srcdir string, outputfile string, sourcemap string, debounce time.Duration) error {
+	// the status is printed on stderr so it doesn't end up in the output when watching to stdout
+	rebuild := func() {
+		err := exportToJson(rep, m, unit, srcdir, outputfile, sourcemap)
+		if err != nil {
+			// keep watching, the next save will probably fix it
+			fmt.Fprintf(os.Stderr, "[%s] failed to export\n", time.Now().Format("15:04:05"))
+		} else {
+			fmt.Fprintf(os.Stderr, "[%s] exported %s\n", time.Now().Format("15:04:05"), outputfile)
+		}
+
+		_, err = rep.report(os.Stderr, err)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write diagnostics: %s\n", err)
+		}
+	}
+
+	rebuild()
+
+	w := watcher.NewWatcher(append([]string{
+		filepath.Join(srcdir, m.Layout.Slots),
+		filepath.Join(srcdir, m.Layout.Lib),
+		filepath.Join(srcdir, dustructs.METADATA_FILE),
+	}, m.ModuleDirs(unit)...), 100*time.Millisecond, debounce)
+	for _, libDir := range m.LibDirs(unit) {
+		w.AddPath(libDir.Path)
+	}
+
+	fmt.Fprintf(os.Stderr, "watching %s for changes ...\n", srcdir)
+
+	err := w.Watch(make(chan struct{}), rebuild)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func writeSourceMap(sourceMap *srcreader.SourceMap, outputfile string) error {
+	res, err := json.MarshalIndent(sourceMap, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = ioutil.WriteFile(outputfile, append(res, '\n'), 0666)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func printMinifyReport(report *srcreader.Report) {
+	p := float64(report.SrcLen-report.MinifiedLen) / float64(report.SrcLen) * 100
+	// on stderr so it doesn't end up in the output when exporting to stdout
+	fmt.Fprintf(os.Stderr, "minified %d bytes of lua -> %d (%.1f%% saved) \n", report.SrcLen, report.MinifiedLen, p)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/rubensayshi/dubby/src/diagnostics"
+	"github.com/urfave/cli/v2"
+)
+
+// reporter collects the diagnostics of a command, they're written when the command is done
+type reporter struct {
+	strict      bool
+	format      string
+	diagnostics []*diagnostics.Diagnostic
+}
+
+func newReporter(c *cli.Context) (*reporter, error) {
+	format := c.String("format")
+	switch format {
+	case diagnostics.FORMAT_TEXT, diagnostics.FORMAT_JSON, diagnostics.FORMAT_SARIF:
+	default:
+		return nil, errors.Errorf("unknown format `%s`, expected `%s`, `%s` or `%s`", format, diagnostics.FORMAT_TEXT, diagnostics.FORMAT_JSON, diagnostics.FORMAT_SARIF)
+	}
+
+	return &reporter{
+		strict: c.Bool("strict"),
+		format: format,
+	}, nil
+}
+
+func (r *reporter) add(diagnostics []*diagnostics.Diagnostic) {
+	r.diagnostics = append(r.diagnostics, diagnostics...)
+}
+
+// report writes the diagnostics that have been collected and the error the command failed with (if any),
+// it returns if any of them is an error and starts collecting again from scratch.
+func (r *reporter) report(w io.Writer, err error) (bool, error) {
+	res := r.diagnostics
+	r.diagnostics = nil
+
+	if err != nil {
+		res = append(res, diagnostics.FromError(err))
+	}
+
+	if r.format == diagnostics.FORMAT_TEXT && len(res) == 0 {
+		return false, nil
+	}
+
+	werr := diagnostics.Write(w, r.format, res)
+	if werr != nil {
+		return false, errors.WithStack(werr)
+	}
+
+	return diagnostics.HasErrors(res), nil
+}
+
+// withDiagnostics runs the action of a command and writes its diagnostics to w,
+// the command fails when any of them is an error.
+func withDiagnostics(w io.Writer, action func(c *cli.Context, rep *reporter) error) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		rep, err := newReporter(c)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		err = action(c, rep)
+
+		// an exit code is the result of the command, not an error
+		exitErr, isExit := err.(cli.ExitCoder)
+		if isExit {
+			err = nil
+		}
+
+		hasErrors, err := rep.report(w, err)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if hasErrors {
+			return cli.Exit("", 1)
+		}
+		if isExit {
+			return exitErr
+		}
+
+		return nil
+	}
+}
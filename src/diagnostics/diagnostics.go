@@ -0,0 +1,130 @@
+package diagnostics
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	SEVERITY_ERROR   = "error"
+	SEVERITY_WARNING = "warning"
+)
+
+// CODE_ERROR is the code for errors that don't have a more specific code
+const CODE_ERROR = "error"
+
+// Diagnostic is a problem found by dubby, the file, line and column are empty when they're not known.
+// It's an error too, so it can be returned as one.
+type Diagnostic struct {
+	Severity string `json:"severity"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+}
+
+func (d *Diagnostic) Error() string {
+	pos := d.position()
+	if pos == "" {
+		return d.Message
+	}
+
+	return fmt.Sprintf("%s: %s", pos, d.Message)
+}
+
+// position is `file:line:column`, leaving out what isn't known
+func (d *Diagnostic) position() string {
+	pos := d.File
+	if d.Line > 0 {
+		pos += fmt.Sprintf(":%d", d.Line)
+	}
+	if d.Column > 0 {
+		pos += fmt.Sprintf(":%d", d.Column)
+	}
+
+	return pos
+}
+
+// diagnoser is implemented by errors that know where they are, such as syntax errors
+type diagnoser interface {
+	Diagnostic() *Diagnostic
+}
+
+// FromError turns an error into a diagnostic, errors that don't know where they are only have a message
+func FromError(err error) *Diagnostic {
+	switch cause := errors.Cause(err).(type) {
+	case *Diagnostic:
+		return cause
+	case diagnoser:
+		return cause.Diagnostic()
+	default:
+		return &Diagnostic{
+			Severity: SEVERITY_ERROR,
+			Code:     CODE_ERROR,
+			Message:  err.Error(),
+		}
+	}
+}
+
+// HasErrors checks if any of the diagnostics is an error
+func HasErrors(diagnostics []*Diagnostic) bool {
+	for _, d := range diagnostics {
+		if d.Severity == SEVERITY_ERROR {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Sort sorts the diagnostics by file, line and column
+func Sort(diagnostics []*Diagnostic) {
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		if diagnostics[i].File != diagnostics[j].File {
+			return diagnostics[i].File < diagnostics[j].File
+		}
+		if diagnostics[i].Line != diagnostics[j].Line {
+			return diagnostics[i].Line < diagnostics[j].Line
+		}
+
+		return diagnostics[i].Column < diagnostics[j].Column
+	})
+}
+
+// Collector collects the diagnostics of a reader, writer or importer.
+// With strict, warnings are returned as errors instead of being collected.
+type Collector struct {
+	strict      bool
+	diagnostics []*Diagnostic
+}
+
+// SetStrict makes warnings errors
+func (c *Collector) SetStrict(strict bool) {
+	c.strict = strict
+}
+
+// Diagnostics are the diagnostics that have been collected
+func (c *Collector) Diagnostics() []*Diagnostic {
+	return c.diagnostics
+}
+
+// Warn collects a warning, or returns it as an error with strict
+func (c *Collector) Warn(d *Diagnostic) error {
+	if c.strict {
+		d.Severity = SEVERITY_ERROR
+		return d
+	}
+
+	d.Severity = SEVERITY_WARNING
+	c.diagnostics = append(c.diagnostics, d)
+
+	return nil
+}
+
+// Add collects a diagnostic as it is
+func (c *Collector) Add(d *Diagnostic) {
+	c.diagnostics = append(c.diagnostics, d)
+}
@@ -0,0 +1,96 @@
+package diagnostics
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+type testError struct{}
+
+func (e *testError) Error() string {
+	return "broken"
+}
+
+func (e *testError) Diagnostic() *Diagnostic {
+	return &Diagnostic{Severity: SEVERITY_ERROR, File: "lib/a.lua", Line: 3, Code: "test", Message: "broken"}
+}
+
+func TestFromError(t *testing.T) {
+	assert := require.New(t)
+
+	d := FromError(errors.WithStack(&Diagnostic{Severity: SEVERITY_ERROR, File: "slots/0.screen.lua", Line: 2, Column: 5, Code: "syntax", Message: "oops"}))
+	assert.Equal("slots/0.screen.lua:2:5: oops", d.Error())
+	assert.Equal("syntax", d.Code)
+
+	d = FromError(errors.WithStack(&testError{}))
+	assert.Equal("lib/a.lua:3: broken", d.Error())
+	assert.Equal("test", d.Code)
+
+	d = FromError(errors.Wrap(errors.New("oops"), "failed to build"))
+	assert.Equal("failed to build: oops", d.Error())
+	assert.Equal(CODE_ERROR, d.Code)
+	assert.Equal(SEVERITY_ERROR, d.Severity)
+}
+
+func TestCollector(t *testing.T) {
+	assert := require.New(t)
+
+	c := &Collector{}
+	assert.NoError(c.Warn(&Diagnostic{Code: "test", Message: "careful"}))
+	assert.Equal(1, len(c.Diagnostics()))
+	assert.Equal(SEVERITY_WARNING, c.Diagnostics()[0].Severity)
+	assert.False(HasErrors(c.Diagnostics()))
+
+	// with strict warnings are errors and they're not collected
+	c = &Collector{}
+	c.SetStrict(true)
+	err := c.Warn(&Diagnostic{Code: "test", Message: "careful"})
+	assert.Error(err)
+	assert.Equal(SEVERITY_ERROR, FromError(err).Severity)
+	assert.Equal(0, len(c.Diagnostics()))
+}
+
+func TestWrite(t *testing.T) {
+	assert := require.New(t)
+
+	ds := []*Diagnostic{
+		{Severity: SEVERITY_WARNING, File: "slots/-1.unit.lua", Line: 9, Code: "empty-handler", Message: "handler stop() has no code"},
+		{Severity: SEVERITY_ERROR, File: "lib/a.lua", Line: 1, Column: 4, Code: "syntax", Message: "unexpected symbol"},
+		{Severity: SEVERITY_ERROR, Code: CODE_ERROR, Message: "can't open input file: x.json"},
+	}
+
+	buf := &bytes.Buffer{}
+	assert.NoError(Write(buf, FORMAT_TEXT, ds))
+	assert.Equal(`slots/-1.unit.lua:9: warning: handler stop() has no code [empty-handler]
+lib/a.lua:1:4: error: unexpected symbol [syntax]
+error: can't open input file: x.json
+`, buf.String())
+
+	buf = &bytes.Buffer{}
+	assert.NoError(Write(buf, FORMAT_JSON, nil))
+	assert.Equal("[]\n", buf.String())
+
+	buf = &bytes.Buffer{}
+	assert.NoError(Write(buf, FORMAT_JSON, ds))
+	actual := make([]*Diagnostic, 0)
+	assert.NoError(json.Unmarshal(buf.Bytes(), &actual))
+	assert.Equal(ds, actual)
+
+	buf = &bytes.Buffer{}
+	assert.NoError(Write(buf, FORMAT_SARIF, ds))
+	sarif := &sarifLog{}
+	assert.NoError(json.Unmarshal(buf.Bytes(), sarif))
+	assert.Equal("2.1.0", sarif.Version)
+	assert.Equal(3, len(sarif.Runs[0].Tool.Driver.Rules))
+	assert.Equal(3, len(sarif.Runs[0].Results))
+	assert.Equal("warning", sarif.Runs[0].Results[0].Level)
+	assert.Equal("lib/a.lua", sarif.Runs[0].Results[1].Locations[0].PhysicalLocation.ArtifactLocation.URI)
+	assert.Equal(4, sarif.Runs[0].Results[1].Locations[0].PhysicalLocation.Region.StartColumn)
+	assert.Equal(0, len(sarif.Runs[0].Results[2].Locations))
+
+	assert.Error(Write(buf, "xml", ds))
+}
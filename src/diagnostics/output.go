@@ -0,0 +1,181 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	FORMAT_TEXT  = "text"
+	FORMAT_JSON  = "json"
+	FORMAT_SARIF = "sarif"
+)
+
+// Write writes the diagnostics in the format, text is for humans and json and sarif are for editors and CI
+func Write(w io.Writer, format string, diagnostics []*Diagnostic) error {
+	switch format {
+	case FORMAT_TEXT:
+		return WriteText(w, diagnostics)
+	case FORMAT_JSON:
+		return WriteJSON(w, diagnostics)
+	case FORMAT_SARIF:
+		return WriteSARIF(w, diagnostics)
+	default:
+		return errors.Errorf("unknown format `%s`, expected `%s`, `%s` or `%s`", format, FORMAT_TEXT, FORMAT_JSON, FORMAT_SARIF)
+	}
+}
+
+// WriteText writes a line per diagnostic, eg; `slots/0.screen.lua:3:5: error: unexpected symbol near 'x' [syntax]`
+func WriteText(w io.Writer, diagnostics []*Diagnostic) error {
+	for _, d := range diagnostics {
+		msg := fmt.Sprintf("%s: %s", d.Severity, d.Message)
+		if d.Code != CODE_ERROR {
+			msg += fmt.Sprintf(" [%s]", d.Code)
+		}
+		if pos := d.position(); pos != "" {
+			msg = pos + ": " + msg
+		}
+
+		_, err := fmt.Fprintln(w, msg)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
+
+// WriteJSON writes the diagnostics as a JSON list
+func WriteJSON(w io.Writer, diagnostics []*Diagnostic) error {
+	if diagnostics == nil {
+		diagnostics = []*Diagnostic{}
+	}
+
+	res, err := json.MarshalIndent(diagnostics, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = w.Write(append(res, '\n'))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID string `json:"id"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+// WriteSARIF writes the diagnostics as a SARIF log, which is what most code review tools understand
+func WriteSARIF(w io.Writer, diagnostics []*Diagnostic) error {
+	run := sarifRun{
+		Tool: sarifTool{
+			Driver: sarifDriver{
+				Name:  "dubby",
+				Rules: []sarifRule{},
+			},
+		},
+		Results: []sarifResult{},
+	}
+
+	rules := make(map[string]bool)
+	for _, d := range diagnostics {
+		if !rules[d.Code] {
+			rules[d.Code] = true
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{ID: d.Code})
+		}
+
+		result := sarifResult{
+			RuleID:  d.Code,
+			Level:   d.Severity,
+			Message: sarifMessage{Text: d.Message},
+		}
+
+		if d.File != "" {
+			location := sarifLocation{
+				PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{URI: d.File},
+				},
+			}
+			if d.Line > 0 {
+				location.PhysicalLocation.Region = &sarifRegion{
+					StartLine:   d.Line,
+					StartColumn: d.Column,
+				}
+			}
+			result.Locations = []sarifLocation{location}
+		}
+
+		run.Results = append(run.Results, result)
+	}
+
+	res, err := json.MarshalIndent(&sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs:    []sarifRun{run},
+	}, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = w.Write(append(res, '\n'))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
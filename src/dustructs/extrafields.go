@@ -0,0 +1,196 @@
+package dustructs
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// ExtraField is a JSON field we don't know about
+type ExtraField struct {
+	Name  string
+	Value json.RawMessage
+}
+
+// ExtraFields holds the JSON fields we don't know about in the order they were in, so we can write them back out as-is.
+// It's (un)marshaled as a JSON object.
+type ExtraFields []ExtraField
+
+// Get is the value of a field, nil when it's not there
+func (e ExtraFields) Get(name string) json.RawMessage {
+	for _, field := range e {
+		if field.Name == name {
+			return field.Value
+		}
+	}
+
+	return nil
+}
+
+// Has checks if a field is there
+func (e ExtraFields) Has(name string) bool {
+	for _, field := range e {
+		if field.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Set sets the value of a field, it's added at the end when it's not there yet
+func (e *ExtraFields) Set(name string, value json.RawMessage) {
+	for k, field := range *e {
+		if field.Name == name {
+			(*e)[k].Value = value
+			return
+		}
+	}
+
+	*e = append(*e, ExtraField{Name: name, Value: value})
+}
+
+func (e *ExtraFields) UnmarshalJSON(d []byte) error {
+	if string(bytes.TrimSpace(d)) == "null" {
+		return nil
+	}
+
+	fields, err := unmarshalObjectFields(d)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	*e = fields
+
+	return nil
+}
+
+func (e ExtraFields) MarshalJSON() ([]byte, error) {
+	if e == nil {
+		return []byte("null"), nil
+	}
+
+	return marshalObjectFields(e)
+}
+
+// unmarshalExtraFields collects all fields of a JSON object except for the known fields,
+// returns nil when there are no extra fields.
+func unmarshalExtraFields(d []byte, known ...string) (ExtraFields, error) {
+	fields, err := unmarshalObjectFields(d)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var res ExtraFields
+	for _, field := range fields {
+		if !contains(known, field.Name) {
+			res = append(res, field)
+		}
+	}
+
+	return res, nil
+}
+
+// marshalWithExtraFields marshals v (which should marshal to a JSON object) and adds the extra fields after its fields,
+// known fields take precedence over extra fields with the same name.
+func marshalWithExtraFields(v interface{}, extra ExtraFields) ([]byte, error) {
+	res, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if len(extra) == 0 {
+		return res, nil
+	}
+
+	fields, err := unmarshalObjectFields(res)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for _, field := range extra {
+		if !fields.Has(field.Name) {
+			fields = append(fields, field)
+		}
+	}
+
+	return marshalObjectFields(fields)
+}
+
+// unmarshalObjectFields reads the fields of a JSON object in the order they're in,
+// when a field is in there more than once the last value wins (same as encoding/json).
+func unmarshalObjectFields(d []byte) (ExtraFields, error) {
+	dec := json.NewDecoder(bytes.NewReader(d))
+
+	t, err := dec.Token()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if t != json.Delim('{') {
+		return nil, errors.Errorf("expected a JSON object, got %v", t)
+	}
+
+	fields := make(ExtraFields, 0)
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		name, ok := t.(string)
+		if !ok {
+			return nil, errors.Errorf("expected the name of a field, got %v", t)
+		}
+
+		var value json.RawMessage
+		err = dec.Decode(&value)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		fields.Set(name, value)
+	}
+
+	_, err = dec.Token()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return fields, nil
+}
+
+// marshalObjectFields writes the fields as a JSON object in their order
+func marshalObjectFields(fields ExtraFields) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+	for k, field := range fields {
+		if k > 0 {
+			buf.WriteByte(',')
+		}
+
+		name, err := json.Marshal(field.Name)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+
+		err = json.Compact(buf, field.Value)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
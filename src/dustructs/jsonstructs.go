@@ -3,7 +3,6 @@ package dustructs
 import (
 	"encoding/json"
 	"strconv"
-	"fmt"
 
 	"github.com/pkg/errors"
 )
@@ -14,11 +13,17 @@ const (
 	SLOT_IDX_LIBRARY = -3
 )
 
+// SLOT_IDX_MAX is the last slot elements can be linked to, a programming board (or control unit, screen, etc.)
+// has 10 of them which the game shows as slot1 to slot10 and keys 0 to 9 in its exports.
+const SLOT_IDX_MAX = 9
+
 type ScriptExport struct {
 	Slots    map[int]*Slot
 	Handlers []*Handler
 	Methods  []*Method
 	Events   []*Event
+	Extra    ExtraFields // any fields the game adds that we don't know about
+	Name     string      // the name of the auto configure module, it's not in the JSON format
 }
 
 func NewScriptExport() *ScriptExport {
@@ -43,6 +48,13 @@ type scriptExportJson struct {
 	Events   []*Event         `json:"events"`
 }
 
+type scriptExportJsonOut struct {
+	Slots    map[string]*Slot `json:"slots"` // keys are quoted numbers
+	Handlers []*Handler       `json:"handlers"`
+	Methods  []*Method        `json:"methods"`
+	Events   []*Event         `json:"events"`
+}
+
 func (e *ScriptExport) UnmarshalJSON(d []byte) error {
 	tmp := &scriptExportJson{}
 	err := json.Unmarshal(d, tmp)
@@ -69,18 +81,26 @@ func (e *ScriptExport) UnmarshalJSON(d []byte) error {
 		handlers[k] = &Handler{
 			Code: v.Code,
 			Filter: &Filter{
-				Args: v.Filter.Args,
-				Signature: v.Filter.Signature, 
-				SlotKey: int(slotKey),
-			}, 
-			Key: int(key),
+				Args:      v.Filter.Args,
+				Signature: v.Filter.Signature,
+				SlotKey:   int(slotKey),
+				Extra:     v.Filter.Extra,
+			},
+			Key:   int(key),
+			Extra: v.Extra,
 		}
 	}
 
+	extra, err := unmarshalExtraFields(d, "slots", "handlers", "methods", "events")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
 	e.Slots = slots
 	e.Handlers = handlers
 	e.Methods = tmp.Methods
 	e.Events = tmp.Events
+	e.Extra = extra
 
 	return nil
 }
@@ -92,26 +112,13 @@ func (e *ScriptExport) MarshalJSON() ([]byte, error) {
 		slots[kstr] = v
 	}
 
-	handlers := make([]*handlerRaw, len(e.Handlers))
-	for k, v := range e.Handlers {
-		handlers[k] = &handlerRaw{
-			Code: v.Code,
-			Filter: &filterRaw{
-				Args: v.Filter.Args,
-				Signature: v.Filter.Signature,
-				SlotKey: json.Number(fmt.Sprintf("%d", v.Filter.SlotKey)),
-			},
-			Key: json.Number(fmt.Sprintf("%d", v.Key)),
-		}
-	}
-
-	tmp := &scriptExportJson{
+	tmp := &scriptExportJsonOut{
 		Slots:    slots,
-		Handlers: handlers,
+		Handlers: e.Handlers,
 		Methods:  e.Methods,
 		Events:   e.Events,
 	}
-	res, err := json.Marshal(tmp)
+	res, err := marshalWithExtraFields(tmp, e.Extra)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -120,8 +127,32 @@ func (e *ScriptExport) MarshalJSON() ([]byte, error) {
 }
 
 type Slot struct {
-	Name string `json:"name"`
-	Type *Type  `json:"type"`
+	Name   string      `json:"name"`
+	Type   *Type       `json:"type"`
+	Class  string      `json:"-"` // the element class of the slot, only the auto configure format has it
+	Select string      `json:"-"` // how the auto configure module selects the element for the slot, eg; `all` or `manual`
+	Extra  ExtraFields `json:"-"` // any fields the game adds that we don't know about
+}
+
+type slotJson Slot
+
+func (s *Slot) UnmarshalJSON(d []byte) error {
+	tmp := (*slotJson)(s)
+	err := json.Unmarshal(d, tmp)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	s.Extra, err = unmarshalExtraFields(d, "name", "type")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (s Slot) MarshalJSON() ([]byte, error) {
+	return marshalWithExtraFields((slotJson)(s), s.Extra)
 }
 
 func NewSlot(name string) *Slot {
@@ -132,8 +163,30 @@ func NewSlot(name string) *Slot {
 }
 
 type Type struct {
-	Events  []Event  `json:"events"`
-	Methods []Method `json:"methods"`
+	Events  []Event     `json:"events"`
+	Methods []Method    `json:"methods"`
+	Extra   ExtraFields `json:"-"` // any fields the game adds that we don't know about
+}
+
+type typeJson Type
+
+func (t *Type) UnmarshalJSON(d []byte) error {
+	tmp := (*typeJson)(t)
+	err := json.Unmarshal(d, tmp)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	t.Extra, err = unmarshalExtraFields(d, "events", "methods")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (t Type) MarshalJSON() ([]byte, error) {
+	return marshalWithExtraFields((typeJson)(t), t.Extra)
 }
 
 func NewType() *Type {
@@ -144,35 +197,164 @@ func NewType() *Type {
 }
 
 type Event struct {
+	Name  string      `json:"name"`
+	Args  []Arg       `json:"args"`
+	Extra ExtraFields `json:"-"` // any fields the game adds that we don't know about
+}
+
+type eventJson Event
+
+func (e *Event) UnmarshalJSON(d []byte) error {
+	tmp := (*eventJson)(e)
+	err := json.Unmarshal(d, tmp)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if e.Args == nil {
+		e.Args = make([]Arg, 0)
+	}
+
+	e.Extra, err = unmarshalExtraFields(d, "name", "args")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (e Event) MarshalJSON() ([]byte, error) {
+	return marshalWithExtraFields((eventJson)(e), e.Extra)
 }
 
 type Method struct {
+	Name  string      `json:"name"`
+	Args  []Arg       `json:"args"`
+	Code  string      `json:"code"`
+	Extra ExtraFields `json:"-"` // any fields the game adds that we don't know about
+}
+
+type methodJson Method
+
+func (m *Method) UnmarshalJSON(d []byte) error {
+	tmp := (*methodJson)(m)
+	err := json.Unmarshal(d, tmp)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if m.Args == nil {
+		m.Args = make([]Arg, 0)
+	}
+
+	m.Extra, err = unmarshalExtraFields(d, "name", "args", "code")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (m Method) MarshalJSON() ([]byte, error) {
+	return marshalWithExtraFields((methodJson)(m), m.Extra)
 }
 
 type Handler struct {
-	Code   string `json:"code"`
-	Filter *Filter `json:"filter"`
-	Key    int    `json:"key,string"`
+	Code   string      `json:"code"`
+	Filter *Filter     `json:"filter"`
+	Key    int         `json:"key,string"`
+	Extra  ExtraFields `json:"-"` // any fields the game adds that we don't know about
+}
+
+type handlerJson Handler
+
+func (h Handler) MarshalJSON() ([]byte, error) {
+	return marshalWithExtraFields((handlerJson)(h), h.Extra)
 }
 
 type handlerRaw struct {
-	Code   string `json:"code"`
-	Filter *filterRaw `json:"filter"`
-	Key    json.Number    `json:"key"` // can be quoted and unquoted
+	Code   string      `json:"code"`
+	Filter *filterRaw  `json:"filter"`
+	Key    json.Number `json:"key"` // can be quoted and unquoted
+	Extra  ExtraFields `json:"-"`
+}
+
+type handlerRawJson handlerRaw
+
+func (h *handlerRaw) UnmarshalJSON(d []byte) error {
+	tmp := (*handlerRawJson)(h)
+	err := json.Unmarshal(d, tmp)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	h.Extra, err = unmarshalExtraFields(d, "code", "filter", "key")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
 }
 
 type Filter struct {
-	Args      []Arg  `json:"args"`
-	Signature string `json:"signature"`
-	SlotKey   int    `json:"slotKey,string"`
+	Args      []Arg       `json:"args"`
+	Signature string      `json:"signature"`
+	SlotKey   int         `json:"slotKey,string"`
+	Extra     ExtraFields `json:"-"` // any fields the game adds that we don't know about
+}
+
+type filterJson Filter
+
+func (f Filter) MarshalJSON() ([]byte, error) {
+	return marshalWithExtraFields((filterJson)(f), f.Extra)
 }
 
 type filterRaw struct {
-	Args      []Arg  `json:"args"`
-	Signature string `json:"signature"`
-	SlotKey   json.Number    `json:"slotKey"`  // can be quoted and unquoted
+	Args      []Arg       `json:"args"`
+	Signature string      `json:"signature"`
+	SlotKey   json.Number `json:"slotKey"` // can be quoted and unquoted
+	Extra     ExtraFields `json:"-"`
+}
+
+type filterRawJson filterRaw
+
+func (f *filterRaw) UnmarshalJSON(d []byte) error {
+	tmp := (*filterRawJson)(f)
+	err := json.Unmarshal(d, tmp)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	f.Extra, err = unmarshalExtraFields(d, "args", "signature", "slotKey")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
 }
 
 type Arg struct {
-	Value string `json:"value"`
+	Value string      `json:"value"`
+	Extra ExtraFields `json:"-"` // any fields the game adds that we don't know about
+}
+
+type argJson Arg
+
+func (a *Arg) UnmarshalJSON(d []byte) error {
+	tmp := (*argJson)(a)
+	err := json.Unmarshal(d, tmp)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	a.Extra, err = unmarshalExtraFields(d, "value")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (a Arg) MarshalJSON() ([]byte, error) {
+	return marshalWithExtraFields((argJson)(a), a.Extra)
 }
@@ -24,3 +24,86 @@ func TestParseJson(t *testing.T) {
 	assert.Equal("unit", export.Slots[SLOT_IDX_UNIT].Name)
 	assert.Equal("-- !DU: main\nfunction yeeehaaaa() end\n", export.Handlers[1].Code)
 }
+
+func TestJsonRoundTrip(t *testing.T) {
+	assert := require.New(t)
+
+	f, err := ioutil.ReadFile(path.Join(utils.ROOT, "testvectors", "testvector2", "input.json"))
+	assert.NoError(err)
+
+	export := &ScriptExport{}
+	err = json.Unmarshal(f, export)
+	assert.NoError(err)
+
+	assert.Equal("mouseDown", export.Slots[0].Type.Events[0].Name)
+	assert.Equal("y", export.Slots[0].Type.Events[0].Args[1].Value)
+	assert.Equal(`"mouseDown(x,y)"`, string(export.Slots[0].Type.Events[0].Extra.Get("signature")))
+	assert.Equal("setHTML", export.Slots[0].Type.Methods[0].Name)
+	assert.Equal("greet", export.Methods[0].Name)
+	assert.Equal("greeted", export.Events[0].Name)
+
+	res, err := json.Marshal(export)
+	assert.NoError(err)
+
+	roundTripped := &ScriptExport{}
+	err = json.Unmarshal(res, roundTripped)
+	assert.NoError(err)
+
+	assert.Equal(export, roundTripped)
+	assert.JSONEq(string(f), string(res))
+}
+
+func TestJsonRoundTripExtraFields(t *testing.T) {
+	assert := require.New(t)
+
+	f, err := ioutil.ReadFile(path.Join(utils.ROOT, "testvectors", "testvector3", "input.json"))
+	assert.NoError(err)
+
+	export := &ScriptExport{}
+	err = json.Unmarshal(f, export)
+	assert.NoError(err)
+
+	assert.Equal(`"1.0"`, string(export.Extra.Get("version")))
+	assert.Equal(`true`, string(export.Slots[SLOT_IDX_UNIT].Extra.Get("autoconf")))
+	assert.Equal(`{}`, string(export.Slots[SLOT_IDX_UNIT].Type.Extra.Get("properties")))
+	assert.Equal(`false`, string(export.Handlers[1].Extra.Get("disabled")))
+	assert.Equal(`"element"`, string(export.Handlers[1].Filter.Extra.Get("kind")))
+	assert.Equal(`"string"`, string(export.Handlers[1].Filter.Args[0].Extra.Get("type")))
+
+	res, err := json.Marshal(export)
+	assert.NoError(err)
+
+	assert.JSONEq(string(f), string(res))
+}
+
+func TestJsonExtraFieldsOrder(t *testing.T) {
+	assert := require.New(t)
+
+	// the known fields come first in the order of the struct, then the extra fields in the order they were in
+	input := `{"zeta":1,"value":"x","alpha":{"b":2,"a":1},"mid":"m"}`
+
+	arg := &Arg{}
+	err := json.Unmarshal([]byte(input), arg)
+	assert.NoError(err)
+	assert.Equal(ExtraFields{
+		{Name: "zeta", Value: json.RawMessage(`1`)},
+		{Name: "alpha", Value: json.RawMessage(`{"b":2,"a":1}`)},
+		{Name: "mid", Value: json.RawMessage(`"m"`)},
+	}, arg.Extra)
+
+	res, err := json.Marshal(arg)
+	assert.NoError(err)
+	assert.Equal(`{"value":"x","zeta":1,"alpha":{"b":2,"a":1},"mid":"m"}`, string(res))
+
+	// same for the metadata file
+	metadata := NewMetadata()
+	metadata.Extra = arg.Extra
+	res, err = json.Marshal(metadata)
+	assert.NoError(err)
+	assert.Contains(string(res), `"extra":{"zeta":1,"alpha":{"b":2,"a":1},"mid":"m"}`)
+
+	roundTripped := NewMetadata()
+	err = json.Unmarshal(res, roundTripped)
+	assert.NoError(err)
+	assert.Equal(arg.Extra, roundTripped.Extra)
+}
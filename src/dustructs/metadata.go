@@ -0,0 +1,232 @@
+package dustructs
+
+import (
+	"fmt"
+	"sort"
+)
+
+const METADATA_FILE = "metadata.json"
+
+// Metadata holds the parts of a ScriptExport which don't have a place in the lua source files,
+// it's stored as a sidecar file in the source directory so we don't lose them when parsing and exporting again.
+type Metadata struct {
+	Slots    map[int]*SlotMetadata `json:"slots,omitempty"`
+	Handlers []*HandlerMetadata    `json:"handlers,omitempty"`
+	Methods  []*Method             `json:"methods,omitempty"`
+	Events   []*Event              `json:"events,omitempty"`
+	Extra    ExtraFields           `json:"extra,omitempty"`
+	Name     string                `json:"name,omitempty"` // the name of the auto configure module
+}
+
+// SlotMetadata has the parts of a slot that aren't in its slot file, the class of a slot is in its slot file.
+type SlotMetadata struct {
+	Name   string      `json:"name"`
+	Type   *Type       `json:"type,omitempty"`
+	Select string      `json:"select,omitempty"`
+	Extra  ExtraFields `json:"extra,omitempty"`
+}
+
+// HandlerMetadata is matched to a handler by its slot, filter and the how many'th handler with that filter it is,
+// because the handler keys are renumbered when reading the source files.
+// Key is the original key of the handler, it's only set when it's not the key the handler gets when it's read.
+type HandlerMetadata struct {
+	SlotKey     int           `json:"slotKey"`
+	Filter      string        `json:"filter"`
+	N           int           `json:"n"`
+	Key         *int          `json:"key,omitempty"`
+	Signature   string        `json:"signature,omitempty"` // only set when it differs from the filter
+	Extra       ExtraFields   `json:"extra,omitempty"`
+	FilterExtra ExtraFields   `json:"filterExtra,omitempty"`
+	ArgsExtra   []ExtraFields `json:"argsExtra,omitempty"`
+}
+
+func NewMetadata() *Metadata {
+	return &Metadata{
+		Slots:    make(map[int]*SlotMetadata),
+		Handlers: make([]*HandlerMetadata, 0),
+		Methods:  make([]*Method, 0),
+		Events:   make([]*Event, 0),
+	}
+}
+
+// NewMetadataFromScriptExport takes the handlers in the order they're read from the source files (which is what N is counted by),
+// readKeys are the keys they get when they're read and the original key of a handler is recorded when it's different.
+func NewMetadataFromScriptExport(e *ScriptExport, readKeys map[*Handler]int) *Metadata {
+	m := NewMetadata()
+
+	for slotKey, slot := range e.Slots {
+		hasType := slot.Type != nil && (len(slot.Type.Events) > 0 || len(slot.Type.Methods) > 0 || len(slot.Type.Extra) > 0)
+		if hasType || len(slot.Extra) > 0 || slot.Select != "" {
+			slotMetadata := &SlotMetadata{
+				Name:   slot.Name,
+				Select: slot.Select,
+				Extra:  slot.Extra,
+			}
+			if hasType {
+				slotMetadata.Type = slot.Type
+			}
+
+			m.Slots[slotKey] = slotMetadata
+		}
+	}
+
+	n := make(map[string]int)
+	for _, handler := range e.Handlers {
+		filter, key := handlerMetadataKey(handler)
+		handlerN := n[key]
+		n[key]++
+
+		handlerMetadata := &HandlerMetadata{
+			SlotKey:     handler.Filter.SlotKey,
+			Filter:      filter,
+			N:           handlerN,
+			Extra:       handler.Extra,
+			FilterExtra: handler.Filter.Extra,
+		}
+
+		if handler.Filter.Signature != filter {
+			handlerMetadata.Signature = handler.Filter.Signature
+		}
+
+		if readKey, ok := readKeys[handler]; ok && readKey != handler.Key {
+			key := handler.Key
+			handlerMetadata.Key = &key
+		}
+
+		for k, arg := range handler.Filter.Args {
+			if len(arg.Extra) > 0 {
+				if handlerMetadata.ArgsExtra == nil {
+					handlerMetadata.ArgsExtra = make([]ExtraFields, len(handler.Filter.Args))
+				}
+				handlerMetadata.ArgsExtra[k] = arg.Extra
+			}
+		}
+
+		if handlerMetadata.Key != nil || handlerMetadata.Signature != "" || len(handlerMetadata.Extra) > 0 || len(handlerMetadata.FilterExtra) > 0 || handlerMetadata.ArgsExtra != nil {
+			m.Handlers = append(m.Handlers, handlerMetadata)
+		}
+	}
+
+	m.Methods = append(m.Methods, e.Methods...)
+	m.Events = append(m.Events, e.Events...)
+	m.Extra = e.Extra
+	m.Name = e.Name
+
+	return m
+}
+
+// IsEmpty is true when there's nothing in the metadata that isn't already in the lua source files
+func (m *Metadata) IsEmpty() bool {
+	return len(m.Slots) == 0 && len(m.Handlers) == 0 && len(m.Methods) == 0 && len(m.Events) == 0 && len(m.Extra) == 0 && m.Name == ""
+}
+
+// ApplyTo puts the metadata back into a ScriptExport that was read from the lua source files
+func (m *Metadata) ApplyTo(e *ScriptExport) {
+	for slotKey, slotMetadata := range m.Slots {
+		slot := e.Slots[slotKey]
+		if slot == nil {
+			slot = NewSlot(slotMetadata.Name)
+			e.Slots[slotKey] = slot
+		}
+
+		if slotMetadata.Type != nil {
+			slot.Type = slotMetadata.Type
+		}
+		slot.Select = slotMetadata.Select
+		slot.Extra = slotMetadata.Extra
+	}
+
+	handlersMetadata := make(map[string]*HandlerMetadata, len(m.Handlers))
+	for _, handlerMetadata := range m.Handlers {
+		key := fmt.Sprintf("%d:%s:%d", handlerMetadata.SlotKey, handlerMetadata.Filter, handlerMetadata.N)
+		handlersMetadata[key] = handlerMetadata
+	}
+
+	restored := make(map[*Handler]bool)
+	n := make(map[string]int)
+	for _, handler := range e.Handlers {
+		_, key := handlerMetadataKey(handler)
+		handlerN := n[key]
+		n[key]++
+
+		handlerMetadata := handlersMetadata[fmt.Sprintf("%s:%d", key, handlerN)]
+		if handlerMetadata == nil {
+			continue
+		}
+
+		if handlerMetadata.Key != nil {
+			handler.Key = *handlerMetadata.Key
+			restored[handler] = true
+		}
+
+		if handlerMetadata.Signature != "" {
+			handler.Filter.Signature = handlerMetadata.Signature
+		}
+		handler.Extra = handlerMetadata.Extra
+		handler.Filter.Extra = handlerMetadata.FilterExtra
+		for k, argExtra := range handlerMetadata.ArgsExtra {
+			if k < len(handler.Filter.Args) {
+				handler.Filter.Args[k].Extra = argExtra
+			}
+		}
+	}
+
+	restoreOrder(e, restored)
+
+	e.Methods = append(e.Methods, m.Methods...)
+	e.Events = append(e.Events, m.Events...)
+	e.Extra = m.Extra
+	e.Name = m.Name
+}
+
+// restoreOrder puts the handlers back in the order of their keys after some of them got their original key back,
+// a handler that ends up with the same key as a restored one (eg; it's been added to the source files since) gets a new key after the last one.
+func restoreOrder(e *ScriptExport, restored map[*Handler]bool) {
+	if len(restored) == 0 {
+		return
+	}
+
+	last := 0
+	for _, handler := range e.Handlers {
+		if handler.Key > last {
+			last = handler.Key
+		}
+	}
+
+	used := make(map[int]bool)
+	for _, restoredFirst := range []bool{true, false} {
+		for _, handler := range e.Handlers {
+			if restored[handler] != restoredFirst {
+				continue
+			}
+
+			if used[handler.Key] {
+				last++
+				handler.Key = last
+			}
+			used[handler.Key] = true
+		}
+	}
+
+	sort.SliceStable(e.Handlers, func(i, j int) bool {
+		return e.Handlers[i].Key < e.Handlers[j].Key
+	})
+}
+
+// handlerMetadataKey returns the filter as it's written in the source files, eg; `tick([Live])`,
+// and the key to match the handler to its metadata with (without the N)
+func handlerMetadataKey(handler *Handler) (string, string) {
+	args := make([]string, len(handler.Filter.Args))
+	for k, arg := range handler.Filter.Args {
+		args[k] = arg.Value
+	}
+
+	filterName, err := filterNameFromSignature(handler.Filter.Signature)
+	if err != nil {
+		filterName = handler.Filter.Signature
+	}
+
+	filter := signatureFromFilterName(filterName, args)
+
+	return filter, fmt.Sprintf("%d:%s", handler.Filter.SlotKey, filter)
+}
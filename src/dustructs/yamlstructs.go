@@ -0,0 +1,347 @@
+package dustructs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const AUTOCONF_SELECT_MANUAL = "manual"
+
+// AutoConf is the YAML format which the game uses for the auto configure modules
+type AutoConf struct {
+	Name     string
+	Slots    []*AutoConfSlot
+	Handlers []*AutoConfHandler
+}
+
+type AutoConfSlot struct {
+	Name   string
+	Class  string
+	Select string
+}
+
+type AutoConfHandler struct {
+	Slot   string
+	Filter string
+	Args   []string
+	Lua    string
+}
+
+type autoConfSlotYaml struct {
+	Class  string `yaml:"class,omitempty"`
+	Select string `yaml:"select,omitempty"`
+}
+
+type autoConfHandlerYaml struct {
+	Args []string `yaml:"args,flow,omitempty"`
+	Lua  string   `yaml:"lua"`
+}
+
+func NewAutoConfFromScriptExport(name string, e *ScriptExport) (*AutoConf, error) {
+	a := &AutoConf{
+		Name:     name,
+		Slots:    make([]*AutoConfSlot, 0),
+		Handlers: make([]*AutoConfHandler, 0),
+	}
+
+	slotKeys := SortedSlotKeys(e.Slots)
+
+	// the default slots are implicit in the auto configure format
+	for _, slotKey := range slotKeys {
+		if slotKey < 0 {
+			continue
+		}
+
+		a.Slots = append(a.Slots, &AutoConfSlot{
+			Name:   e.Slots[slotKey].Name,
+			Class:  e.Slots[slotKey].Class,
+			Select: e.Slots[slotKey].Select,
+		})
+	}
+
+	handlers := make([]*Handler, len(e.Handlers))
+	copy(handlers, e.Handlers)
+	sort.SliceStable(handlers, func(i, j int) bool {
+		return handlers[i].Key < handlers[j].Key
+	})
+
+	for _, handler := range handlers {
+		if e.Slots[handler.Filter.SlotKey] == nil {
+			return nil, errors.Errorf("handler [%d] for unknown slot [%d]", handler.Key, handler.Filter.SlotKey)
+		}
+	}
+
+	// handlers are grouped per slot, so we add them in the order of the slots
+	for _, slotKey := range slotKeys {
+		for _, handler := range handlers {
+			if handler.Filter.SlotKey != slotKey {
+				continue
+			}
+
+			filter, err := filterNameFromSignature(handler.Filter.Signature)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+
+			args := make([]string, len(handler.Filter.Args))
+			for k, arg := range handler.Filter.Args {
+				args[k] = arg.Value
+			}
+
+			a.Handlers = append(a.Handlers, &AutoConfHandler{
+				Slot:   e.Slots[slotKey].Name,
+				Filter: filter,
+				Args:   args,
+				Lua:    handler.Code,
+			})
+		}
+	}
+
+	return a, nil
+}
+
+// SetSlotClass sets the element class of a slot, which the game uses to link the slot
+func (a *AutoConf) SetSlotClass(slotName string, class string) {
+	for _, slot := range a.Slots {
+		if slot.Name == slotName {
+			slot.Class = class
+		}
+	}
+}
+
+func (a *AutoConf) MarshalYAML() (interface{}, error) {
+	slots := yaml.MapSlice{}
+	for _, slot := range a.Slots {
+		// without a class the game can't link the slot by itself, so it has to be selected manually
+		selectSlot := slot.Select
+		if slot.Class == "" && selectSlot == "" {
+			selectSlot = AUTOCONF_SELECT_MANUAL
+		}
+
+		slots = append(slots, yaml.MapItem{
+			Key: slot.Name,
+			Value: &autoConfSlotYaml{
+				Class:  slot.Class,
+				Select: selectSlot,
+			},
+		})
+	}
+
+	// handlers are grouped per slot, the same filter can occur multiple times within a slot
+	handlers := yaml.MapSlice{}
+	slotHandlers := make(map[string]int)
+	for _, handler := range a.Handlers {
+		idx, ok := slotHandlers[handler.Slot]
+		if !ok {
+			idx = len(handlers)
+			slotHandlers[handler.Slot] = idx
+			handlers = append(handlers, yaml.MapItem{Key: handler.Slot, Value: yaml.MapSlice{}})
+		}
+
+		handlers[idx].Value = append(handlers[idx].Value.(yaml.MapSlice), yaml.MapItem{
+			Key: handler.Filter,
+			Value: &autoConfHandlerYaml{
+				Args: handler.Args,
+				Lua:  handler.Lua,
+			},
+		})
+	}
+
+	return yaml.MapSlice{
+		{Key: "name", Value: a.Name},
+		{Key: "slots", Value: slots},
+		{Key: "handlers", Value: handlers},
+	}, nil
+}
+
+type autoConfYaml struct {
+	Name     string        `yaml:"name"`
+	Slots    yaml.MapSlice `yaml:"slots"`
+	Handlers yaml.MapSlice `yaml:"handlers"` // filters can occur multiple times within a slot, so we need to preserve duplicate keys
+}
+
+func (a *AutoConf) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	tmp := &autoConfYaml{}
+	err := unmarshal(tmp)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	slots := make([]*AutoConfSlot, 0, len(tmp.Slots))
+	for _, item := range tmp.Slots {
+		slotName, ok := item.Key.(string)
+		if !ok {
+			return errors.Errorf("slot name should be a string: %v", item.Key)
+		}
+
+		slot := &AutoConfSlot{
+			Name: slotName,
+		}
+
+		if item.Value != nil {
+			values, ok := item.Value.(yaml.MapSlice)
+			if !ok {
+				return errors.Errorf("slot should be a map: %s", slotName)
+			}
+
+			for _, value := range values {
+				switch value.Key {
+				case "class":
+					slot.Class = fmt.Sprintf("%v", value.Value)
+				case "select":
+					slot.Select = fmt.Sprintf("%v", value.Value)
+				}
+			}
+		}
+
+		slots = append(slots, slot)
+	}
+
+	handlers := make([]*AutoConfHandler, 0)
+	for _, slotItem := range tmp.Handlers {
+		slotName, ok := slotItem.Key.(string)
+		if !ok {
+			return errors.Errorf("slot name should be a string: %v", slotItem.Key)
+		}
+
+		filters, ok := slotItem.Value.(yaml.MapSlice)
+		if !ok {
+			return errors.Errorf("handlers for slot should be a map: %s", slotName)
+		}
+
+		for _, filterItem := range filters {
+			filter, ok := filterItem.Key.(string)
+			if !ok {
+				return errors.Errorf("filter should be a string: %v", filterItem.Key)
+			}
+
+			handler := &AutoConfHandler{
+				Slot:   slotName,
+				Filter: filter,
+				Args:   []string{},
+			}
+
+			values, ok := filterItem.Value.(yaml.MapSlice)
+			if !ok {
+				return errors.Errorf("handler should be a map: %s.%s", slotName, filter)
+			}
+
+			for _, value := range values {
+				switch value.Key {
+				case "lua":
+					lua, ok := value.Value.(string)
+					if !ok && value.Value != nil {
+						return errors.Errorf("lua should be a string: %s.%s", slotName, filter)
+					}
+					handler.Lua = lua
+				case "args":
+					args, ok := value.Value.([]interface{})
+					if !ok {
+						return errors.Errorf("args should be a list: %s.%s", slotName, filter)
+					}
+					for _, arg := range args {
+						handler.Args = append(handler.Args, fmt.Sprintf("%v", arg))
+					}
+				}
+			}
+
+			handlers = append(handlers, handler)
+		}
+	}
+
+	a.Name = tmp.Name
+	a.Slots = slots
+	a.Handlers = handlers
+
+	return nil
+}
+
+func (a *AutoConf) ToScriptExport() (*ScriptExport, error) {
+	e := NewScriptExport()
+	e.Name = a.Name
+
+	slotKeys := map[string]int{
+		e.Slots[SLOT_IDX_UNIT].Name:    SLOT_IDX_UNIT,
+		e.Slots[SLOT_IDX_SYSTEM].Name:  SLOT_IDX_SYSTEM,
+		e.Slots[SLOT_IDX_LIBRARY].Name: SLOT_IDX_LIBRARY,
+	}
+
+	for k, slot := range a.Slots {
+		if _, ok := slotKeys[slot.Name]; ok {
+			return nil, errors.Errorf("duplicate slot name: %s", slot.Name)
+		}
+
+		e.Slots[k] = NewSlot(slot.Name)
+		e.Slots[k].Class = slot.Class
+		e.Slots[k].Select = slot.Select
+		slotKeys[slot.Name] = k
+	}
+
+	for k, handler := range a.Handlers {
+		slotKey, ok := slotKeys[handler.Slot]
+		if !ok {
+			return nil, errors.Errorf("handler for unknown slot: %s", handler.Slot)
+		}
+
+		args := make([]Arg, len(handler.Args))
+		for k, arg := range handler.Args {
+			args[k] = Arg{Value: arg}
+		}
+
+		e.Handlers = append(e.Handlers, &Handler{
+			Code: handler.Lua,
+			Filter: &Filter{
+				Args:      args,
+				Signature: signatureFromFilterName(handler.Filter, handler.Args),
+				SlotKey:   slotKey,
+			},
+			Key: k + 1,
+		})
+	}
+
+	return e, nil
+}
+
+// signatureFromFilterName formats the signature the same way the source files do, eg; `tick` + `Live` -> `tick([Live])`
+func signatureFromFilterName(filter string, args []string) string {
+	if len(args) == 0 {
+		return fmt.Sprintf("%s()", filter)
+	}
+
+	return fmt.Sprintf("%s([%s])", filter, strings.Join(args, ", "))
+}
+
+// filterNameFromSignature strips the args off of a signature, eg; `tick([Live])` -> `tick`
+func filterNameFromSignature(signature string) (string, error) {
+	idx := strings.Index(signature, "(")
+	if idx <= 0 {
+		return "", errors.Errorf("Signature does not match expected pattern: %s", signature)
+	}
+
+	return strings.TrimSpace(signature[:idx]), nil
+}
+
+// SortedSlotKeys sorts the slots in the order the game lists them; unit, system, library and then the linked slots
+func SortedSlotKeys(slots map[int]*Slot) []int {
+	keys := make([]int, 0, len(slots))
+	for k := range slots {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i] < 0 && keys[j] < 0 {
+			return keys[i] > keys[j]
+		}
+		if keys[i] < 0 || keys[j] < 0 {
+			return keys[i] < 0
+		}
+
+		return keys[i] < keys[j]
+	})
+
+	return keys
+}
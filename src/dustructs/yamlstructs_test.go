@@ -0,0 +1,89 @@
+package dustructs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path"
+	"testing"
+
+	"github.com/rubensayshi/dubby/src/utils"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestMarshalAutoConf(t *testing.T) {
+	assert := require.New(t)
+
+	f, err := ioutil.ReadFile(path.Join(utils.ROOT, "testvectors", "testvector1", "input.json"))
+	assert.NoError(err)
+
+	export := &ScriptExport{}
+	err = json.Unmarshal(f, export)
+	assert.NoError(err)
+
+	autoConf, err := NewAutoConfFromScriptExport("testvector1", export)
+	assert.NoError(err)
+
+	actual, err := yaml.Marshal(autoConf)
+	assert.NoError(err)
+
+	expected, err := ioutil.ReadFile(path.Join(utils.ROOT, "testvectors", "testvector1", "autoconf.yaml"))
+	assert.NoError(err)
+
+	assert.Equal(string(expected), string(actual))
+}
+
+func TestMarshalAutoConfSlots(t *testing.T) {
+	assert := require.New(t)
+
+	export := NewScriptExport()
+	export.Slots[0] = NewSlot("screen")
+	export.Handlers = append(export.Handlers, &Handler{
+		Code:   "render()",
+		Filter: &Filter{Signature: "mouseDown(x, y)", Args: []Arg{{Value: "*"}, {Value: "*"}}, SlotKey: 0},
+		Key:    1,
+	})
+
+	autoConf, err := NewAutoConfFromScriptExport("screens", export)
+	assert.NoError(err)
+
+	actual, err := yaml.Marshal(autoConf)
+	assert.NoError(err)
+
+	assert.Equal(`name: screens
+slots:
+  screen:
+    select: manual
+handlers:
+  screen:
+    mouseDown:
+      args: ['*', '*']
+      lua: render()
+`, string(actual))
+}
+
+func TestMarshalAutoConfSlotClass(t *testing.T) {
+	assert := require.New(t)
+
+	export := NewScriptExport()
+	export.Slots[0] = NewSlot("screen")
+	export.Slots[1] = NewSlot("door")
+
+	autoConf, err := NewAutoConfFromScriptExport("screens", export)
+	assert.NoError(err)
+
+	// only the slot without a class has to be selected manually
+	autoConf.SetSlotClass("screen", "ScreenUnit")
+
+	actual, err := yaml.Marshal(autoConf)
+	assert.NoError(err)
+
+	assert.Equal(`name: screens
+slots:
+  screen:
+    class: ScreenUnit
+  door:
+    select: manual
+handlers: {}
+`, string(actual))
+}
@@ -0,0 +1,120 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// JSON-RPC error codes that we use
+const (
+	CODE_PARSE_ERROR      = -32700
+	CODE_INVALID_PARAMS   = -32602
+	CODE_METHOD_NOT_FOUND = -32601
+	CODE_INTERNAL_ERROR   = -32603
+)
+
+// message is a JSON-RPC request, response or notification (a request without an ID)
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  *json.RawMessage `json:"result,omitempty"`
+	Error   *responseError   `json:"error,omitempty"`
+}
+
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// conn reads and writes JSON-RPC messages with the `Content-Length` headers of the LSP base protocol
+type conn struct {
+	r  *bufio.Reader
+	w  io.Writer
+	mu sync.Mutex
+}
+
+func newConn(r io.Reader, w io.Writer) *conn {
+	return &conn{
+		r: bufio.NewReader(r),
+		w: w,
+	}
+}
+
+func (c *conn) read() (*message, error) {
+	header, err := textproto.NewReader(c.r).ReadMIMEHeader()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, errors.Errorf("invalid Content-Length: %s", header.Get("Content-Length"))
+	}
+
+	buf := make([]byte, length)
+	_, err = io.ReadFull(c.r, buf)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	msg := &message{}
+	err = json.Unmarshal(buf, msg)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return msg, nil
+}
+
+func (c *conn) write(msg *message) error {
+	msg.JSONRPC = "2.0"
+
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, err = fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(buf), buf)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// reply responds to a request, a nil result is sent as `null`
+func (c *conn) reply(id *json.RawMessage, result interface{}) error {
+	buf, err := json.Marshal(result)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	raw := json.RawMessage(buf)
+
+	return c.write(&message{ID: id, Result: &raw})
+}
+
+func (c *conn) replyError(id *json.RawMessage, code int, msg string) error {
+	return c.write(&message{ID: id, Error: &responseError{Code: code, Message: msg}})
+}
+
+func (c *conn) notify(method string, params interface{}) error {
+	buf, err := json.Marshal(params)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return c.write(&message{Method: method, Params: buf})
+}
@@ -0,0 +1,532 @@
+package lsp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+	"github.com/rubensayshi/dubby/src/diagnostics"
+	"github.com/rubensayshi/dubby/src/srcreader"
+	"github.com/rubensayshi/dubby/src/srcutils"
+)
+
+// markerPrefixRegexp matches a start marker up to the cursor, while the name of the filter is being typed
+var markerPrefixRegexp = regexp.MustCompile(`^\s*(do)?\s*-- ?!DU: *([a-zA-Z0-9_-]*)$`)
+
+// errExit is returned by handle when the client tells the server to exit
+var errExit = errors.New("exit")
+
+// Workspace finds the source directory of a file and creates a reader for it, with the settings of dubby.yaml
+type Workspace interface {
+	// Root is the directory that the relative paths in the messages of the readers are relative to
+	Root() string
+	// NewReader creates a reader for the source directory that a file is in, the srcdir is "" when it's not in one
+	NewReader(file string) (reader *srcreader.SrcReader, srcdir string, err error)
+}
+
+// Server is a language server for source directories, it publishes the problems that `dubby lint` finds when files are opened or saved,
+// completes the filters of markers, goes to the definition of the globals of the lib files and shows which slot a file belongs to.
+// Positions are in UTF-16 code units as the spec says, or in bytes when the client supports that.
+type Server struct {
+	conn      *conn
+	workspace Workspace
+	utf8      bool                            // the client uses bytes instead of UTF-16 code units for the characters of positions
+	docs      map[string]string               // the text of the open documents, by URI
+	readers   map[string]*srcreader.SrcReader // the reader that last linted each srcdir
+	published map[string][]string             // the URIs diagnostics were published for, by srcdir, to clear them when they're fixed
+}
+
+func NewServer(workspace Workspace) *Server {
+	return &Server{
+		workspace: workspace,
+		docs:      make(map[string]string),
+		readers:   make(map[string]*srcreader.SrcReader),
+		published: make(map[string][]string),
+	}
+}
+
+// Serve handles the messages of the client until it exits or closes the connection
+func (s *Server) Serve(r io.Reader, w io.Writer) error {
+	s.conn = newConn(r, w)
+
+	for {
+		msg, err := s.conn.read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		err = s.handle(msg)
+		if err == errExit {
+			return nil
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+}
+
+func (s *Server) handle(msg *message) error {
+	var result interface{}
+	var err error
+
+	switch msg.Method {
+	case "initialize":
+		params := &InitializeParams{}
+		if len(msg.Params) > 0 {
+			err = json.Unmarshal(msg.Params, params)
+		}
+
+		// bytes are what we have, so that's preferred when the client supports it
+		positionEncoding := POSITION_ENCODING_UTF16
+		for _, encoding := range params.Capabilities.General.PositionEncodings {
+			if encoding == POSITION_ENCODING_UTF8 {
+				positionEncoding = POSITION_ENCODING_UTF8
+			}
+		}
+		s.utf8 = positionEncoding == POSITION_ENCODING_UTF8
+
+		result = &InitializeResult{
+			Capabilities: ServerCapabilities{
+				PositionEncoding: positionEncoding,
+				TextDocumentSync: TextDocumentSyncOptions{
+					OpenClose: true,
+					Change:    TEXT_DOCUMENT_SYNC_FULL,
+					Save:      true,
+				},
+				CompletionProvider: CompletionOptions{
+					TriggerCharacters: []string{":", " "},
+				},
+				DefinitionProvider: true,
+				HoverProvider:      true,
+			},
+			ServerInfo: ServerInfo{Name: "dubby"},
+		}
+	case "shutdown":
+		result = nil
+	case "exit":
+		return errExit
+	case "textDocument/didOpen":
+		params := &DidOpenTextDocumentParams{}
+		err = json.Unmarshal(msg.Params, params)
+		if err == nil {
+			s.docs[params.TextDocument.URI] = params.TextDocument.Text
+			err = s.lint(params.TextDocument.URI)
+		}
+	case "textDocument/didChange":
+		params := &DidChangeTextDocumentParams{}
+		err = json.Unmarshal(msg.Params, params)
+		// with full sync the last change is the whole document
+		if err == nil && len(params.ContentChanges) > 0 {
+			s.docs[params.TextDocument.URI] = params.ContentChanges[len(params.ContentChanges)-1].Text
+		}
+	case "textDocument/didSave":
+		params := &DidSaveTextDocumentParams{}
+		err = json.Unmarshal(msg.Params, params)
+		if err == nil {
+			err = s.lint(params.TextDocument.URI)
+		}
+	case "textDocument/didClose":
+		params := &DidCloseTextDocumentParams{}
+		err = json.Unmarshal(msg.Params, params)
+		if err == nil {
+			delete(s.docs, params.TextDocument.URI)
+		}
+	case "textDocument/completion":
+		params := &TextDocumentPositionParams{}
+		err = json.Unmarshal(msg.Params, params)
+		if err == nil {
+			result = s.completion(params)
+		}
+	case "textDocument/definition":
+		params := &TextDocumentPositionParams{}
+		err = json.Unmarshal(msg.Params, params)
+		if err == nil {
+			result, err = s.definition(params)
+		}
+	case "textDocument/hover":
+		params := &TextDocumentPositionParams{}
+		err = json.Unmarshal(msg.Params, params)
+		if err == nil {
+			result, err = s.hover(params)
+		}
+	default:
+		// notifications we don't know about can be ignored
+		if msg.ID != nil {
+			return s.conn.replyError(msg.ID, CODE_METHOD_NOT_FOUND, fmt.Sprintf("method not found: %s", msg.Method))
+		}
+
+		return nil
+	}
+
+	// notifications don't get a response, so their errors are logged instead
+	if msg.ID == nil {
+		if err != nil {
+			return s.conn.notify("window/logMessage", &LogMessageParams{Type: MESSAGE_TYPE_ERROR, Message: err.Error()})
+		}
+
+		return nil
+	}
+
+	if err != nil {
+		return s.conn.replyError(msg.ID, CODE_INTERNAL_ERROR, err.Error())
+	}
+
+	return s.conn.reply(msg.ID, result)
+}
+
+// lint publishes the problems of the source directory that a document is in, clearing the ones that have been fixed
+func (s *Server) lint(uri string) error {
+	reader, srcdir, err := s.workspace.NewReader(uriToPath(uri))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if srcdir == "" {
+		return nil
+	}
+
+	problems, err := reader.Lint()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	s.readers[srcdir] = reader
+
+	byURI := map[string][]Diagnostic{
+		// always publish for the document, to clear its problems when they're fixed
+		uri: {},
+	}
+	for _, problem := range problems {
+		// problems which aren't about a specific file are shown on the document
+		problemURI := uri
+		if problem.File != "" {
+			problemURI = pathToURI(s.absPath(problem.File))
+		}
+
+		byURI[problemURI] = append(byURI[problemURI], s.toDiagnostic(problemURI, problem))
+	}
+
+	for _, prevURI := range s.published[srcdir] {
+		if _, ok := byURI[prevURI]; !ok {
+			byURI[prevURI] = []Diagnostic{}
+		}
+	}
+
+	uris := make([]string, 0, len(byURI))
+	for problemURI := range byURI {
+		uris = append(uris, problemURI)
+	}
+	sort.Strings(uris)
+
+	s.published[srcdir] = make([]string, 0)
+	for _, problemURI := range uris {
+		err := s.conn.notify("textDocument/publishDiagnostics", &PublishDiagnosticsParams{
+			URI:         problemURI,
+			Diagnostics: byURI[problemURI],
+		})
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if len(byURI[problemURI]) > 0 {
+			s.published[srcdir] = append(s.published[srcdir], problemURI)
+		}
+	}
+
+	return nil
+}
+
+// reader is the reader of the source directory that a document is in, it's linted when it hasn't been yet
+func (s *Server) reader(uri string) (*srcreader.SrcReader, string, error) {
+	_, srcdir, err := s.workspace.NewReader(uriToPath(uri))
+	if err != nil || srcdir == "" {
+		return nil, "", errors.WithStack(err)
+	}
+
+	if _, ok := s.readers[srcdir]; !ok {
+		err := s.lint(uri)
+		if err != nil {
+			return nil, "", errors.WithStack(err)
+		}
+	}
+
+	return s.readers[srcdir], srcdir, nil
+}
+
+// completion completes the name of the filter in a start marker, eg; `-- !DU: mou` -> `mouseDown([])`
+func (s *Server) completion(params *TextDocumentPositionParams) []CompletionItem {
+	items := make([]CompletionItem, 0)
+
+	line := s.line(params.TextDocument.URI, params.Position.Line)
+	line = line[:s.byteOffset(line, params.Position.Character)]
+
+	m := markerPrefixRegexp.FindStringSubmatch(line)
+	if m == nil {
+		return items
+	}
+
+	// only the filters of the element class of the slot, when it's known
+	class := ""
+	filters := srcutils.NewFilters()
+	reader, slotKey, _, ok := s.slotFile(params.TextDocument.URI)
+	if ok {
+		class = reader.SlotClass(slotKey)
+		filters = reader.Filters()
+	}
+
+	signatures := make(map[string]string)
+	names := make([]string, 0)
+	for _, name := range filters.Names() {
+		if !strings.HasPrefix(name, m[2]) {
+			continue
+		}
+
+		if signature, ok := filters.ClassSignature(class, name); ok {
+			signatures[name] = signature
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		signature := signatures[name]
+
+		insertText := name + "()"
+		if !strings.HasSuffix(signature, "()") {
+			insertText = name + "([])"
+		}
+
+		items = append(items, CompletionItem{
+			Label:      name,
+			Kind:       COMPLETION_ITEM_KIND_EVENT,
+			Detail:     signature,
+			InsertText: insertText,
+		})
+	}
+
+	return items
+}
+
+// definition finds where the global under the cursor is assigned in the lib files
+func (s *Server) definition(params *TextDocumentPositionParams) ([]Location, error) {
+	locations := make([]Location, 0)
+
+	name := s.globalAt(params.TextDocument.URI, params.Position)
+	if name == "" {
+		return locations, nil
+	}
+
+	reader, srcdir, err := s.reader(params.TextDocument.URI)
+	if err != nil || srcdir == "" {
+		return locations, errors.WithStack(err)
+	}
+
+	globals, err := reader.LibGlobals()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for _, global := range globals {
+		if global.Name != name {
+			continue
+		}
+
+		uri := pathToURI(s.absPath(global.File))
+		line := s.line(uri, global.Line-1)
+		locations = append(locations, Location{
+			URI: uri,
+			Range: Range{
+				Start: Position{Line: global.Line - 1, Character: s.character(line, global.Column-1)},
+				End:   Position{Line: global.Line - 1, Character: s.character(line, global.Column-1+len(name))},
+			},
+		})
+	}
+
+	return locations, nil
+}
+
+// hover shows the slot that a slot file belongs to, with its element class and the filters of that class
+func (s *Server) hover(params *TextDocumentPositionParams) (*Hover, error) {
+	reader, slotKey, name, ok := s.slotFile(params.TextDocument.URI)
+	if !ok {
+		return nil, nil
+	}
+
+	value := fmt.Sprintf("**slot %d** `%s`", slotKey, name)
+
+	class := reader.SlotClass(slotKey)
+	if class == "" {
+		value += "\n\nclass unknown, set it with `-- !DU[class]: ScreenUnit` at the top of the file or `class` in dubby.yaml"
+	} else {
+		value += fmt.Sprintf("\n\nclass `%s`", class)
+
+		signatures := make([]string, 0)
+		classSignatures, _ := reader.Filters().ClassSignatures(class)
+		for _, signature := range classSignatures {
+			signatures = append(signatures, "`"+signature+"`")
+		}
+		sort.Strings(signatures)
+
+		if len(signatures) > 0 {
+			value += "\n\nfilters: " + strings.Join(signatures, ", ")
+		}
+	}
+
+	return &Hover{Contents: MarkupContent{Kind: "markdown", Value: value}}, nil
+}
+
+// slotFile is the slot that a document is the slot file of, ok is false when it's not a slot file
+func (s *Server) slotFile(uri string) (reader *srcreader.SrcReader, slotKey int, name string, ok bool) {
+	reader, srcdir, err := s.reader(uri)
+	if err != nil || srcdir == "" {
+		return nil, 0, "", false
+	}
+
+	rel, err := filepath.Rel(srcdir, uriToPath(uri))
+	if err != nil {
+		return nil, 0, "", false
+	}
+
+	slotKey, name, ok = reader.SlotFile(filepath.ToSlash(rel))
+
+	return reader, slotKey, name, ok
+}
+
+// line is a line of a document, from the file when it's not open, "" when the document or the line doesn't exist
+func (s *Server) line(uri string, line int) string {
+	text, ok := s.docs[uri]
+	if !ok {
+		buf, err := ioutil.ReadFile(uriToPath(uri))
+		if err != nil {
+			return ""
+		}
+		text = string(buf)
+	}
+
+	lines := strings.Split(text, "\n")
+	if line < 0 || line >= len(lines) {
+		return ""
+	}
+
+	return strings.TrimSuffix(lines[line], "\r")
+}
+
+// globalAt is the name under the cursor, "" when it's a field (eg; `self.name` or `system:print`) or not a name at all
+func (s *Server) globalAt(uri string, pos Position) string {
+	line := s.line(uri, pos.Line)
+	col := s.byteOffset(line, pos.Character)
+
+	start := col
+	for start > 0 && isNameChar(line[start-1]) {
+		start--
+	}
+	end := col
+	for end < len(line) && isNameChar(line[end]) {
+		end++
+	}
+
+	if start == end || (line[start] >= '0' && line[start] <= '9') {
+		return ""
+	}
+	if start > 0 && (line[start-1] == '.' || line[start-1] == ':') {
+		return ""
+	}
+
+	return line[start:end]
+}
+
+// character converts a column (0-based, in bytes) of a line to the character of a position for the client
+func (s *Server) character(line string, col int) int {
+	if col > len(line) {
+		col = len(line)
+	}
+	if s.utf8 {
+		return col
+	}
+
+	return len(utf16.Encode([]rune(line[:col])))
+}
+
+// byteOffset converts the character of a position from the client to a column (0-based, in bytes) of a line,
+// a character past the end of the line is the end of the line.
+func (s *Server) byteOffset(line string, character int) int {
+	if s.utf8 {
+		if character > len(line) {
+			return len(line)
+		}
+		return character
+	}
+
+	units := 0
+	for col, r := range line {
+		if units >= character {
+			return col
+		}
+		units += len(utf16.Encode([]rune{r}))
+	}
+
+	return len(line)
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// absPath resolves the path of a file in a message of a reader
+func (s *Server) absPath(file string) string {
+	if filepath.IsAbs(file) {
+		return file
+	}
+
+	return filepath.Join(s.workspace.Root(), filepath.FromSlash(file))
+}
+
+// toDiagnostic converts a problem in a document to a diagnostic, it spans the rest of the line since we only know where it starts
+func (s *Server) toDiagnostic(uri string, d *diagnostics.Diagnostic) Diagnostic {
+	start := Position{}
+	if d.Line > 0 {
+		start.Line = d.Line - 1
+	}
+	if d.Column > 0 {
+		start.Character = s.character(s.line(uri, start.Line), d.Column-1)
+	}
+
+	severity := DIAGNOSTIC_SEVERITY_ERROR
+	if d.Severity == diagnostics.SEVERITY_WARNING {
+		severity = DIAGNOSTIC_SEVERITY_WARNING
+	}
+
+	return Diagnostic{
+		Range: Range{
+			Start: start,
+			End:   Position{Line: start.Line + 1},
+		},
+		Severity: severity,
+		Code:     d.Code,
+		Source:   "dubby",
+		Message:  d.Message,
+	}
+}
+
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+
+	return filepath.FromSlash(u.Path)
+}
+
+func pathToURI(p string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(p)}).String()
+}
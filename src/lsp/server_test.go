@@ -0,0 +1,252 @@
+package lsp
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rubensayshi/dubby/src/srcreader"
+	"github.com/stretchr/testify/require"
+)
+
+type testWorkspace struct {
+	srcdir string
+}
+
+func (w *testWorkspace) Root() string {
+	return w.srcdir
+}
+
+func (w *testWorkspace) NewReader(file string) (*srcreader.SrcReader, string, error) {
+	if !strings.HasPrefix(file, w.srcdir) {
+		return nil, "", nil
+	}
+
+	return srcreader.NewSrcReader(w.srcdir, false), w.srcdir, nil
+}
+
+// testClient talks to a server, collecting the notifications it sends
+type testClient struct {
+	t             *testing.T
+	conn          *conn
+	messages      chan *message
+	id            int
+	notifications []*message
+}
+
+func newTestClient(t *testing.T, r io.Reader, w io.Writer) *testClient {
+	c := &testClient{t: t, conn: newConn(r, w), messages: make(chan *message, 100)}
+
+	// the server blocks while it writes, so keep reading to not block on each other
+	go func() {
+		for {
+			msg, err := c.conn.read()
+			if err != nil {
+				close(c.messages)
+				return
+			}
+			c.messages <- msg
+		}
+	}()
+
+	return c
+}
+
+func (c *testClient) request(method string, params interface{}, result interface{}) {
+	c.id++
+	id := json.RawMessage(strings.Repeat("1", c.id))
+	c.send(&id, method, params)
+
+	for {
+		msg, ok := <-c.messages
+		require.True(c.t, ok)
+
+		if msg.ID == nil {
+			c.notifications = append(c.notifications, msg)
+			continue
+		}
+
+		require.Equal(c.t, string(id), string(*msg.ID))
+		require.Nil(c.t, msg.Error)
+		if result != nil {
+			require.NoError(c.t, json.Unmarshal(*msg.Result, result))
+		}
+
+		return
+	}
+}
+
+func (c *testClient) send(id *json.RawMessage, method string, params interface{}) {
+	buf, err := json.Marshal(params)
+	require.NoError(c.t, err)
+	require.NoError(c.t, c.conn.write(&message{ID: id, Method: method, Params: buf}))
+}
+
+// diagnostics are the diagnostics that were published last for a file
+func (c *testClient) diagnostics(file string) []Diagnostic {
+	var res []Diagnostic
+	for _, msg := range c.notifications {
+		params := &PublishDiagnosticsParams{}
+		if msg.Method == "textDocument/publishDiagnostics" && json.Unmarshal(msg.Params, params) == nil && params.URI == pathToURI(file) {
+			res = params.Diagnostics
+		}
+	}
+
+	return res
+}
+
+func TestServer(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "dubby")
+	assert.NoError(err)
+	defer os.RemoveAll(dir) // always cleanup the mess
+
+	slotFile := filepath.Join(dir, "slots", "0.screen.lua")
+	unitFile := filepath.Join(dir, "slots", "-1.unit.lua")
+	libFile := filepath.Join(dir, "lib", "utils.lua")
+	assert.NoError(os.MkdirAll(filepath.Dir(slotFile), 0755))
+	assert.NoError(os.MkdirAll(filepath.Dir(libFile), 0755))
+	assert.NoError(ioutil.WriteFile(libFile, []byte("local x = 1\nfunction greet(name)\n    system.print(name)\nend\n"), 0666))
+	assert.NoError(ioutil.WriteFile(unitFile, []byte("do -- !DU: start()\n    greet(\"hi\")\nend -- !DU: end\n"), 0666))
+	slotSrc := "-- !DU[class]: ScreenUnit\ndo -- !DU: mouseDown[x]\n    greet(\"x\")\nend -- !DU: end\n"
+	assert.NoError(ioutil.WriteFile(slotFile, []byte(slotSrc), 0666))
+
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- NewServer(&testWorkspace{srcdir: dir}).Serve(serverIn, serverOut)
+	}()
+
+	c := newTestClient(t, clientIn, clientOut)
+
+	initialize := &InitializeResult{}
+	c.request("initialize", map[string]interface{}{}, initialize)
+	assert.True(initialize.Capabilities.DefinitionProvider)
+	assert.True(initialize.Capabilities.HoverProvider)
+	assert.Equal(POSITION_ENCODING_UTF16, initialize.Capabilities.PositionEncoding)
+
+	// opening a file publishes the problems of its source directory
+	slotURI := pathToURI(slotFile)
+	c.send(nil, "textDocument/didOpen", &DidOpenTextDocumentParams{TextDocument: TextDocumentItem{URI: slotURI, LanguageID: "lua", Text: slotSrc}})
+	c.request("textDocument/hover", &TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: slotURI}}, nil)
+	diagnostics := c.diagnostics(slotFile)
+	assert.Equal(2, len(diagnostics))
+	assert.Equal("bad-marker", diagnostics[0].Code)
+	assert.Equal(1, diagnostics[0].Range.Start.Line)
+	assert.Equal(DIAGNOSTIC_SEVERITY_ERROR, diagnostics[0].Severity)
+	assert.Equal("end-without-start", diagnostics[1].Code)
+	assert.Equal(3, diagnostics[1].Range.Start.Line)
+
+	// which are cleared when it's fixed and saved
+	slotSrc = "-- !DU[class]: ScreenUnit\ndo -- !DU: mouseDown([*, *])\n    greet(\"x\")\nend -- !DU: end\n"
+	assert.NoError(ioutil.WriteFile(slotFile, []byte(slotSrc), 0666))
+	c.send(nil, "textDocument/didChange", &DidChangeTextDocumentParams{TextDocument: TextDocumentIdentifier{URI: slotURI}, ContentChanges: []TextDocumentContentChangeEvent{{Text: slotSrc}}})
+	c.send(nil, "textDocument/didSave", &DidSaveTextDocumentParams{TextDocument: TextDocumentIdentifier{URI: slotURI}})
+	c.request("textDocument/hover", &TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: slotURI}}, nil)
+	assert.Equal(0, len(c.diagnostics(slotFile)))
+
+	// hover shows the slot of the file
+	hover := &Hover{}
+	c.request("textDocument/hover", &TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: slotURI}}, hover)
+	assert.Contains(hover.Contents.Value, "**slot 0** `screen`")
+	assert.Contains(hover.Contents.Value, "class `ScreenUnit`")
+	assert.Contains(hover.Contents.Value, "`mouseDown(x,y)`")
+
+	// go to the global of the lib
+	locations := make([]Location, 0)
+	c.request("textDocument/definition", &TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: slotURI}, Position: Position{Line: 2, Character: 6}}, &locations)
+	assert.Equal([]Location{{
+		URI:   pathToURI(libFile),
+		Range: Range{Start: Position{Line: 1, Character: 9}, End: Position{Line: 1, Character: 14}},
+	}}, locations)
+
+	// fields aren't globals
+	c.send(nil, "textDocument/didOpen", &DidOpenTextDocumentParams{TextDocument: TextDocumentItem{URI: pathToURI(libFile), Text: "local x = 1\nfunction greet(name)\n    system.print(name)\nend\n"}})
+	c.request("textDocument/definition", &TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: pathToURI(libFile)}, Position: Position{Line: 2, Character: 12}}, &locations)
+	assert.Equal(0, len(locations))
+
+	// complete the filter of a marker
+	c.send(nil, "textDocument/didChange", &DidChangeTextDocumentParams{TextDocument: TextDocumentIdentifier{URI: slotURI}, ContentChanges: []TextDocumentContentChangeEvent{{Text: "do -- !DU: mouse"}}})
+	items := make([]CompletionItem, 0)
+	c.request("textDocument/completion", &TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: slotURI}, Position: Position{Line: 0, Character: 16}}, &items)
+	assert.Equal(2, len(items))
+	assert.Equal("mouseDown", items[0].Label)
+	assert.Equal("mouseDown(x,y)", items[0].Detail)
+	assert.Equal("mouseDown([])", items[0].InsertText)
+	assert.Equal("mouseUp", items[1].Label)
+
+	// but not outside of markers
+	c.request("textDocument/completion", &TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: slotURI}, Position: Position{Line: 0, Character: 2}}, &items)
+	assert.Equal(0, len(items))
+
+	// only the filters of the class of the slot are completed
+	c.send(nil, "textDocument/didChange", &DidChangeTextDocumentParams{TextDocument: TextDocumentIdentifier{URI: slotURI}, ContentChanges: []TextDocumentContentChangeEvent{{Text: "do -- !DU: en"}}})
+	c.request("textDocument/completion", &TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: slotURI}, Position: Position{Line: 0, Character: 13}}, &items)
+	assert.Equal(0, len(items))
+
+	c.send(nil, "textDocument/didChange", &DidChangeTextDocumentParams{TextDocument: TextDocumentIdentifier{URI: pathToURI(unitFile)}, ContentChanges: []TextDocumentContentChangeEvent{{Text: "do -- !DU: "}}})
+	c.request("textDocument/completion", &TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: pathToURI(unitFile)}, Position: Position{Line: 0, Character: 11}}, &items)
+	assert.Equal(3, len(items))
+	assert.Equal([]string{"start", "stop", "tick"}, []string{items[0].Label, items[1].Label, items[2].Label})
+
+	// the characters of positions are in UTF-16 code units
+	c.send(nil, "textDocument/didChange", &DidChangeTextDocumentParams{TextDocument: TextDocumentIdentifier{URI: slotURI}, ContentChanges: []TextDocumentContentChangeEvent{{Text: "print(\"h\u00e9llo\", greet)"}}})
+	c.request("textDocument/definition", &TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: slotURI}, Position: Position{Line: 0, Character: 15}}, &locations)
+	assert.Equal(1, len(locations))
+
+	c.request("shutdown", nil, nil)
+	c.send(nil, "exit", nil)
+	assert.NoError(<-done)
+}
+
+func TestServerPositionEncoding(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "dubby")
+	assert.NoError(err)
+	defer os.RemoveAll(dir) // always cleanup the mess
+
+	slotFile := filepath.Join(dir, "slots", "-1.unit.lua")
+	libFile := filepath.Join(dir, "lib", "utils.lua")
+	assert.NoError(os.MkdirAll(filepath.Dir(slotFile), 0755))
+	assert.NoError(os.MkdirAll(filepath.Dir(libFile), 0755))
+	assert.NoError(ioutil.WriteFile(libFile, []byte("x = \"\u00e9\"; greet = 1\n"), 0666))
+	assert.NoError(ioutil.WriteFile(slotFile, []byte("do -- !DU: start()\n    print(greet)\nend -- !DU: end\n"), 0666))
+
+	for _, encoding := range []string{POSITION_ENCODING_UTF16, POSITION_ENCODING_UTF8} {
+		serverIn, clientOut := io.Pipe()
+		clientIn, serverOut := io.Pipe()
+		done := make(chan error)
+		go func() {
+			done <- NewServer(&testWorkspace{srcdir: dir}).Serve(serverIn, serverOut)
+		}()
+
+		c := newTestClient(t, clientIn, clientOut)
+
+		// bytes are used when the client supports them
+		initialize := &InitializeResult{}
+		c.request("initialize", &InitializeParams{Capabilities: ClientCapabilities{General: GeneralClientCapabilities{PositionEncodings: []string{encoding}}}}, initialize)
+		assert.Equal(encoding, initialize.Capabilities.PositionEncoding)
+
+		locations := make([]Location, 0)
+		c.request("textDocument/definition", &TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: pathToURI(slotFile)}, Position: Position{Line: 1, Character: 10}}, &locations)
+		assert.Equal(1, len(locations))
+
+		// the é is 1 UTF-16 code unit, but 2 bytes
+		start := 9
+		if encoding == POSITION_ENCODING_UTF8 {
+			start = 10
+		}
+		assert.Equal(Range{Start: Position{Line: 0, Character: start}, End: Position{Line: 0, Character: start + 5}}, locations[0].Range)
+
+		c.request("shutdown", nil, nil)
+		c.send(nil, "exit", nil)
+		assert.NoError(<-done)
+	}
+}
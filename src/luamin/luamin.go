@@ -1,75 +1,53 @@
 package luamin
 
 import (
-	"bytes"
-	"io/ioutil"
-	"os"
-	"os/exec"
-	"regexp"
-	"strings"
-
 	"github.com/pkg/errors"
 )
 
-const LUAMIN_CMD = "luamin"
-
-var versionRegex = regexp.MustCompile(`^v[0-9]+\.[0-9]+\.[0-9]+$`)
+const (
+	MINIFIER_NATIVE = "native"
+	MINIFIER_LUAMIN = "luamin"
+)
 
-var isSupported *bool = nil
+var minifier = MINIFIER_NATIVE
 
-func IsSupported() bool {
-	if isSupported == nil {
-		cmd := exec.Command(LUAMIN_CMD, "-v")
-		res, _ := cmd.Output()
+// SetMinifier selects which minifier LuaMin uses, the native one (default) or the `luamin` binary
+func SetMinifier(name string) error {
+	switch name {
+	case MINIFIER_NATIVE:
+	case MINIFIER_LUAMIN:
+		if !IsSupported() {
+			return errors.Errorf("minifier `%s` not supported, couldn't detect `%s`", name, LUAMIN_CMD)
+		}
+	default:
+		return errors.Errorf("unknown minifier `%s`, expected `%s` or `%s`", name, MINIFIER_NATIVE, MINIFIER_LUAMIN)
+	}
 
-		v := strings.TrimSuffix(strings.TrimSuffix(string(res), "\n"), "\r\n")
+	minifier = name
 
-		ok := versionRegex.MatchString(v)
-		isSupported = &ok
-	}
+	return nil
+}
 
-	return *isSupported
+// Position maps a position (1-based) in the minified code to the position in the original code
+type Position struct {
+	Line       int
+	Column     int
+	OrigLine   int
+	OrigColumn int
 }
 
 func LuaMin(lua []byte) ([]byte, error) {
-	if !IsSupported() {
-		return nil, errors.Errorf("LuaMin not supported, couldn't detect `%s`", LUAMIN_CMD)
-	}
-
-	cmd := exec.Command(LUAMIN_CMD, "-c")
-
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	stdin, _ := cmd.StdinPipe()
-	err := cmd.Start()
-	if err != nil {
-		return nil, errors.WithStack(err)
-	}
-
-	_, err = stdin.Write(lua)
-	if err != nil {
-		return nil, errors.WithStack(err)
-	}
-
-	_ = stdin.Close()
-
-	err = cmd.Wait()
-
-	if err != nil {
-		_, isExit := err.(*exec.ExitError)
-		if isExit && stderr.Len() > 0 {
-			err = errors.Errorf(stderr.String())
-		} else if isExit && stdout.Len() > 0 {
-			err = errors.Errorf(stdout.String())
-		}
-
-		tmp, _ := ioutil.TempFile(os.TempDir(), "luamin")
-		tmp.Write(lua)
+	minified, _, err := LuaMinWithPositions(lua)
+	return minified, err
+}
 
-		return nil, errors.Wrapf(err, "Failed to luamin: (dumped to %s)", tmp.Name())
+// LuaMinWithPositions is the same as LuaMin, but also returns the position in the original code of each token.
+// The `luamin` binary doesn't provide these, so then the positions are nil.
+func LuaMinWithPositions(lua []byte) ([]byte, []Position, error) {
+	if minifier == MINIFIER_LUAMIN {
+		minified, err := externalLuaMin(lua)
+		return minified, nil, err
 	}
 
-	return stdout.Bytes(), nil
+	return nativeLuaMin(lua)
 }
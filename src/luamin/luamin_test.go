@@ -21,3 +21,104 @@ func TestLuaMinErr(t *testing.T) {
 	assert.Error(err)
 	assert.Contains(err.Error(), "unexpected number '14' near 'blabla'")
 }
+
+func TestLuaMinRenamesLocals(t *testing.T) {
+	assert := require.New(t)
+
+	out, err := LuaMin([]byte(`
+a = 1 -- global, so the locals can't use it
+local first = 1
+local function second(third, ...)
+	local first = first + third
+	return first, ...
+end
+print(second(first, 2))
+`))
+	assert.NoError(err)
+	assert.Equal("a=1 local b=1 local function c(d,...)local e=b+d return e,...end print(c(b,2))\n", string(out))
+}
+
+func TestLuaMinKeepsTokensApart(t *testing.T) {
+	assert := require.New(t)
+
+	out, err := LuaMin([]byte(`
+local t = {}
+t[ [[x]] ] = 1 .. 2
+print(- -1, t.x .. .5)
+`))
+	assert.NoError(err)
+	assert.Equal("local a={}a[ [[x]]]=1 .. 2 print(- -1,a.x.. .5)\n", string(out))
+}
+
+func TestLuaMinKeepsEscapedNewlines(t *testing.T) {
+	assert := require.New(t)
+
+	out, err := LuaMin([]byte("local s = 'a\\z\n    b' .. 'c\\\nd'\nprint(s)\n"))
+	assert.NoError(err)
+	assert.Equal("local a='a\\z\n    b'..'c\\\nd'print(a)\n", string(out))
+}
+
+func TestLuaMinKeepsSelf(t *testing.T) {
+	assert := require.New(t)
+
+	out, err := LuaMin([]byte(`
+local obj = {}
+function obj:fn(arg) return self, arg end
+`))
+	assert.NoError(err)
+	assert.Equal("local a={}function a:fn(b)return self,b end\n", string(out))
+}
+
+func TestSetMinifier(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Error(SetMinifier("uglify"))
+	assert.NoError(SetMinifier(MINIFIER_NATIVE))
+}
+
+func TestLuaMinWithPositions(t *testing.T) {
+	assert := require.New(t)
+
+	out, positions, err := LuaMinWithPositions([]byte(`local a = 1
+if a then
+    print(a)
+end
+`))
+	assert.NoError(err)
+	assert.Equal("local a=1 if a then print(a)end\n", string(out))
+	assert.Equal([]Position{
+		{1, 1, 1, 1},   // local
+		{1, 7, 1, 7},   // a
+		{1, 8, 1, 9},   // =
+		{1, 9, 1, 11},  // 1
+		{1, 11, 2, 1},  // if
+		{1, 14, 2, 4},  // a
+		{1, 16, 2, 6},  // then
+		{1, 21, 3, 5},  // print
+		{1, 26, 3, 10}, // (
+		{1, 27, 3, 11}, // a
+		{1, 28, 3, 12}, // )
+		{1, 29, 4, 1},  // end
+	}, positions)
+}
+
+func TestLuaMinWithPositionsMultiLine(t *testing.T) {
+	assert := require.New(t)
+
+	out, positions, err := LuaMinWithPositions([]byte(`local a = [[a
+b]]
+print(a)
+`))
+	assert.NoError(err)
+	assert.Equal("local a=[[a\nb]]print(a)\n", string(out))
+	assert.Equal([]Position{
+		{1, 1, 1, 1},  // local
+		{1, 7, 1, 7},  // a
+		{1, 8, 1, 9},  // =
+		{1, 9, 1, 11}, // [[a\nb]]
+		{2, 4, 3, 1},  // print
+		{2, 9, 3, 6},  // (
+		{2, 10, 3, 7}, // a
+		{2, 11, 3, 8}, // )
+	}, positions)
+}
@@ -0,0 +1,158 @@
+package luamin
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rubensayshi/dubby/src/luaparser"
+)
+
+const nameStartChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ_"
+const nameChars = nameStartChars + "0123456789"
+
+// nativeLuaMin minifies lua without any external dependencies;
+// strips comments and whitespace and renames locals to the shortest names that are safe to use.
+func nativeLuaMin(lua []byte) ([]byte, []Position, error) {
+	chunk, err := luaparser.Parse(string(lua))
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	renamed := renameLocals(chunk)
+
+	var out strings.Builder
+	positions := make([]Position, 0, len(chunk.Tokens))
+	var prev *luaparser.Token
+	prevValue := ""
+	// the output is a single line, except for long strings and comments with newlines in them
+	line, lineStart := 1, 0
+	for _, t := range chunk.Tokens {
+		if t.Type == luaparser.TOKEN_EOF {
+			break
+		}
+
+		value := t.Value
+		if name, ok := renamed[t]; ok {
+			value = name
+		}
+
+		if prev != nil && needsSpace(prev, prevValue, value) {
+			out.WriteByte(' ')
+		}
+
+		positions = append(positions, Position{
+			Line:       line,
+			Column:     out.Len() - lineStart + 1,
+			OrigLine:   t.Line,
+			OrigColumn: t.Column,
+		})
+
+		out.WriteString(value)
+
+		if k := strings.LastIndex(value, "\n"); k != -1 {
+			line += strings.Count(value, "\n")
+			lineStart = out.Len() - len(value) + k + 1
+		}
+
+		prev = t
+		prevValue = value
+	}
+
+	// same as the luamin cli
+	out.WriteByte('\n')
+
+	return []byte(out.String()), positions, nil
+}
+
+// renameLocals gives every local the shortest name which doesn't collide with any global,
+// or with any other local that is in scope at the same time.
+func renameLocals(chunk *luaparser.Chunk) map[*luaparser.Token]string {
+	renamed := make(map[*luaparser.Token]string)
+
+	// when _ENV is used all bets are off, since locals can become globals and vice versa
+	for _, t := range chunk.Tokens {
+		if t.Type == luaparser.TOKEN_NAME && t.Value == "_ENV" {
+			return renamed
+		}
+	}
+
+	globals := make(map[string]bool)
+	for _, v := range chunk.Vars {
+		if v.Local == nil {
+			globals[v.Token.Value] = true
+		}
+	}
+
+	names := make(map[*luaparser.Local]string, len(chunk.Locals))
+	for _, l := range chunk.Locals {
+		// the implicit `self` can't be renamed
+		if l.Token == nil {
+			names[l] = l.Name
+			continue
+		}
+
+		taken := make(map[string]bool, len(l.Visible))
+		for _, visible := range l.Visible {
+			taken[names[visible]] = true
+		}
+
+		for i := 0; ; i++ {
+			name := generateName(i)
+			if !taken[name] && !globals[name] && !luaparser.Keywords[name] {
+				names[l] = name
+				break
+			}
+		}
+	}
+
+	for _, v := range chunk.Vars {
+		if v.Local != nil {
+			renamed[v.Token] = names[v.Local]
+		}
+	}
+
+	return renamed
+}
+
+// generateName generates the i'th name in the sequence a, b, ..., _, aa, ba, ...
+func generateName(i int) string {
+	name := []byte{nameStartChars[i%len(nameStartChars)]}
+	i = i / len(nameStartChars)
+
+	for i > 0 {
+		i--
+		name = append(name, nameChars[i%len(nameChars)])
+		i = i / len(nameChars)
+	}
+
+	return string(name)
+}
+
+// needsSpace checks if 2 tokens would be lexed differently when not separated by whitespace
+func needsSpace(prev *luaparser.Token, prevValue string, next string) bool {
+	last := prevValue[len(prevValue)-1]
+	first := next[0]
+
+	switch {
+	case isNameChar(last) && isNameChar(first):
+		return true
+	case prev.Type == luaparser.TOKEN_NUMBER && first == '.':
+		return true
+	case last == '.' && (first == '.' || isDigit(first)):
+		return true
+	case last == '-' && first == '-':
+		return true
+	case last == '[' && (first == '[' || first == '='):
+		return true
+	}
+
+	return false
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isNameChar(c byte) bool {
+	return strings.IndexByte(nameChars, c) != -1
+}
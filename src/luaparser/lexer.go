@@ -0,0 +1,363 @@
+package luaparser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type TokenType int
+
+const (
+	TOKEN_EOF TokenType = iota
+	TOKEN_NAME
+	TOKEN_KEYWORD
+	TOKEN_NUMBER
+	TOKEN_STRING
+	TOKEN_SYMBOL
+)
+
+var tokenTypeNames = map[TokenType]string{
+	TOKEN_EOF:     "<eof>",
+	TOKEN_NAME:    "identifier",
+	TOKEN_KEYWORD: "keyword",
+	TOKEN_NUMBER:  "number",
+	TOKEN_STRING:  "string",
+	TOKEN_SYMBOL:  "symbol",
+}
+
+func (t TokenType) String() string {
+	return tokenTypeNames[t]
+}
+
+var Keywords = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true, "end": true,
+	"false": true, "for": true, "function": true, "goto": true, "if": true, "in": true,
+	"local": true, "nil": true, "not": true, "or": true, "repeat": true, "return": true,
+	"then": true, "true": true, "until": true, "while": true,
+}
+
+// symbols ordered so that the longest match is tried first
+var symbols = []string{
+	"...", "..", "==", "~=", "<=", ">=", "<<", ">>", "//", "::",
+	"+", "-", "*", "/", "%", "^", "#", "&", "~", "|", "<", ">", "=",
+	"(", ")", "{", "}", "[", "]", ";", ":", ",", ".",
+}
+
+type Token struct {
+	Type   TokenType
+	Value  string // the raw source of the token
+	Line   int    // 1-based
+	Column int    // 1-based, in bytes
+	Offset int    // 0-based, in bytes
+}
+
+// display is how the token is shown in error messages
+func (t *Token) display() string {
+	if t.Type == TOKEN_EOF {
+		return "<eof>"
+	}
+
+	if t.Type == TOKEN_NUMBER && !strings.HasPrefix(strings.ToLower(t.Value), "0x") {
+		if f, err := strconv.ParseFloat(t.Value, 64); err == nil {
+			return strconv.FormatFloat(f, 'g', -1, 64)
+		}
+	}
+
+	return t.Value
+}
+
+type lexer struct {
+	src    string
+	offset int
+	line   int
+	column int
+}
+
+// Tokenize splits lua source into tokens, dropping whitespace and comments.
+// The last token is always a TOKEN_EOF.
+func Tokenize(src string) ([]*Token, error) {
+	l := &lexer{
+		src:    src,
+		line:   1,
+		column: 1,
+	}
+
+	// skip the shebang line, the same as the lua interpreter does
+	if strings.HasPrefix(src, "#") {
+		for l.offset < len(l.src) && l.src[l.offset] != '\n' {
+			l.advance(1)
+		}
+	}
+
+	tokens := make([]*Token, 0)
+	for {
+		token, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, token)
+
+		if token.Type == TOKEN_EOF {
+			return tokens, nil
+		}
+	}
+}
+
+func (l *lexer) errorf(line int, column int, format string, args ...interface{}) error {
+	return &SyntaxError{
+		Line:    line,
+		Column:  column,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+func (l *lexer) peek(n int) byte {
+	if l.offset+n >= len(l.src) {
+		return 0
+	}
+
+	return l.src[l.offset+n]
+}
+
+func (l *lexer) advance(n int) {
+	for i := 0; i < n && l.offset < len(l.src); i++ {
+		if l.src[l.offset] == '\n' {
+			l.line++
+			l.column = 1
+		} else {
+			l.column++
+		}
+		l.offset++
+	}
+}
+
+func (l *lexer) next() (*Token, error) {
+	err := l.skipWhitespaceAndComments()
+	if err != nil {
+		return nil, err
+	}
+
+	token := &Token{
+		Line:   l.line,
+		Column: l.column,
+		Offset: l.offset,
+	}
+
+	if l.offset >= len(l.src) {
+		token.Type = TOKEN_EOF
+		return token, nil
+	}
+
+	c := l.peek(0)
+	switch {
+	case isNameStart(c):
+		for isNameChar(l.peek(0)) {
+			l.advance(1)
+		}
+
+		token.Value = l.src[token.Offset:l.offset]
+		token.Type = TOKEN_NAME
+		if Keywords[token.Value] {
+			token.Type = TOKEN_KEYWORD
+		}
+
+	case isDigit(c) || (c == '.' && isDigit(l.peek(1))):
+		l.readNumber()
+		token.Type = TOKEN_NUMBER
+		token.Value = l.src[token.Offset:l.offset]
+
+	case c == '"' || c == '\'':
+		err := l.readString(c)
+		if err != nil {
+			return nil, err
+		}
+		token.Type = TOKEN_STRING
+		token.Value = l.src[token.Offset:l.offset]
+
+	case c == '[' && (l.peek(1) == '[' || l.peek(1) == '='):
+		level, ok := l.longBracketLevel()
+		if !ok {
+			// just a `[` followed by a `=`, which isn't valid but that's for the parser to figure out
+			l.advance(1)
+			token.Type = TOKEN_SYMBOL
+			token.Value = "["
+			return token, nil
+		}
+
+		err := l.readLongBracket(level, "string")
+		if err != nil {
+			return nil, err
+		}
+		token.Type = TOKEN_STRING
+		token.Value = l.src[token.Offset:l.offset]
+
+	default:
+		for _, symbol := range symbols {
+			if strings.HasPrefix(l.src[l.offset:], symbol) {
+				l.advance(len(symbol))
+				token.Type = TOKEN_SYMBOL
+				token.Value = symbol
+				return token, nil
+			}
+		}
+
+		return nil, l.errorf(token.Line, token.Column, "unexpected character '%c'", c)
+	}
+
+	return token, nil
+}
+
+func (l *lexer) skipWhitespaceAndComments() error {
+	for l.offset < len(l.src) {
+		c := l.peek(0)
+		switch {
+		case isSpace(c):
+			l.advance(1)
+
+		case c == '-' && l.peek(1) == '-':
+			l.advance(2)
+
+			if l.peek(0) == '[' {
+				level, ok := l.longBracketLevel()
+				if ok {
+					err := l.readLongBracket(level, "comment")
+					if err != nil {
+						return err
+					}
+					continue
+				}
+			}
+
+			for l.offset < len(l.src) && l.peek(0) != '\n' {
+				l.advance(1)
+			}
+
+		default:
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// longBracketLevel checks if we're at the start of a long bracket (`[[` or `[==[`) and returns its level
+func (l *lexer) longBracketLevel() (int, bool) {
+	level := 0
+	for l.peek(1+level) == '=' {
+		level++
+	}
+
+	return level, l.peek(1+level) == '['
+}
+
+func (l *lexer) readLongBracket(level int, what string) error {
+	line, column := l.line, l.column
+	closing := "]" + strings.Repeat("=", level) + "]"
+
+	l.advance(level + 2)
+
+	idx := strings.Index(l.src[l.offset:], closing)
+	if idx == -1 {
+		return l.errorf(line, column, "unfinished long %s near '<eof>'", what)
+	}
+
+	l.advance(idx + len(closing))
+
+	return nil
+}
+
+func (l *lexer) readString(quote byte) error {
+	line, column, start := l.line, l.column, l.offset
+	l.advance(1)
+
+	for {
+		if l.offset >= len(l.src) {
+			return l.errorf(line, column, "unfinished string near '<eof>'")
+		}
+
+		c := l.peek(0)
+		switch c {
+		case quote:
+			l.advance(1)
+			return nil
+		case '\n':
+			return l.errorf(line, column, "unfinished string near '%s'", l.src[start:l.offset])
+		case '\\':
+			switch l.peek(1) {
+			case 'z':
+				// `\z` skips the whitespace after it, including newlines
+				l.advance(2)
+				for isSpace(l.peek(0)) {
+					l.advance(1)
+				}
+			case '\r', '\n':
+				// an escaped newline is a newline in the string, `\r\n` and `\n\r` count as one
+				l.advance(1)
+				if (l.peek(0) == '\r' && l.peek(1) == '\n') || (l.peek(0) == '\n' && l.peek(1) == '\r') {
+					l.advance(2)
+				} else {
+					l.advance(1)
+				}
+			default:
+				// the escaped char can be anything else, it only matters that it doesn't end the string
+				l.advance(2)
+			}
+		default:
+			l.advance(1)
+		}
+	}
+}
+
+func (l *lexer) readNumber() {
+	isHex := l.peek(0) == '0' && (l.peek(1) == 'x' || l.peek(1) == 'X')
+	isDigitFn := isDigit
+	exponent := "eE"
+	if isHex {
+		l.advance(2)
+		isDigitFn = isHexDigit
+		exponent = "pP"
+	}
+
+	for isDigitFn(l.peek(0)) {
+		l.advance(1)
+	}
+
+	if l.peek(0) == '.' && l.peek(1) != '.' {
+		l.advance(1)
+		for isDigitFn(l.peek(0)) {
+			l.advance(1)
+		}
+	}
+
+	if strings.IndexByte(exponent, l.peek(0)) != -1 && l.peek(0) != 0 {
+		l.advance(1)
+		if l.peek(0) == '+' || l.peek(0) == '-' {
+			l.advance(1)
+		}
+		for isDigit(l.peek(0)) {
+			l.advance(1)
+		}
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\v' || c == '\f'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func isNameStart(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_'
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || isDigit(c)
+}
@@ -0,0 +1,39 @@
+package luaparser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenizeStringEscapes(t *testing.T) {
+	assert := require.New(t)
+
+	for src, expected := range map[string]string{
+		// `\z` skips the whitespace after it, including newlines
+		"s = 'a\\z\n    b' x":     "'a\\z\n    b'",
+		"s = \"a\\z  \r\n\tb\" x": "\"a\\z  \r\n\tb\"",
+		"s = 'a\\z' x":            "'a\\z'",
+		// an escaped newline is a newline in the string
+		"s = 'a\\\nb' x":   "'a\\\nb'",
+		"s = 'a\\\r\nb' x": "'a\\\r\nb'",
+		"s = 'a\\\n\rb' x": "'a\\\n\rb'",
+	} {
+		tokens, err := Tokenize(src)
+		assert.NoError(err, src)
+		assert.Equal(5, len(tokens), src)
+		assert.Equal(TOKEN_STRING, tokens[2].Type, src)
+		assert.Equal(expected, tokens[2].Value, src)
+		assert.Equal("x", tokens[3].Value, src)
+	}
+
+	// the line of the tokens after it is counted
+	tokens, err := Tokenize("s = 'a\\z\n\n    b' x")
+	assert.NoError(err)
+	assert.Equal(3, tokens[3].Line)
+
+	// `\z` doesn't skip anything else, a newline without an escape still ends the string
+	_, err = Tokenize("s = 'a\\z b\nc'")
+	assert.Error(err)
+	assert.Equal("[1:5] unfinished string near ''a\\z b'", err.Error())
+}
@@ -0,0 +1,882 @@
+package luaparser
+
+import (
+	"fmt"
+)
+
+type SyntaxError struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("[%d:%d] %s", e.Line, e.Column, e.Message)
+}
+
+// Local is a local variable, function parameter or for loop variable
+type Local struct {
+	Name    string
+	Token   *Token   // nil for the implicit `self` of methods
+	Visible []*Local // the locals which were in scope when this local came into scope
+}
+
+// Var is a name that refers to a variable, either a local or a global
+type Var struct {
+	Token  *Token
+	Local  *Local // nil when it's a global
+	Assign bool   // when it's declared or assigned to, instead of read
+	Depth  int    // how many functions deep, 0 means it's executed when the chunk is loaded
+}
+
+// Chunk is the result of parsing, we don't keep a full syntax tree since we only need to know about the variables
+type Chunk struct {
+	Tokens []*Token
+	Vars   []*Var
+	Locals []*Local // in the order they came into scope
+}
+
+// binary operator priorities (left, right), the same as the lua parser uses
+var binaryPriority = map[string][2]int{
+	"or": {1, 1}, "and": {2, 2},
+	"<": {3, 3}, ">": {3, 3}, "<=": {3, 3}, ">=": {3, 3}, "~=": {3, 3}, "==": {3, 3},
+	"|": {4, 4}, "~": {5, 5}, "&": {6, 6}, "<<": {7, 7}, ">>": {7, 7},
+	"..": {9, 8}, // right associative
+	"+":  {10, 10}, "-": {10, 10},
+	"*": {11, 11}, "/": {11, 11}, "//": {11, 11}, "%": {11, 11},
+	"^": {14, 13}, // right associative
+}
+
+const unaryPriority = 12
+
+type parser struct {
+	tokens []*Token
+	pos    int
+	chunk  *Chunk
+	active []*Local // stack of locals in scope
+	depth  int
+	vararg []bool // stack of functions, true when the function accepts `...`
+}
+
+// Parse parses lua 5.3 source, returns a *SyntaxError when the source isn't valid
+func Parse(src string) (*Chunk, error) {
+	tokens, err := Tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{
+		tokens: tokens,
+		chunk: &Chunk{
+			Tokens: tokens,
+			Vars:   make([]*Var, 0),
+			Locals: make([]*Local, 0),
+		},
+		vararg: []bool{true}, // the main chunk is a vararg function
+	}
+
+	err = p.block()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek().Type != TOKEN_EOF {
+		return nil, p.unexpected(p.peek())
+	}
+
+	return p.chunk, nil
+}
+
+func (p *parser) peek() *Token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) peekN(n int) *Token {
+	if p.pos+n >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+
+	return p.tokens[p.pos+n]
+}
+
+func (p *parser) next() *Token {
+	t := p.tokens[p.pos]
+	if t.Type != TOKEN_EOF {
+		p.pos++
+	}
+
+	return t
+}
+
+// check if the current token is the keyword or symbol
+func (p *parser) check(value string) bool {
+	t := p.peek()
+	return (t.Type == TOKEN_SYMBOL || t.Type == TOKEN_KEYWORD) && t.Value == value
+}
+
+func (p *parser) accept(value string) bool {
+	if p.check(value) {
+		p.next()
+		return true
+	}
+
+	return false
+}
+
+func (p *parser) expect(value string) error {
+	if !p.accept(value) {
+		return p.errorf(p.peek(), "'%s' expected near '%s'", value, p.peek().display())
+	}
+
+	return nil
+}
+
+// expectMatch is for closing tokens, so the error can point to where the opening token was
+func (p *parser) expectMatch(value string, open string, openToken *Token) error {
+	if !p.accept(value) {
+		if openToken.Line == p.peek().Line {
+			return p.expect(value)
+		}
+
+		return p.errorf(p.peek(), "'%s' expected (to close '%s' at line %d) near '%s'", value, open, openToken.Line, p.peek().display())
+	}
+
+	return nil
+}
+
+func (p *parser) expectName() (*Token, error) {
+	t := p.peek()
+	if t.Type != TOKEN_NAME {
+		return nil, p.errorf(t, "<name> expected near '%s'", t.display())
+	}
+
+	return p.next(), nil
+}
+
+func (p *parser) errorf(t *Token, format string, args ...interface{}) error {
+	return &SyntaxError{
+		Line:    t.Line,
+		Column:  t.Column,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+func (p *parser) unexpected(t *Token) error {
+	if t.Type == TOKEN_EOF {
+		return p.errorf(t, "unexpected <eof>")
+	}
+
+	near := p.tokens[len(p.tokens)-1]
+	for k, token := range p.tokens {
+		if token == t && k+1 < len(p.tokens) {
+			near = p.tokens[k+1]
+			break
+		}
+	}
+
+	return p.errorf(t, "unexpected %s '%s' near '%s'", t.Type, t.display(), near.display())
+}
+
+func (p *parser) openScope() int {
+	return len(p.active)
+}
+
+func (p *parser) closeScope(scope int) {
+	p.active = p.active[:scope]
+}
+
+func (p *parser) newLocal(t *Token) *Local {
+	l := &Local{
+		Name:  t.Value,
+		Token: t,
+	}
+
+	p.chunk.Vars = append(p.chunk.Vars, &Var{
+		Token:  t,
+		Local:  l,
+		Assign: true,
+		Depth:  p.depth,
+	})
+
+	return l
+}
+
+// activate brings locals into scope, which for `local x = x` only happens after the expressions are parsed
+func (p *parser) activate(locals ...*Local) {
+	for _, l := range locals {
+		l.Visible = make([]*Local, len(p.active))
+		copy(l.Visible, p.active)
+
+		p.active = append(p.active, l)
+		p.chunk.Locals = append(p.chunk.Locals, l)
+	}
+}
+
+func (p *parser) resolve(t *Token) *Var {
+	v := &Var{
+		Token: t,
+		Depth: p.depth,
+	}
+
+	for i := len(p.active) - 1; i >= 0; i-- {
+		if p.active[i].Name == t.Value {
+			v.Local = p.active[i]
+			break
+		}
+	}
+
+	p.chunk.Vars = append(p.chunk.Vars, v)
+
+	return v
+}
+
+func (p *parser) blockFollow() bool {
+	t := p.peek()
+	if t.Type == TOKEN_EOF {
+		return true
+	}
+	if t.Type != TOKEN_KEYWORD {
+		return false
+	}
+
+	switch t.Value {
+	case "else", "elseif", "end", "until":
+		return true
+	}
+
+	return false
+}
+
+// block parses a block in its own scope
+func (p *parser) block() error {
+	scope := p.openScope()
+	defer p.closeScope(scope)
+
+	return p.statements()
+}
+
+// statements parses the statements of a block without opening a scope
+func (p *parser) statements() error {
+	for !p.blockFollow() {
+		if p.check("return") {
+			return p.returnStatement()
+		}
+
+		err := p.statement()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *parser) returnStatement() error {
+	p.next()
+
+	if !p.blockFollow() && !p.check(";") {
+		err := p.exprList()
+		if err != nil {
+			return err
+		}
+	}
+
+	p.accept(";")
+
+	// return has to be the last statement in a block
+	if !p.blockFollow() {
+		return p.errorf(p.peek(), "'<eof>' expected near '%s'", p.peek().display())
+	}
+
+	return nil
+}
+
+func (p *parser) statement() error {
+	t := p.peek()
+
+	if t.Type == TOKEN_SYMBOL {
+		switch t.Value {
+		case ";":
+			p.next()
+			return nil
+		case "::":
+			p.next()
+			_, err := p.expectName()
+			if err != nil {
+				return err
+			}
+			return p.expect("::")
+		}
+	}
+
+	if t.Type == TOKEN_KEYWORD {
+		switch t.Value {
+		case "if":
+			return p.ifStatement()
+		case "while":
+			p.next()
+			err := p.expr(0)
+			if err != nil {
+				return err
+			}
+			err = p.expect("do")
+			if err != nil {
+				return err
+			}
+			err = p.block()
+			if err != nil {
+				return err
+			}
+			return p.expectMatch("end", "while", t)
+		case "do":
+			p.next()
+			err := p.block()
+			if err != nil {
+				return err
+			}
+			return p.expectMatch("end", "do", t)
+		case "for":
+			return p.forStatement()
+		case "repeat":
+			p.next()
+			// the condition can see the locals from the block
+			scope := p.openScope()
+			defer p.closeScope(scope)
+
+			err := p.statements()
+			if err != nil {
+				return err
+			}
+			err = p.expectMatch("until", "repeat", t)
+			if err != nil {
+				return err
+			}
+			return p.expr(0)
+		case "function":
+			return p.functionStatement()
+		case "local":
+			p.next()
+			if p.accept("function") {
+				return p.localFunctionStatement()
+			}
+			return p.localStatement()
+		case "break":
+			p.next()
+			return nil
+		case "goto":
+			p.next()
+			_, err := p.expectName()
+			return err
+		}
+	}
+
+	return p.exprStatement()
+}
+
+func (p *parser) ifStatement() error {
+	t := p.next()
+
+	for {
+		err := p.expr(0)
+		if err != nil {
+			return err
+		}
+		err = p.expect("then")
+		if err != nil {
+			return err
+		}
+		err = p.block()
+		if err != nil {
+			return err
+		}
+
+		if !p.accept("elseif") {
+			break
+		}
+	}
+
+	if p.accept("else") {
+		err := p.block()
+		if err != nil {
+			return err
+		}
+	}
+
+	return p.expectMatch("end", "if", t)
+}
+
+func (p *parser) forStatement() error {
+	t := p.next()
+
+	name, err := p.expectName()
+	if err != nil {
+		return err
+	}
+
+	scope := p.openScope()
+	defer p.closeScope(scope)
+
+	locals := []*Local{p.newLocal(name)}
+
+	if p.accept("=") {
+		// numeric for
+		err := p.expr(0)
+		if err != nil {
+			return err
+		}
+		err = p.expect(",")
+		if err != nil {
+			return err
+		}
+		err = p.expr(0)
+		if err != nil {
+			return err
+		}
+		if p.accept(",") {
+			err = p.expr(0)
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		// generic for
+		for p.accept(",") {
+			name, err := p.expectName()
+			if err != nil {
+				return err
+			}
+			locals = append(locals, p.newLocal(name))
+		}
+
+		err := p.expect("in")
+		if err != nil {
+			return err
+		}
+
+		err = p.exprList()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = p.expect("do")
+	if err != nil {
+		return err
+	}
+
+	p.activate(locals...)
+
+	err = p.block()
+	if err != nil {
+		return err
+	}
+
+	return p.expectMatch("end", "for", t)
+}
+
+func (p *parser) functionStatement() error {
+	t := p.next()
+
+	name, err := p.expectName()
+	if err != nil {
+		return err
+	}
+
+	v := p.resolve(name)
+
+	isMethod := false
+	isField := false
+	for p.check(".") || p.check(":") {
+		isField = true
+		isMethod = p.check(":")
+		p.next()
+
+		_, err := p.expectName()
+		if err != nil {
+			return err
+		}
+
+		if isMethod {
+			break
+		}
+	}
+
+	// `function a()` assigns to a, `function a.b()` only reads a
+	v.Assign = !isField
+
+	return p.functionBody(t, isMethod)
+}
+
+func (p *parser) localFunctionStatement() error {
+	name, err := p.expectName()
+	if err != nil {
+		return err
+	}
+
+	// the function can refer to itself, so it's in scope before the body
+	p.activate(p.newLocal(name))
+
+	return p.functionBody(name, false)
+}
+
+func (p *parser) localStatement() error {
+	locals := make([]*Local, 0)
+
+	for {
+		name, err := p.expectName()
+		if err != nil {
+			return err
+		}
+		locals = append(locals, p.newLocal(name))
+
+		if !p.accept(",") {
+			break
+		}
+	}
+
+	if p.accept("=") {
+		err := p.exprList()
+		if err != nil {
+			return err
+		}
+	}
+
+	p.activate(locals...)
+
+	return nil
+}
+
+func (p *parser) exprStatement() error {
+	e, err := p.suffixedExpr()
+	if err != nil {
+		return err
+	}
+
+	if p.check("=") || p.check(",") {
+		targets := []*suffixedExprInfo{e}
+		for p.accept(",") {
+			e, err := p.suffixedExpr()
+			if err != nil {
+				return err
+			}
+			targets = append(targets, e)
+		}
+
+		for _, target := range targets {
+			if target.isCall || target.isParen {
+				return p.errorf(target.token, "syntax error near '%s'", p.peek().display())
+			}
+			if target.bareName != nil {
+				target.bareName.Assign = true
+			}
+		}
+
+		err := p.expect("=")
+		if err != nil {
+			return err
+		}
+
+		return p.exprList()
+	}
+
+	if !e.isCall {
+		return p.errorf(p.peek(), "syntax error near '%s'", p.peek().display())
+	}
+
+	return nil
+}
+
+func (p *parser) functionBody(t *Token, isMethod bool) error {
+	p.depth++
+	defer func() { p.depth-- }()
+
+	scope := p.openScope()
+	defer p.closeScope(scope)
+
+	locals := make([]*Local, 0)
+	if isMethod {
+		locals = append(locals, &Local{Name: "self"})
+	}
+
+	vararg := false
+
+	err := p.expect("(")
+	if err != nil {
+		return err
+	}
+
+	if !p.check(")") {
+		for {
+			if p.accept("...") {
+				vararg = true
+				break
+			}
+
+			name, err := p.expectName()
+			if err != nil {
+				return err
+			}
+			locals = append(locals, p.newLocal(name))
+
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+
+	err = p.expect(")")
+	if err != nil {
+		return err
+	}
+
+	p.activate(locals...)
+
+	p.vararg = append(p.vararg, vararg)
+	defer func() { p.vararg = p.vararg[:len(p.vararg)-1] }()
+
+	err = p.block()
+	if err != nil {
+		return err
+	}
+
+	return p.expectMatch("end", "function", t)
+}
+
+func (p *parser) exprList() error {
+	for {
+		err := p.expr(0)
+		if err != nil {
+			return err
+		}
+
+		if !p.accept(",") {
+			return nil
+		}
+	}
+}
+
+// expr parses a (sub)expression where the binary operators bind stronger than limit
+func (p *parser) expr(limit int) error {
+	t := p.peek()
+	if (t.Type == TOKEN_KEYWORD && t.Value == "not") || (t.Type == TOKEN_SYMBOL && (t.Value == "-" || t.Value == "#" || t.Value == "~")) {
+		p.next()
+		err := p.expr(unaryPriority)
+		if err != nil {
+			return err
+		}
+	} else {
+		err := p.simpleExpr()
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		t := p.peek()
+		if t.Type != TOKEN_SYMBOL && t.Type != TOKEN_KEYWORD {
+			return nil
+		}
+
+		priority, ok := binaryPriority[t.Value]
+		if !ok || priority[0] <= limit {
+			return nil
+		}
+
+		p.next()
+		err := p.expr(priority[1])
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (p *parser) simpleExpr() error {
+	t := p.peek()
+
+	switch t.Type {
+	case TOKEN_NUMBER, TOKEN_STRING:
+		p.next()
+		return nil
+	case TOKEN_KEYWORD:
+		switch t.Value {
+		case "nil", "true", "false":
+			p.next()
+			return nil
+		case "function":
+			p.next()
+			return p.functionBody(t, false)
+		}
+	case TOKEN_SYMBOL:
+		switch t.Value {
+		case "...":
+			if !p.vararg[len(p.vararg)-1] {
+				return p.errorf(t, "cannot use '...' outside a vararg function near '...'")
+			}
+			p.next()
+			return nil
+		case "{":
+			return p.table()
+		}
+	}
+
+	_, err := p.suffixedExpr()
+	return err
+}
+
+type suffixedExprInfo struct {
+	token    *Token
+	bareName *Var // set when the expression is just a name
+	isCall   bool
+	isParen  bool
+}
+
+func (p *parser) primaryExpr() (*suffixedExprInfo, error) {
+	t := p.peek()
+
+	if t.Type == TOKEN_NAME {
+		p.next()
+		return &suffixedExprInfo{token: t, bareName: p.resolve(t)}, nil
+	}
+
+	if p.check("(") {
+		p.next()
+		err := p.expr(0)
+		if err != nil {
+			return nil, err
+		}
+		err = p.expectMatch(")", "(", t)
+		if err != nil {
+			return nil, err
+		}
+
+		return &suffixedExprInfo{token: t, isParen: true}, nil
+	}
+
+	return nil, p.unexpected(t)
+}
+
+func (p *parser) suffixedExpr() (*suffixedExprInfo, error) {
+	e, err := p.primaryExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+
+		switch {
+		case p.check("."):
+			p.next()
+			_, err := p.expectName()
+			if err != nil {
+				return nil, err
+			}
+			e = &suffixedExprInfo{token: e.token}
+
+		case p.check("["):
+			p.next()
+			err := p.expr(0)
+			if err != nil {
+				return nil, err
+			}
+			err = p.expectMatch("]", "[", t)
+			if err != nil {
+				return nil, err
+			}
+			e = &suffixedExprInfo{token: e.token}
+
+		case p.check(":"):
+			p.next()
+			_, err := p.expectName()
+			if err != nil {
+				return nil, err
+			}
+			err = p.callArgs()
+			if err != nil {
+				return nil, err
+			}
+			e = &suffixedExprInfo{token: e.token, isCall: true}
+
+		case p.check("(") || p.check("{") || t.Type == TOKEN_STRING:
+			err := p.callArgs()
+			if err != nil {
+				return nil, err
+			}
+			e = &suffixedExprInfo{token: e.token, isCall: true}
+
+		default:
+			return e, nil
+		}
+	}
+}
+
+func (p *parser) callArgs() error {
+	t := p.peek()
+
+	switch {
+	case t.Type == TOKEN_STRING:
+		p.next()
+		return nil
+	case p.check("{"):
+		return p.table()
+	case p.check("("):
+		p.next()
+		if !p.check(")") {
+			err := p.exprList()
+			if err != nil {
+				return err
+			}
+		}
+		return p.expectMatch(")", "(", t)
+	}
+
+	return p.errorf(t, "function arguments expected near '%s'", t.display())
+}
+
+func (p *parser) table() error {
+	t := p.next()
+
+	for !p.check("}") {
+		switch {
+		case p.check("["):
+			open := p.next()
+			err := p.expr(0)
+			if err != nil {
+				return err
+			}
+			err = p.expectMatch("]", "[", open)
+			if err != nil {
+				return err
+			}
+			err = p.expect("=")
+			if err != nil {
+				return err
+			}
+			err = p.expr(0)
+			if err != nil {
+				return err
+			}
+
+		case p.peek().Type == TOKEN_NAME && p.peekN(1).Type == TOKEN_SYMBOL && p.peekN(1).Value == "=":
+			// named field, the name isn't a variable
+			p.next()
+			p.next()
+			err := p.expr(0)
+			if err != nil {
+				return err
+			}
+
+		default:
+			err := p.expr(0)
+			if err != nil {
+				return err
+			}
+		}
+
+		if !p.accept(",") && !p.accept(";") {
+			break
+		}
+	}
+
+	return p.expectMatch("}", "{", t)
+}
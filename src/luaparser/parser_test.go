@@ -0,0 +1,108 @@
+package luaparser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseValid(t *testing.T) {
+	assert := require.New(t)
+
+	for _, src := range []string{
+		"",
+		"local a = 1",
+		"a, b.c, d[1] = 1, 2, 3",
+		"print('hi')",
+		"print 'hi'",
+		"print [[hi]]",
+		"f{1, 2; x = 3, [4] = 5}",
+		"local t = {} t[ [[x]] ] = 1",
+		"function a.b.c:d(x, ...) return self, ... end",
+		"local function f() return f() end",
+		"for i = 1, 10, 2 do end for k, v in pairs({}) do end",
+		"repeat local x = 1 until x == 1",
+		"while true do break end",
+		"if a then elseif b then else end",
+		"goto done ::done::",
+		"local x = 1 // 2 | 3 & 4 ~ 5 << 6 >> 7 .. 8 ^ -9",
+		"local x = not #a == ~b",
+		"return",
+		"return 1;",
+		"do return end",
+		"#!/usr/bin/lua\nprint(1)",
+		"local s = 'a\\'b' .. \"c\\\"d\" .. [==[e]]f]==] --[[ comment ]] -- comment",
+		"local n = 0x1F + 1e10 + .5 + 3. + 0x.8p1",
+	} {
+		_, err := Parse(src)
+		assert.NoError(err, src)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	assert := require.New(t)
+
+	for src, expected := range map[string]string{
+		"14.blabla":                   "[1:1] unexpected number '14' near 'blabla'",
+		"local = 1":                   "[1:7] <name> expected near '='",
+		"x":                           "[1:2] syntax error near '<eof>'",
+		"f() = 1":                     "[1:1] syntax error near '='",
+		"if a then":                   "[1:10] 'end' expected near '<eof>'",
+		"if a then\n\nprint(1)":       "[3:9] 'end' expected (to close 'if' at line 1) near '<eof>'",
+		"print('hi)":                  "[1:7] unfinished string near '<eof>'",
+		"print('hi)\nx":               "[1:7] unfinished string near ''hi)'",
+		"print([[hi)":                 "[1:7] unfinished long string near '<eof>'",
+		"function f() return ... end": "[1:21] cannot use '...' outside a vararg function near '...'",
+		"return 1 print(2)":           "[1:10] '<eof>' expected near 'print'",
+		"a = 1 +":                     "[1:8] unexpected <eof>",
+		"a = 1 $ 2":                   "[1:7] unexpected character '$'",
+	} {
+		_, err := Parse(src)
+		assert.Error(err, src)
+		assert.Equal(expected, err.Error(), src)
+	}
+}
+
+func TestParseVars(t *testing.T) {
+	assert := require.New(t)
+
+	chunk, err := Parse(`
+local a = b
+function c(d)
+	e = a + d
+	local function f() return f end
+end
+g.h = 1
+`)
+	assert.NoError(err)
+
+	type v struct {
+		name   string
+		local  bool
+		assign bool
+		depth  int
+	}
+
+	vars := make([]v, len(chunk.Vars))
+	for k, vv := range chunk.Vars {
+		vars[k] = v{vv.Token.Value, vv.Local != nil, vv.Assign, vv.Depth}
+	}
+
+	assert.Equal([]v{
+		{"a", true, true, 0},
+		{"b", false, false, 0},
+		{"c", false, true, 0},
+		{"d", true, true, 1},
+		{"e", false, true, 1},
+		{"a", true, false, 1},
+		{"d", true, false, 1},
+		{"f", true, true, 1},
+		{"f", true, false, 2},
+		{"g", false, false, 0},
+	}, vars)
+
+	assert.Equal(3, len(chunk.Locals))
+	assert.Equal("d", chunk.Locals[1].Name)
+	assert.Equal(1, len(chunk.Locals[1].Visible))
+	assert.Equal("a", chunk.Locals[1].Visible[0].Name)
+}
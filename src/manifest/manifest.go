@@ -0,0 +1,476 @@
+package manifest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rubensayshi/dubby/src/dustructs"
+	"gopkg.in/yaml.v2"
+)
+
+const MANIFEST_FILE = "dubby.yaml"
+
+const (
+	FORMAT_JSON = "json"
+	FORMAT_YAML = "yaml"
+)
+
+const (
+	LINE_ENDINGS_LF   = "lf"
+	LINE_ENDINGS_CRLF = "crlf"
+)
+
+// Manifest holds the build settings of a project, it's placed in the root of the project
+// and all paths in it are relative to the directory it's in.
+type Manifest struct {
+	dir string
+
+	Src         string        `yaml:"src"`
+	Layout      *Layout       `yaml:"layout"`
+	Minify      bool          `yaml:"minify"`
+	Minifier    string        `yaml:"minifier"`
+	Renumber    bool          `yaml:"renumber"`
+	Indent      Indent        `yaml:"indent"`
+	LineEndings string        `yaml:"lineEndings"`
+	Outputs     []*Output     `yaml:"outputs"`
+	Slots       map[int]*Slot `yaml:"slots"`
+	Libs        []*LibPath    `yaml:"libs"`
+	Modules     []string      `yaml:"modules"`
+	Filters     []*Filter     `yaml:"filters"`
+	Units       []*Unit       `yaml:"units"`
+}
+
+// Unit is a programming board (or control unit, etc.) in a workspace,
+// each with its own source directory and outputs but sharing the libs and other settings of the manifest.
+type Unit struct {
+	Name    string        `yaml:"name"`
+	Src     string        `yaml:"src"`
+	Libs    []*LibPath    `yaml:"libs"`
+	Modules []string      `yaml:"modules"`
+	Outputs []*Output     `yaml:"outputs"`
+	Slots   map[int]*Slot `yaml:"slots"`
+}
+
+// LibPath is a lib directory outside of the source directory, optionally only including some of its files,
+// in the manifest it can also be just the path.
+type LibPath struct {
+	Path    string   `yaml:"path"`
+	Include []string `yaml:"include"`
+}
+
+func (l *LibPath) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var p string
+	if err := unmarshal(&p); err == nil {
+		l.Path = p
+		return nil
+	}
+
+	type libPathYaml LibPath
+	err := unmarshal((*libPathYaml)(l))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// Filter is an extra filter signature, for filters which are missing from the catalogue of dubby (eg; after a game update),
+// when a class is specified it's only added for that element class. In the manifest it can also be just the signature.
+type Filter struct {
+	Signature string `yaml:"signature"`
+	Class     string `yaml:"class"`
+}
+
+func (f *Filter) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var signature string
+	if err := unmarshal(&signature); err == nil {
+		f.Signature = signature
+		return nil
+	}
+
+	type filterYaml Filter
+	err := unmarshal((*filterYaml)(f))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// Layout are the names of the directories inside the source directory
+type Layout struct {
+	Slots string `yaml:"slots"`
+	Lib   string `yaml:"lib"`
+}
+
+// Output is a file that's built from the source directory, the format defaults to the file extension
+type Output struct {
+	File   string `yaml:"file"`
+	Format string `yaml:"format"`
+	Name   string `yaml:"name"` // name of the auto configure module
+}
+
+// Slot is the metadata for a slot, which can't be derived from the source files
+type Slot struct {
+	Name   string `yaml:"name"`
+	Class  string `yaml:"class"`
+	Select string `yaml:"select"`
+}
+
+// Indent is the indenting used for the code inside handlers, in the manifest it can be
+// a number of spaces, `tab` or the literal string.
+type Indent string
+
+func (i *Indent) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var n int
+	if err := unmarshal(&n); err == nil {
+		*i = Indent(strings.Repeat(" ", n))
+		return nil
+	}
+
+	var s string
+	err := unmarshal(&s)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if s == "tab" {
+		s = "\t"
+	}
+
+	*i = Indent(s)
+
+	return nil
+}
+
+// NewManifest creates a manifest with the default settings, which match how dubby works without a manifest
+func NewManifest(dir string) *Manifest {
+	return &Manifest{
+		dir: dir,
+		Layout: &Layout{
+			Slots: "slots",
+			Lib:   "lib",
+		},
+		Minifier:    "native",
+		Indent:      "    ",
+		LineEndings: LINE_ENDINGS_LF,
+		Outputs:     make([]*Output, 0),
+		Slots:       make(map[int]*Slot),
+		Libs:        make([]*LibPath, 0),
+		Modules:     make([]string, 0),
+		Filters:     make([]*Filter, 0),
+		Units:       make([]*Unit, 0),
+	}
+}
+
+// Find looks for the manifest in dir and all of its parents, returns an empty string when there's none
+func Find(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	for {
+		manifestFile := filepath.Join(dir, MANIFEST_FILE)
+		info, err := os.Stat(manifestFile)
+		if err == nil && !info.IsDir() {
+			return manifestFile, nil
+		} else if err != nil && !os.IsNotExist(err) {
+			return "", errors.WithStack(err)
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// FindAndLoad finds the manifest starting from dir, returns nil when there's none
+func FindAndLoad(dir string) (*Manifest, error) {
+	manifestFile, err := Find(dir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if manifestFile == "" {
+		return nil, nil
+	}
+
+	return Load(manifestFile)
+}
+
+func Load(manifestFile string) (*Manifest, error) {
+	buf, err := ioutil.ReadFile(manifestFile)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	abs, err := filepath.Abs(manifestFile)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	m := NewManifest(filepath.Dir(abs))
+	err = yaml.UnmarshalStrict(buf, m)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s", manifestFile)
+	}
+
+	err = m.validate()
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid %s", manifestFile)
+	}
+
+	return m, nil
+}
+
+func (m *Manifest) validate() error {
+	if m.Layout == nil {
+		m.Layout = &Layout{}
+	}
+	if m.Layout.Slots == "" {
+		m.Layout.Slots = "slots"
+	}
+	if m.Layout.Lib == "" {
+		m.Layout.Lib = "lib"
+	}
+
+	switch m.LineEndings {
+	case LINE_ENDINGS_LF, LINE_ENDINGS_CRLF:
+	default:
+		return errors.Errorf("unknown lineEndings `%s`, expected `%s` or `%s`", m.LineEndings, LINE_ENDINGS_LF, LINE_ENDINGS_CRLF)
+	}
+
+	err := validateOutputs(m.Outputs)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = validateLibs(m.Libs)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for k, filter := range m.Filters {
+		if filter.Signature == "" {
+			return errors.Errorf("filter [%d] has no signature", k)
+		}
+	}
+
+	names := make(map[string]bool, len(m.Units))
+	for k, unit := range m.Units {
+		if unit.Name == "" {
+			return errors.Errorf("unit [%d] has no name", k)
+		}
+		if names[unit.Name] {
+			return errors.Errorf("duplicate unit name: %s", unit.Name)
+		}
+		names[unit.Name] = true
+
+		if unit.Src == "" {
+			return errors.Errorf("unit [%s] has no src", unit.Name)
+		}
+
+		err := validateOutputs(unit.Outputs)
+		if err != nil {
+			return errors.Wrapf(err, "unit [%s]", unit.Name)
+		}
+
+		err = validateLibs(unit.Libs)
+		if err != nil {
+			return errors.Wrapf(err, "unit [%s]", unit.Name)
+		}
+
+		// default the name of the auto configure module to the name of the unit
+		for _, output := range unit.Outputs {
+			if output.Name == "" {
+				output.Name = unit.Name
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateLibs(libs []*LibPath) error {
+	for k, lib := range libs {
+		if lib.Path == "" {
+			return errors.Errorf("lib [%d] has no path", k)
+		}
+	}
+
+	return nil
+}
+
+func validateOutputs(outputs []*Output) error {
+	for k, output := range outputs {
+		if output.File == "" {
+			return errors.Errorf("output [%d] has no file", k)
+		}
+
+		if output.Format == "" {
+			output.Format = FormatFromFile(output.File)
+		}
+
+		switch output.Format {
+		case FORMAT_JSON, FORMAT_YAML:
+		default:
+			return errors.Errorf("output [%s] has unknown format `%s`, expected `%s` or `%s`", output.File, output.Format, FORMAT_JSON, FORMAT_YAML)
+		}
+	}
+
+	return nil
+}
+
+// Dir is the directory the manifest is in
+func (m *Manifest) Dir() string {
+	return m.dir
+}
+
+// Path makes a path from the manifest absolute
+func (m *Manifest) Path(p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+
+	return filepath.Join(m.dir, filepath.FromSlash(p))
+}
+
+// LineEnding is the line ending to use when writing source files
+func (m *Manifest) LineEnding() string {
+	if m.LineEndings == LINE_ENDINGS_CRLF {
+		return "\r\n"
+	}
+
+	return "\n"
+}
+
+// Unit returns the unit with the specified name, or nil when there's none
+func (m *Manifest) Unit(name string) *Unit {
+	for _, unit := range m.Units {
+		if unit.Name == name {
+			return unit
+		}
+	}
+
+	return nil
+}
+
+// DefaultUnit is the unit made up of the top-level src, outputs and slots,
+// which is what's used when the manifest isn't a workspace.
+func (m *Manifest) DefaultUnit() *Unit {
+	return &Unit{
+		Name:    filepath.Base(m.Path(m.Src)),
+		Src:     m.Src,
+		Libs:    make([]*LibPath, 0),
+		Modules: make([]string, 0),
+		Outputs: m.Outputs,
+		Slots:   m.Slots,
+	}
+}
+
+// AllUnits returns the units of the workspace, or the DefaultUnit when it isn't a workspace
+func (m *Manifest) AllUnits() []*Unit {
+	if len(m.Units) == 0 {
+		return []*Unit{m.DefaultUnit()}
+	}
+
+	return m.Units
+}
+
+// SrcDir is the absolute path of the source directory of the unit
+func (m *Manifest) SrcDir(unit *Unit) string {
+	return m.Path(unit.Src)
+}
+
+// LibDirs are the shared lib directories (with absolute paths) for the unit, in the order they should be included
+func (m *Manifest) LibDirs(unit *Unit) []*LibPath {
+	libDirs := make([]*LibPath, 0, len(m.Libs)+len(unit.Libs))
+	for _, lib := range append(append([]*LibPath{}, m.Libs...), unit.Libs...) {
+		libDirs = append(libDirs, &LibPath{
+			Path:    m.Path(lib.Path),
+			Include: lib.Include,
+		})
+	}
+
+	return libDirs
+}
+
+// ModuleDirs are the absolute paths of the directories to look for required modules for the unit
+func (m *Manifest) ModuleDirs(unit *Unit) []string {
+	return m.paths(m.Modules, unit.Modules)
+}
+
+func (m *Manifest) paths(shared []string, unit []string) []string {
+	paths := make([]string, 0, len(shared)+len(unit))
+	for _, p := range append(append([]string{}, shared...), unit...) {
+		paths = append(paths, m.Path(p))
+	}
+
+	return paths
+}
+
+// Output returns the first output with the specified format, or nil when there's none
+func (u *Unit) Output(format string) *Output {
+	for _, output := range u.Outputs {
+		if output.Format == format {
+			return output
+		}
+	}
+
+	return nil
+}
+
+// Slot returns the metadata for a slot, or nil when there's none
+func (u *Unit) Slot(slotKey int) *Slot {
+	return u.Slots[slotKey]
+}
+
+// FormatFromFile detects the format from the file extension, auto configure files are yaml and anything else is json
+func FormatFromFile(file string) string {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml", ".conf":
+		return FORMAT_YAML
+	default:
+		return FORMAT_JSON
+	}
+}
+
+// ApplyToScriptExport sets the names of the slots from the manifest, adding any slots that are missing
+func (u *Unit) ApplyToScriptExport(e *dustructs.ScriptExport) {
+	for slotKey, slot := range u.Slots {
+		if e.Slots[slotKey] == nil {
+			e.Slots[slotKey] = dustructs.NewSlot(slot.Name)
+		} else if slot.Name != "" {
+			e.Slots[slotKey].Name = slot.Name
+		}
+	}
+}
+
+// ApplyToAutoConf sets the class and select of the slots from the manifest,
+// e should be the ScriptExport the AutoConf was created from.
+func (u *Unit) ApplyToAutoConf(e *dustructs.ScriptExport, a *dustructs.AutoConf) {
+	for slotKey, slot := range u.Slots {
+		if e.Slots[slotKey] == nil {
+			continue
+		}
+
+		for _, autoConfSlot := range a.Slots {
+			if autoConfSlot.Name != e.Slots[slotKey].Name {
+				continue
+			}
+
+			if slot.Class != "" {
+				autoConfSlot.Class = slot.Class
+			}
+			if slot.Select != "" {
+				autoConfSlot.Select = slot.Select
+			}
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package manifest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rubensayshi/dubby/src/dustructs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindAndLoad(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "dubby")
+	assert.NoError(err)
+	defer os.RemoveAll(dir) // always cleanup the mess
+
+	sub := filepath.Join(dir, "a", "b")
+	assert.NoError(os.MkdirAll(sub, 0777))
+
+	// there might be a dubby.yaml somewhere above the temp dir, but not in it
+	m, err := FindAndLoad(dir)
+	assert.NoError(err)
+	if m != nil {
+		assert.NotEqual(dir, m.Dir())
+	}
+
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, MANIFEST_FILE), []byte(`
+src: code
+layout:
+  slots: handlers
+minify: true
+indent: 2
+lineEndings: crlf
+outputs:
+  - file: build/export.json
+  - file: build/module.conf
+    name: mymodule
+slots:
+  0:
+    name: screen
+    class: ScreenUnit
+filters:
+  - onOpen(id)
+  - signature: opened(id)
+    class: DoorUnit
+`), 0666))
+
+	m, err = FindAndLoad(sub)
+	assert.NoError(err)
+	assert.NotNil(m)
+
+	unit := m.DefaultUnit()
+
+	assert.Equal(dir, m.Dir())
+	assert.Equal(filepath.Join(dir, "code"), m.SrcDir(unit))
+	assert.Equal("handlers", m.Layout.Slots)
+	assert.Equal("lib", m.Layout.Lib)
+	assert.True(m.Minify)
+	assert.Equal("native", m.Minifier)
+	assert.Equal(Indent("  "), m.Indent)
+	assert.Equal("\r\n", m.LineEnding())
+	assert.Equal(FORMAT_JSON, m.Outputs[0].Format)
+	assert.Equal(FORMAT_YAML, m.Outputs[1].Format)
+	assert.Equal("build/module.conf", unit.Output(FORMAT_YAML).File)
+	assert.Equal(filepath.Join(dir, "build", "module.conf"), m.Path(unit.Output(FORMAT_YAML).File))
+	assert.Equal("ScreenUnit", unit.Slot(0).Class)
+	assert.Equal([]*Unit{unit}, m.AllUnits())
+	assert.Equal([]*Filter{{Signature: "onOpen(id)"}, {Signature: "opened(id)", Class: "DoorUnit"}}, m.Filters)
+}
+
+func TestLoadInvalid(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "dubby")
+	assert.NoError(err)
+	defer os.RemoveAll(dir) // always cleanup the mess
+
+	manifestFile := filepath.Join(dir, MANIFEST_FILE)
+
+	for _, content := range []string{
+		"lineEndings: cr",
+		"outputs: [{file: out.json, format: xml}]",
+		"outputs: [{format: json}]",
+		"unknown: field",
+		"units: [{src: a}]",
+		"libs: [{include: [a]}]",
+		"filters: [{class: DoorUnit}]",
+		"units: [{name: a}]",
+		"units: [{name: a, src: a}, {name: a, src: b}]",
+	} {
+		assert.NoError(ioutil.WriteFile(manifestFile, []byte(content), 0666))
+
+		_, err := Load(manifestFile)
+		assert.Error(err, content)
+	}
+
+	assert.NoError(ioutil.WriteFile(manifestFile, []byte("indent: tab"), 0666))
+	m, err := Load(manifestFile)
+	assert.NoError(err)
+	assert.Equal(Indent("\t"), m.Indent)
+}
+
+func TestApplyTo(t *testing.T) {
+	assert := require.New(t)
+
+	m := NewManifest("")
+	m.Slots[0] = &Slot{Name: "screen", Class: "ScreenUnit"}
+	m.Slots[1] = &Slot{Name: "door", Select: "all"}
+	unit := m.DefaultUnit()
+
+	e := dustructs.NewScriptExport()
+	e.Slots[0] = dustructs.NewSlot("slot1")
+
+	unit.ApplyToScriptExport(e)
+	assert.Equal("screen", e.Slots[0].Name)
+	assert.Equal("door", e.Slots[1].Name)
+
+	a, err := dustructs.NewAutoConfFromScriptExport("test", e)
+	assert.NoError(err)
+
+	unit.ApplyToAutoConf(e, a)
+	assert.Equal([]*dustructs.AutoConfSlot{
+		{Name: "screen", Class: "ScreenUnit"},
+		{Name: "door", Select: "all"},
+	}, a.Slots)
+}
+
+func TestWorkspace(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "dubby")
+	assert.NoError(err)
+	defer os.RemoveAll(dir) // always cleanup the mess
+
+	manifestFile := filepath.Join(dir, MANIFEST_FILE)
+	assert.NoError(ioutil.WriteFile(manifestFile, []byte(`
+libs: [shared/lib]
+units:
+  - name: control
+    src: units/control
+    outputs:
+      - file: build/control.json
+  - name: door
+    src: units/door
+    libs:
+      - path: shared/doors
+        include: [doors/*, vec]
+    outputs:
+      - file: build/door.conf
+`), 0666))
+
+	m, err := Load(manifestFile)
+	assert.NoError(err)
+
+	assert.Equal(2, len(m.AllUnits()))
+	assert.Nil(m.Unit("screen"))
+
+	control := m.Unit("control")
+	assert.Equal(filepath.Join(dir, "units", "control"), m.SrcDir(control))
+	assert.Equal([]*LibPath{{Path: filepath.Join(dir, "shared", "lib")}}, m.LibDirs(control))
+
+	door := m.Unit("door")
+	assert.Equal([]*LibPath{
+		{Path: filepath.Join(dir, "shared", "lib")},
+		{Path: filepath.Join(dir, "shared", "doors"), Include: []string{"doors/*", "vec"}},
+	}, m.LibDirs(door))
+	assert.Equal("door", door.Output(FORMAT_YAML).Name)
+	assert.Nil(door.Output(FORMAT_JSON))
+}
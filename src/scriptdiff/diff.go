@@ -0,0 +1,259 @@
+package scriptdiff
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/rubensayshi/dubby/src/dustructs"
+	"github.com/rubensayshi/dubby/src/srcutils"
+)
+
+// CONTEXT is the number of unchanged lines around the changes in the diffs of the code
+const CONTEXT = 3
+
+// SlotChange is a slot that's only in one of the exports (Old or New is nil) or of which the name changed
+type SlotChange struct {
+	Key int
+	Old *dustructs.Slot
+	New *dustructs.Slot
+}
+
+// HandlerChange is a handler that's only in one of the exports (Old or New is nil) or that changed,
+// handlers are paired up by their slot and the name of their filter, in the order of their keys.
+type HandlerChange struct {
+	SlotKey int
+	Old     *dustructs.Handler
+	New     *dustructs.Handler
+}
+
+// FilterChanged checks if the signature or the args of the filter changed
+func (c *HandlerChange) FilterChanged() bool {
+	return c.Old != nil && c.New != nil &&
+		(c.Old.Filter.Signature != c.New.Filter.Signature || !reflect.DeepEqual(argValues(c.Old.Filter.Args), argValues(c.New.Filter.Args)))
+}
+
+// CodeChanged checks if the code changed
+func (c *HandlerChange) CodeChanged() bool {
+	return c.Old != nil && c.New != nil && c.Old.Code != c.New.Code
+}
+
+// Diff is the difference between two exports, per slot and per handler
+type Diff struct {
+	Old      *dustructs.ScriptExport
+	New      *dustructs.ScriptExport
+	Slots    []*SlotChange
+	Handlers []*HandlerChange
+}
+
+// Empty checks if there are no differences
+func (d *Diff) Empty() bool {
+	return len(d.Slots) == 0 && len(d.Handlers) == 0
+}
+
+// Compare finds the differences between two exports
+func Compare(oldExport *dustructs.ScriptExport, newExport *dustructs.ScriptExport) *Diff {
+	d := &Diff{
+		Old:      oldExport,
+		New:      newExport,
+		Slots:    make([]*SlotChange, 0),
+		Handlers: make([]*HandlerChange, 0),
+	}
+
+	for _, slotKey := range slotKeys(oldExport, newExport) {
+		oldSlot, newSlot := oldExport.Slots[slotKey], newExport.Slots[slotKey]
+		if oldSlot == nil || newSlot == nil || oldSlot.Name != newSlot.Name {
+			d.Slots = append(d.Slots, &SlotChange{Key: slotKey, Old: oldSlot, New: newSlot})
+		}
+
+		for _, c := range pairHandlers(oldExport, newExport, slotKey) {
+			if c.Old == nil || c.New == nil || c.FilterChanged() || c.CodeChanged() {
+				d.Handlers = append(d.Handlers, c)
+			}
+		}
+	}
+
+	return d
+}
+
+// pairHandlers pairs up the handlers of a slot by the name of their filter in the order of their keys,
+// the handlers that are left over were added or removed.
+func pairHandlers(oldExport *dustructs.ScriptExport, newExport *dustructs.ScriptExport, slotKey int) []*HandlerChange {
+	res := make([]*HandlerChange, 0)
+
+	oldHandlers := handlersByFilter(oldExport, slotKey)
+	newHandlers := handlersByFilter(newExport, slotKey)
+
+	for _, handler := range slotHandlers(oldExport, newExport, slotKey) {
+		name := filterName(handler)
+		if oldHandlers[name] == nil && newHandlers[name] == nil {
+			continue // already paired up
+		}
+
+		for k := 0; k < len(oldHandlers[name]) || k < len(newHandlers[name]); k++ {
+			c := &HandlerChange{SlotKey: slotKey}
+			if k < len(oldHandlers[name]) {
+				c.Old = oldHandlers[name][k]
+			}
+			if k < len(newHandlers[name]) {
+				c.New = newHandlers[name][k]
+			}
+
+			res = append(res, c)
+		}
+
+		delete(oldHandlers, name)
+		delete(newHandlers, name)
+	}
+
+	return res
+}
+
+// Write writes the differences for humans, with a unified diff of the code of each handler that changed
+func (d *Diff) Write(w io.Writer, oldName string, newName string) error {
+	for _, c := range d.Slots {
+		var err error
+		switch {
+		case c.Old == nil:
+			_, err = fmt.Fprintf(w, "slot %d `%s`: added\n", c.Key, c.New.Name)
+		case c.New == nil:
+			_, err = fmt.Fprintf(w, "slot %d `%s`: removed\n", c.Key, c.Old.Name)
+		default:
+			_, err = fmt.Fprintf(w, "slot %d: renamed `%s` -> `%s`\n", c.Key, c.Old.Name, c.New.Name)
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	for _, c := range d.Handlers {
+		slot := d.slotName(c.SlotKey)
+
+		var oldCode, newCode, oldSignature, newSignature string
+		if c.Old != nil {
+			oldCode, oldSignature = c.Old.Code, c.Old.Filter.Signature
+		}
+		if c.New != nil {
+			newCode, newSignature = c.New.Code, c.New.Filter.Signature
+		}
+
+		var err error
+		switch {
+		case c.Old == nil:
+			_, err = fmt.Fprintf(w, "slot %d `%s`: added handler %s\n", c.SlotKey, slot, newSignature)
+		case c.New == nil:
+			_, err = fmt.Fprintf(w, "slot %d `%s`: removed handler %s\n", c.SlotKey, slot, oldSignature)
+		case c.FilterChanged():
+			_, err = fmt.Fprintf(w, "slot %d `%s`: changed filter of handler %s -> %s\n", c.SlotKey, slot, oldSignature, newSignature)
+		default:
+			_, err = fmt.Fprintf(w, "slot %d `%s`: changed code of handler %s\n", c.SlotKey, slot, newSignature)
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if oldSignature == "" {
+			oldSignature = newSignature
+		}
+		if newSignature == "" {
+			newSignature = oldSignature
+		}
+
+		_, err = io.WriteString(w, Unified(
+			fmt.Sprintf("%s %s %s", oldName, slot, oldSignature),
+			fmt.Sprintf("%s %s %s", newName, slot, newSignature),
+			oldCode, newCode, CONTEXT))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
+
+// slotName is the name of a slot in the new export, or in the old one when it was removed
+func (d *Diff) slotName(slotKey int) string {
+	if slot := d.New.Slots[slotKey]; slot != nil {
+		return slot.Name
+	}
+	if slot := d.Old.Slots[slotKey]; slot != nil {
+		return slot.Name
+	}
+
+	return fmt.Sprintf("%d", slotKey)
+}
+
+// slotKeys are the keys of the slots of both exports, including the slots that only have handlers (which is invalid, but possible)
+func slotKeys(oldExport *dustructs.ScriptExport, newExport *dustructs.ScriptExport) []int {
+	slots := make(map[int]*dustructs.Slot)
+	for _, e := range []*dustructs.ScriptExport{oldExport, newExport} {
+		for slotKey, slot := range e.Slots {
+			slots[slotKey] = slot
+		}
+		for _, handler := range e.Handlers {
+			if _, ok := slots[handler.Filter.SlotKey]; !ok {
+				slots[handler.Filter.SlotKey] = nil
+			}
+		}
+	}
+
+	return dustructs.SortedSlotKeys(slots)
+}
+
+// slotHandlers are the handlers of a slot in both exports, the old ones first, to go through the filters in the order of the keys
+func slotHandlers(oldExport *dustructs.ScriptExport, newExport *dustructs.ScriptExport, slotKey int) []*dustructs.Handler {
+	res := make([]*dustructs.Handler, 0)
+	for _, e := range []*dustructs.ScriptExport{oldExport, newExport} {
+		for _, handler := range sortedHandlers(e) {
+			if handler.Filter.SlotKey == slotKey {
+				res = append(res, handler)
+			}
+		}
+	}
+
+	return res
+}
+
+// handlersByFilter groups the handlers of a slot by the name of their filter, in the order of their keys
+func handlersByFilter(e *dustructs.ScriptExport, slotKey int) map[string][]*dustructs.Handler {
+	res := make(map[string][]*dustructs.Handler)
+	for _, handler := range sortedHandlers(e) {
+		if handler.Filter.SlotKey == slotKey {
+			res[filterName(handler)] = append(res[filterName(handler)], handler)
+		}
+	}
+
+	return res
+}
+
+// sortedHandlers are the handlers in the order of their keys, which is the order the game runs them in
+func sortedHandlers(e *dustructs.ScriptExport) []*dustructs.Handler {
+	res := make([]*dustructs.Handler, len(e.Handlers))
+	copy(res, e.Handlers)
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Key < res[j].Key
+	})
+
+	return res
+}
+
+// filterName is the name of the filter of a handler, eg; `tick` for `tick([Live])`
+func filterName(handler *dustructs.Handler) string {
+	name, _, err := srcutils.ParseHeader(handler.Filter.Signature)
+	if err != nil {
+		return handler.Filter.Signature
+	}
+
+	return name
+}
+
+func argValues(args []dustructs.Arg) []string {
+	res := make([]string, len(args))
+	for k, arg := range args {
+		res[k] = arg.Value
+	}
+
+	return res
+}
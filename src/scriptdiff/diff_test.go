@@ -0,0 +1,178 @@
+package scriptdiff
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rubensayshi/dubby/src/dustructs"
+	"github.com/stretchr/testify/require"
+)
+
+func newHandler(key int, slotKey int, signature string, code string, args ...string) *dustructs.Handler {
+	filterArgs := make([]dustructs.Arg, len(args))
+	for k, arg := range args {
+		filterArgs[k] = dustructs.Arg{Value: arg}
+	}
+
+	return &dustructs.Handler{
+		Code: code,
+		Filter: &dustructs.Filter{
+			Args:      filterArgs,
+			Signature: signature,
+			SlotKey:   slotKey,
+		},
+		Key: key,
+	}
+}
+
+func TestUnified(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal("", Unified("a", "b", "x\ny\n", "x\ny\n", 3))
+
+	assert.Equal("--- a\n+++ b\n@@ -1,3 +1,3 @@\n x\n-y\n+Y\n z\n", Unified("a", "b", "x\ny\nz\n", "x\nY\nz\n", 3))
+
+	// everything added or removed
+	assert.Equal("--- a\n+++ b\n@@ -0,0 +1,2 @@\n+x\n+y\n", Unified("a", "b", "", "x\ny\n", 3))
+	assert.Equal("--- a\n+++ b\n@@ -1 +0,0 @@\n-x\n", Unified("a", "b", "x", "", 3))
+
+	// changes far apart are separate hunks, with the context around them
+	old := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"
+	new := "1\nTWO\n3\n4\n5\n6\n7\n8\n9\n10\n11\n"
+	assert.Equal("--- a\n+++ b\n"+
+		"@@ -1,5 +1,5 @@\n 1\n-2\n+TWO\n 3\n 4\n 5\n"+
+		"@@ -8,3 +8,4 @@\n 8\n 9\n 10\n+11\n", Unified("a", "b", old, new, 3))
+
+	// and close together they're one hunk
+	assert.Equal("--- a\n+++ b\n"+
+		"@@ -1,4 +1,4 @@\n-1\n+ONE\n 2\n-3\n+THREE\n 4\n", Unified("a", "b", "1\n2\n3\n4\n", "ONE\n2\nTHREE\n4\n", 1))
+}
+
+func TestCompare(t *testing.T) {
+	assert := require.New(t)
+
+	old := dustructs.NewScriptExport()
+	old.Slots[0] = dustructs.NewSlot("screen")
+	old.Slots[1] = dustructs.NewSlot("door")
+	old.Handlers = []*dustructs.Handler{
+		newHandler(1, -1, "start()", "init()\n"),
+		newHandler(2, -1, "start()", "go()\n"),
+		newHandler(3, -1, "tick([Live])", "live()\n", "Live"),
+		newHandler(4, -1, "stop()", "stop()\n"),
+		newHandler(5, 0, "mouseDown([*, *])", "click()\n", "*", "*"),
+	}
+
+	new := dustructs.NewScriptExport()
+	new.Slots[0] = dustructs.NewSlot("display")
+	new.Slots[2] = dustructs.NewSlot("radar")
+	new.Handlers = []*dustructs.Handler{
+		// the keys don't matter, only the order
+		newHandler(11, -1, "start()", "init()\n"),
+		newHandler(12, -1, "start()", "go(1)\n"),
+		newHandler(13, -1, "tick([Fast])", "live()\n", "Fast"),
+		newHandler(14, 0, "mouseDown([*, *])", "click()\n", "*", "*"),
+		newHandler(15, 2, "enter([*])", "enter()\n", "*"),
+	}
+
+	d := Compare(old, new)
+	assert.False(d.Empty())
+	assert.True(Compare(old, old).Empty())
+
+	assert.Equal(3, len(d.Slots))
+	assert.Equal(0, d.Slots[0].Key)
+	assert.Equal(1, d.Slots[1].Key)
+	assert.Nil(d.Slots[1].New)
+	assert.Equal(2, d.Slots[2].Key)
+	assert.Nil(d.Slots[2].Old)
+
+	buf := &bytes.Buffer{}
+	assert.NoError(d.Write(buf, "old.json", "src"))
+	assert.Equal("slot 0: renamed `screen` -> `display`\n"+
+		"slot 1 `door`: removed\n"+
+		"slot 2 `radar`: added\n"+
+		"slot -1 `unit`: changed code of handler start()\n"+
+		"--- old.json unit start()\n+++ src unit start()\n@@ -1 +1 @@\n-go()\n+go(1)\n"+
+		"slot -1 `unit`: changed filter of handler tick([Live]) -> tick([Fast])\n"+
+		"slot -1 `unit`: removed handler stop()\n"+
+		"--- old.json unit stop()\n+++ src unit stop()\n@@ -1 +0,0 @@\n-stop()\n"+
+		"slot 2 `radar`: added handler enter([*])\n"+
+		"--- old.json radar enter([*])\n+++ src radar enter([*])\n@@ -0,0 +1 @@\n+enter()\n", buf.String())
+}
+
+func TestDrift(t *testing.T) {
+	assert := require.New(t)
+
+	original := dustructs.NewScriptExport()
+	original.Slots[0] = dustructs.NewSlot("screen")
+	original.Slots[0].Extra = dustructs.ExtraFields{{Name: "color", Value: []byte(`"red"`)}}
+	original.Handlers = []*dustructs.Handler{
+		newHandler(1, -1, "start()", "init()\n\n\n"),
+		newHandler(2, -1, "stop()", "if a then\n    stop()\nend\n"),
+		newHandler(3, -1, "tick([Live])", "live()\n", "Live"),
+		newHandler(4, 0, "mouseDown([*, *])", "click()\n", "*", "*"),
+		newHandler(5, -3, "start()", "-- !DU[lib]: utils\nfunction a() end\n-- !DU[lib]: strings\nfunction b() end\n"),
+	}
+
+	assert.Equal(0, len(Drift(original, original)))
+
+	result := dustructs.NewScriptExport()
+	result.Slots[0] = dustructs.NewSlot("screen.lua")
+	result.Handlers = []*dustructs.Handler{
+		newHandler(1, -1, "start()", "init()\n"),
+		newHandler(2, -1, "stop()", "if a then\n\tstop()\nend\n"),
+		newHandler(4, -1, "tick([Live])", "live(1)\n", "Live"),
+		newHandler(5, -3, "start()", "-- !DU[lib]: utils\nfunction a() end\n-- !DU[lib]: str\nfunction b() end\n"),
+	}
+
+	messages := make([]string, 0)
+	for _, d := range Drift(original, result) {
+		messages = append(messages, d.Code+": "+d.Message)
+	}
+
+	assert.Equal([]string{
+		"drift-whitespace: slot -1 `unit` handler start(): trailing newlines changed from 3 to 1",
+		"drift-whitespace: slot -1 `unit` handler stop(): indenting changed on line 2, \"    \" is \"\\t\"",
+		"drift-key: slot -1 `unit` handler tick([Live]): key 3 is 4",
+		"drift-code: slot -1 `unit` handler tick([Live]): line 1 `live()` is `live(1)`",
+		"drift-lib-name: slot -3 `library` handler start(): lib `strings` is named `str`",
+		"drift-slot: slot 0 `screen` is named `screen.lua`",
+		"drift-extra: slot 0 `screen`: field `color` is lost",
+		"drift-handler: slot 0 `screen` handler mouseDown([*, *]) [4] is lost",
+	}, messages)
+}
+
+func TestMerge3(t *testing.T) {
+	assert := require.New(t)
+
+	base := "a\nb\nc\nd\ne\n"
+
+	// changes from both sides that don't overlap are both applied
+	merged, conflicts := Merge3("local", "other", base, "a\nB\nc\nd\ne\n", "a\nb\nc\nD\ne\n")
+	assert.Equal("a\nB\nc\nD\ne\n", merged)
+	assert.Equal(0, conflicts)
+
+	// lines inserted before lines the other side changed aren't a conflict
+	merged, conflicts = Merge3("local", "other", base, "-- class\na\nb\nc\nd\ne\n", "A\nb\nc\nd\ne\n")
+	assert.Equal("-- class\nA\nb\nc\nd\ne\n", merged)
+	assert.Equal(0, conflicts)
+
+	// the same change on both sides isn't a conflict
+	merged, conflicts = Merge3("local", "other", base, "a\nb\nC\nd\ne\n", "a\nb\nC\nd\ne\n")
+	assert.Equal("a\nb\nC\nd\ne\n", merged)
+	assert.Equal(0, conflicts)
+
+	// different changes to the same lines are
+	merged, conflicts = Merge3("local", "other", base, "a\nb\nc1\nd\ne\n", "a\nb\nc2\nd\ne\n")
+	assert.Equal("a\nb\n<<<<<<< local\nc1\n=======\nc2\n>>>>>>> other\nd\ne\n", merged)
+	assert.Equal(1, conflicts)
+
+	// and so are lines inserted in the same place
+	merged, conflicts = Merge3("local", "other", base, "a\nb\nc\nd\ne\nf\n", "a\nb\nc\nd\ne\ng\n")
+	assert.Equal("a\nb\nc\nd\ne\n<<<<<<< local\nf\n=======\ng\n>>>>>>> other\n", merged)
+	assert.Equal(1, conflicts)
+
+	// removing everything
+	merged, conflicts = Merge3("local", "other", base, base, "")
+	assert.Equal("", merged)
+	assert.Equal(0, conflicts)
+}
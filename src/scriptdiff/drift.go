@@ -0,0 +1,246 @@
+package scriptdiff
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/rubensayshi/dubby/src/diagnostics"
+	"github.com/rubensayshi/dubby/src/dustructs"
+)
+
+const (
+	DRIFT_SLOT       = "drift-slot"
+	DRIFT_HANDLER    = "drift-handler"
+	DRIFT_FILTER     = "drift-filter"
+	DRIFT_KEY        = "drift-key"
+	DRIFT_LIB_NAME   = "drift-lib-name"
+	DRIFT_WHITESPACE = "drift-whitespace"
+	DRIFT_CODE       = "drift-code"
+	DRIFT_EXTRA      = "drift-extra"
+)
+
+// libNameRegexp matches the markers of the lib files and modules in the code of the lib handler
+var libNameRegexp = regexp.MustCompile(`(?m)^-- !DU\[((?:ext)?(?:lib|module))]: (.*)$`)
+
+// Drift finds what a round trip (eg; json -> source directory -> json) lost or changed compared to the original export.
+// Unlike Compare it also checks the keys of the handlers and the fields the game added that we don't know about,
+// and it explains what changed about the code; the whitespace, the names of the libs or the first line that differs.
+func Drift(original *dustructs.ScriptExport, result *dustructs.ScriptExport) []*diagnostics.Diagnostic {
+	// the slots are referred to by the name they have in the original
+	names := &Diff{Old: result, New: original}
+	res := make([]*diagnostics.Diagnostic, 0)
+
+	res = append(res, extraDrift("the export", original.Extra, result.Extra)...)
+	if !reflect.DeepEqual(original.Methods, result.Methods) {
+		res = append(res, drift(DRIFT_EXTRA, "the methods of the export changed"))
+	}
+	if !reflect.DeepEqual(original.Events, result.Events) {
+		res = append(res, drift(DRIFT_EXTRA, "the events of the export changed"))
+	}
+
+	for _, slotKey := range slotKeys(original, result) {
+		oldSlot, newSlot := original.Slots[slotKey], result.Slots[slotKey]
+		switch {
+		case oldSlot == nil:
+			res = append(res, drift(DRIFT_SLOT, "slot %d `%s` is added", slotKey, newSlot.Name))
+		case newSlot == nil:
+			res = append(res, drift(DRIFT_SLOT, "slot %d `%s` is lost", slotKey, oldSlot.Name))
+		default:
+			if oldSlot.Name != newSlot.Name {
+				res = append(res, drift(DRIFT_SLOT, "slot %d `%s` is named `%s`", slotKey, oldSlot.Name, newSlot.Name))
+			}
+			if !reflect.DeepEqual(oldSlot.Type, newSlot.Type) {
+				res = append(res, drift(DRIFT_EXTRA, "the events and methods of slot %d `%s` changed", slotKey, oldSlot.Name))
+			}
+			res = append(res, extraDrift(fmt.Sprintf("slot %d `%s`", slotKey, oldSlot.Name), oldSlot.Extra, newSlot.Extra)...)
+		}
+
+		for _, c := range pairHandlers(original, result, slotKey) {
+			res = append(res, handlerDrift(names.slotName(slotKey), c)...)
+		}
+	}
+
+	return res
+}
+
+func handlerDrift(slot string, c *HandlerChange) []*diagnostics.Diagnostic {
+	if c.New == nil {
+		return []*diagnostics.Diagnostic{drift(DRIFT_HANDLER, "slot %d `%s` handler %s [%d] is lost", c.SlotKey, slot, c.Old.Filter.Signature, c.Old.Key)}
+	}
+	if c.Old == nil {
+		return []*diagnostics.Diagnostic{drift(DRIFT_HANDLER, "slot %d `%s` handler %s [%d] is added", c.SlotKey, slot, c.New.Filter.Signature, c.New.Key)}
+	}
+
+	handler := fmt.Sprintf("slot %d `%s` handler %s", c.SlotKey, slot, c.Old.Filter.Signature)
+	res := make([]*diagnostics.Diagnostic, 0)
+
+	if c.Old.Key != c.New.Key {
+		res = append(res, drift(DRIFT_KEY, "%s: key %d is %d", handler, c.Old.Key, c.New.Key))
+	}
+	if c.FilterChanged() {
+		res = append(res, drift(DRIFT_FILTER, "%s: filter is %s", handler, c.New.Filter.Signature))
+	}
+
+	res = append(res, extraDrift(handler, c.Old.Extra, c.New.Extra)...)
+	res = append(res, extraDrift(handler+" filter", c.Old.Filter.Extra, c.New.Filter.Extra)...)
+	res = append(res, codeDrift(handler, c.Old.Code, c.New.Code)...)
+
+	return res
+}
+
+// codeDrift explains what changed about the code, the names of the libs are checked first and then left out
+func codeDrift(handler string, oldCode string, newCode string) []*diagnostics.Diagnostic {
+	res := make([]*diagnostics.Diagnostic, 0)
+
+	oldLibs, newLibs := libNames(oldCode), libNames(newCode)
+	if !reflect.DeepEqual(oldLibs, newLibs) {
+		if len(oldLibs) == len(newLibs) {
+			for k := range oldLibs {
+				if oldLibs[k] != newLibs[k] {
+					res = append(res, drift(DRIFT_LIB_NAME, "%s: lib `%s` is named `%s`", handler, oldLibs[k], newLibs[k]))
+				}
+			}
+		} else {
+			res = append(res, drift(DRIFT_LIB_NAME, "%s: libs `%s` are `%s`", handler, strings.Join(oldLibs, "`, `"), strings.Join(newLibs, "`, `")))
+		}
+
+		oldCode = libNameRegexp.ReplaceAllString(oldCode, "-- !DU[$1]:")
+		newCode = libNameRegexp.ReplaceAllString(newCode, "-- !DU[$1]:")
+	}
+
+	if oldCode == newCode {
+		return res
+	}
+
+	if change, ok := whitespaceChange(oldCode, newCode); ok {
+		return append(res, drift(DRIFT_WHITESPACE, "%s: %s", handler, change))
+	}
+
+	return append(res, drift(DRIFT_CODE, "%s: %s", handler, codeChange(oldCode, newCode)))
+}
+
+// libNames are the names of the libs and modules in the code of the lib handler, eg; `utils/strings`
+func libNames(code string) []string {
+	res := make([]string, 0)
+	for _, m := range libNameRegexp.FindAllStringSubmatch(code, -1) {
+		res = append(res, m[2])
+	}
+
+	return res
+}
+
+// whitespaceChange explains a change that's only whitespace, ok is false when something else changed as well
+func whitespaceChange(oldCode string, newCode string) (string, bool) {
+	if strings.Join(strings.Fields(oldCode), " ") != strings.Join(strings.Fields(newCode), " ") {
+		return "", false
+	}
+
+	oldTrimmed, newTrimmed := strings.TrimRight(oldCode, "\n"), strings.TrimRight(newCode, "\n")
+	if oldTrimmed == newTrimmed {
+		return fmt.Sprintf("trailing newlines changed from %d to %d", len(oldCode)-len(oldTrimmed), len(newCode)-len(newTrimmed)), true
+	}
+
+	oldLines, newLines := splitLines(oldTrimmed), splitLines(newTrimmed)
+	for k := 0; k < len(oldLines) && k < len(newLines); k++ {
+		if oldLines[k] == newLines[k] {
+			continue
+		}
+
+		switch {
+		case len(oldLines) != len(newLines):
+			return fmt.Sprintf("blank lines changed from line %d, %d lines are %d lines", k+1, len(oldLines), len(newLines)), true
+		case strings.TrimRight(oldLines[k], " \t") == strings.TrimRight(newLines[k], " \t"):
+			return fmt.Sprintf("trailing whitespace changed on line %d", k+1), true
+		case strings.TrimLeft(oldLines[k], " \t") == strings.TrimLeft(newLines[k], " \t"):
+			return fmt.Sprintf("indenting changed on line %d, %q is %q", k+1, leadingWhitespace(oldLines[k]), leadingWhitespace(newLines[k])), true
+		default:
+			return fmt.Sprintf("whitespace changed on line %d", k+1), true
+		}
+	}
+
+	return fmt.Sprintf("blank lines changed, %d lines are %d lines", len(oldLines), len(newLines)), true
+}
+
+func leadingWhitespace(line string) string {
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+// codeChange describes the first line that changed and how many lines changed in total
+func codeChange(oldCode string, newCode string) string {
+	edits := diffLines(splitLines(oldCode), splitLines(newCode))
+
+	changed := 0
+	first := -1
+	for k, e := range edits {
+		if e.kind != EDIT_EQUAL {
+			changed++
+			if first == -1 {
+				first = k
+			}
+		}
+	}
+
+	if first == -1 {
+		// only the newline at the end, which splitLines ignores
+		return "the newline at the end changed"
+	}
+
+	e := edits[first]
+	var res string
+	switch {
+	case e.kind == EDIT_DELETE && first+1 < len(edits) && edits[first+1].kind == EDIT_INSERT:
+		res = fmt.Sprintf("line %d `%s` is `%s`", e.a+1, strings.TrimSpace(e.line), strings.TrimSpace(edits[first+1].line))
+	case e.kind == EDIT_DELETE:
+		res = fmt.Sprintf("line %d `%s` is lost", e.a+1, strings.TrimSpace(e.line))
+	default:
+		res = fmt.Sprintf("line %d `%s` is added", e.b+1, strings.TrimSpace(e.line))
+	}
+
+	if changed > 2 {
+		res += fmt.Sprintf(", %d lines changed in total", changed)
+	}
+
+	return res
+}
+
+// extraDrift checks the fields the game added that we don't know about
+func extraDrift(what string, oldExtra dustructs.ExtraFields, newExtra dustructs.ExtraFields) []*diagnostics.Diagnostic {
+	keys := make([]string, 0)
+	for _, field := range oldExtra {
+		keys = append(keys, field.Name)
+	}
+	for _, field := range newExtra {
+		if !oldExtra.Has(field.Name) {
+			keys = append(keys, field.Name)
+		}
+	}
+	sort.Strings(keys)
+
+	res := make([]*diagnostics.Diagnostic, 0)
+	for _, k := range keys {
+		oldValue, inOld := oldExtra.Get(k), oldExtra.Has(k)
+		newValue, inNew := newExtra.Get(k), newExtra.Has(k)
+		switch {
+		case !inNew:
+			res = append(res, drift(DRIFT_EXTRA, "%s: field `%s` is lost", what, k))
+		case !inOld:
+			res = append(res, drift(DRIFT_EXTRA, "%s: field `%s` is added", what, k))
+		case !bytes.Equal(oldValue, newValue):
+			res = append(res, drift(DRIFT_EXTRA, "%s: field `%s` is %s instead of %s", what, k, newValue, oldValue))
+		}
+	}
+
+	return res
+}
+
+func drift(code string, format string, args ...interface{}) *diagnostics.Diagnostic {
+	return &diagnostics.Diagnostic{
+		Severity: diagnostics.SEVERITY_ERROR,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+	}
+}
@@ -0,0 +1,138 @@
+package scriptdiff
+
+import (
+	"strings"
+)
+
+const (
+	CONFLICT_START  = "<<<<<<<"
+	CONFLICT_MIDDLE = "======="
+	CONFLICT_END    = ">>>>>>>"
+)
+
+// change replaces the lines start to end (exclusive, 0-based) of the base with lines,
+// when start == end the lines are inserted before start.
+type change struct {
+	start int
+	end   int
+	lines []string
+}
+
+// changes groups the edits that turn the base into something else into changes
+func changes(edits []edit) []change {
+	res := make([]change, 0)
+	for k := 0; k < len(edits); {
+		if edits[k].kind == EDIT_EQUAL {
+			k++
+			continue
+		}
+
+		c := change{start: edits[k].a, end: edits[k].a, lines: []string{}}
+		for ; k < len(edits) && edits[k].kind != EDIT_EQUAL; k++ {
+			if edits[k].kind == EDIT_DELETE {
+				c.end = edits[k].a + 1
+			} else {
+				c.lines = append(c.lines, edits[k].line)
+			}
+		}
+
+		res = append(res, c)
+	}
+
+	return res
+}
+
+// apply applies the changes to the lines start to end of the base
+func apply(base []string, changes []change, start int, end int) []string {
+	res := make([]string, 0)
+	pos := start
+	for _, c := range changes {
+		res = append(res, base[pos:c.start]...)
+		res = append(res, c.lines...)
+		pos = c.end
+	}
+
+	return append(res, base[pos:end]...)
+}
+
+// Merge3 merges the changes from base to other into local, line by line (like `diff3 -m` does),
+// where both changed the same lines in a different way they're left between conflict markers.
+// Lines inserted right before or after lines the other side changed aren't a conflict, lines both inserted in the same place are.
+// It returns the merged code and the number of conflicts.
+func Merge3(localName string, otherName string, base string, local string, other string) (string, int) {
+	baseLines := splitLines(base)
+	localChanges := changes(diffLines(baseLines, splitLines(local)))
+	otherChanges := changes(diffLines(baseLines, splitLines(other)))
+
+	res := make([]string, 0)
+	conflicts := 0
+	pos := 0
+	for l, o := 0, 0; l < len(localChanges) || o < len(otherChanges); {
+		// start with whichever change comes first (inserting before changing) and add the changes of both that overlap with it
+		var first change
+		switch {
+		case o == len(otherChanges):
+			first = localChanges[l]
+		case l == len(localChanges):
+			first = otherChanges[o]
+		case localChanges[l].start < otherChanges[o].start:
+			first = localChanges[l]
+		case otherChanges[o].start < localChanges[l].start:
+			first = otherChanges[o]
+		case localChanges[l].start == localChanges[l].end:
+			first = localChanges[l]
+		default:
+			first = otherChanges[o]
+		}
+
+		// changes overlap when they change the same lines of the base, or when they both insert lines in the same place
+		start, end := first.start, first.end
+		overlaps := func(c change) bool {
+			return c.start < end || (start == end && c.start == start && c.end == start)
+		}
+
+		fromLocal, fromOther := l, o
+		for {
+			if l < len(localChanges) && overlaps(localChanges[l]) {
+				if localChanges[l].end > end {
+					end = localChanges[l].end
+				}
+				l++
+			} else if o < len(otherChanges) && overlaps(otherChanges[o]) {
+				if otherChanges[o].end > end {
+					end = otherChanges[o].end
+				}
+				o++
+			} else {
+				break
+			}
+		}
+
+		res = append(res, baseLines[pos:start]...)
+		pos = end
+
+		localLines := apply(baseLines, localChanges[fromLocal:l], start, end)
+		otherLines := apply(baseLines, otherChanges[fromOther:o], start, end)
+
+		switch {
+		case fromOther == o:
+			res = append(res, localLines...)
+		case fromLocal == l || strings.Join(localLines, "\n") == strings.Join(otherLines, "\n"):
+			res = append(res, otherLines...)
+		default:
+			conflicts++
+			res = append(res, CONFLICT_START+" "+localName)
+			res = append(res, localLines...)
+			res = append(res, CONFLICT_MIDDLE)
+			res = append(res, otherLines...)
+			res = append(res, CONFLICT_END+" "+otherName)
+		}
+	}
+	res = append(res, baseLines[pos:]...)
+
+	if len(res) == 0 {
+		return "", conflicts
+	}
+
+	return strings.Join(res, "\n") + "\n", conflicts
+}
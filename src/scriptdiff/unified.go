@@ -0,0 +1,185 @@
+package scriptdiff
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	EDIT_EQUAL  = ' '
+	EDIT_DELETE = '-'
+	EDIT_INSERT = '+'
+)
+
+// edit is a line that's the same in a and b, deleted from a or inserted from b,
+// a and b are the line numbers (0-based) in a and b where the edit happens.
+type edit struct {
+	kind byte
+	a    int
+	b    int
+	line string
+}
+
+// diffLines finds the shortest edit script to turn a into b, with the Myers algorithm
+func diffLines(a []string, b []string) []edit {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+
+	// v holds the furthest x on each diagonal k (x - y), trace has a copy of it before each step
+	v := make([]int, 2*max+3)
+	trace := make([][]int, 0)
+
+search:
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int{}, v...))
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // down, inserting from b
+			} else {
+				x = v[offset+k-1] + 1 // right, deleting from a
+			}
+			y := x - k
+
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// walk back through the trace to find the edits that got us here
+	edits := make([]edit, 0, max)
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			edits = append(edits, edit{kind: EDIT_EQUAL, a: x, b: y, line: a[x]})
+		}
+
+		if x == prevX {
+			y--
+			edits = append(edits, edit{kind: EDIT_INSERT, a: x, b: y, line: b[y]})
+		} else {
+			x--
+			edits = append(edits, edit{kind: EDIT_DELETE, a: x, b: y, line: a[x]})
+		}
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		edits = append(edits, edit{kind: EDIT_EQUAL, a: x, b: y, line: a[x]})
+	}
+
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+
+	return edits
+}
+
+// splitLines splits code into lines, a trailing newline doesn't make an extra empty line
+func splitLines(code string) []string {
+	if code == "" {
+		return []string{}
+	}
+
+	return strings.Split(strings.TrimSuffix(code, "\n"), "\n")
+}
+
+// Unified is a unified diff of the lines of a and b with the lines of context around each change,
+// it's "" when they're the same.
+func Unified(aName string, bName string, a string, b string, context int) string {
+	edits := diffLines(splitLines(a), splitLines(b))
+
+	res := &strings.Builder{}
+	for start := 0; start < len(edits); {
+		// find the next change
+		for start < len(edits) && edits[start].kind == EDIT_EQUAL {
+			start++
+		}
+		if start == len(edits) {
+			break
+		}
+
+		// the hunk continues until there are more unchanged lines than the context on both sides
+		end := start
+		for end < len(edits) {
+			next := end
+			for next < len(edits) && edits[next].kind == EDIT_EQUAL {
+				next++
+			}
+			if next == len(edits) || next-end > 2*context {
+				break
+			}
+			for next < len(edits) && edits[next].kind != EDIT_EQUAL {
+				next++
+			}
+			end = next
+		}
+
+		hunkStart := start - context
+		if hunkStart < 0 {
+			hunkStart = 0
+		}
+		hunkEnd := end + context
+		if hunkEnd > len(edits) {
+			hunkEnd = len(edits)
+		}
+
+		if res.Len() == 0 {
+			fmt.Fprintf(res, "--- %s\n+++ %s\n", aName, bName)
+		}
+
+		aLen, bLen := 0, 0
+		for _, e := range edits[hunkStart:hunkEnd] {
+			if e.kind != EDIT_INSERT {
+				aLen++
+			}
+			if e.kind != EDIT_DELETE {
+				bLen++
+			}
+		}
+
+		fmt.Fprintf(res, "@@ -%s +%s @@\n", hunkRange(edits[hunkStart].a, aLen), hunkRange(edits[hunkStart].b, bLen))
+		for _, e := range edits[hunkStart:hunkEnd] {
+			fmt.Fprintf(res, "%c%s\n", e.kind, e.line)
+		}
+
+		start = hunkEnd
+	}
+
+	return res.String()
+}
+
+// hunkRange is the range of lines of a hunk, an empty range refers to the line before it
+func hunkRange(start int, length int) string {
+	if length == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if length == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+
+	return fmt.Sprintf("%d,%d", start+1, length)
+}
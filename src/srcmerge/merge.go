@@ -0,0 +1,393 @@
+package srcmerge
+
+import (
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rubensayshi/dubby/src/diagnostics"
+	"github.com/rubensayshi/dubby/src/dustructs"
+	"github.com/rubensayshi/dubby/src/scriptdiff"
+	"github.com/rubensayshi/dubby/src/srcreader"
+	"github.com/rubensayshi/dubby/src/srcwriter"
+)
+
+const (
+	CODE_MERGE_CONFLICT = "merge-conflict"
+)
+
+const (
+	ACTION_CREATE = "create"
+	ACTION_UPDATE = "update"
+	ACTION_RENAME = "rename"
+	ACTION_DELETE = "delete"
+)
+
+// FileChange is a change to a file of the source directory, the paths are relative to the source directory
+type FileChange struct {
+	Action    string
+	Path      string
+	OldPath   string // the path before it's renamed
+	Content   string
+	Conflicts int
+}
+
+// SrcMerger merges the changes between a base export (eg; the last one that was built) and another export
+// (eg; exported from the game after editing it there) into a source directory.
+// Both exports are written as source files (like parse-to-src does) and the changes between those files
+// are merged into the files of the source directory, slot files are matched by their slot key and lib files by their name.
+type SrcMerger struct {
+	base       *dustructs.ScriptExport
+	other      *dustructs.ScriptExport
+	slotsDir   string
+	libDir     string
+	indent     string
+	lineEnding string
+	name       string
+	otherName  string
+
+	diagnostics.Collector
+}
+
+func NewSrcMerger(base *dustructs.ScriptExport, other *dustructs.ScriptExport) *SrcMerger {
+	return &SrcMerger{
+		base:       base,
+		other:      other,
+		slotsDir:   "slots",
+		libDir:     "lib",
+		indent:     "    ",
+		lineEnding: "\n",
+		otherName:  "other",
+	}
+}
+
+// SetLayout sets the names of the slots and lib directories
+func (m *SrcMerger) SetLayout(slotsDir string, libDir string) {
+	m.slotsDir = slotsDir
+	m.libDir = libDir
+}
+
+// SetIndent sets the indenting used for the code inside handlers
+func (m *SrcMerger) SetIndent(indent string) {
+	m.indent = indent
+}
+
+// SetLineEnding sets the line ending used in the lua files, `\n` or `\r\n`
+func (m *SrcMerger) SetLineEnding(lineEnding string) {
+	m.lineEnding = lineEnding
+}
+
+// SetName sets the name that's prefixed to the paths of the files of the source directory in messages and conflict markers
+func (m *SrcMerger) SetName(name string) {
+	m.name = name
+}
+
+// SetOtherName sets the name of the other export in conflict markers, eg; `ingame.json`
+func (m *SrcMerger) SetOtherName(otherName string) {
+	m.otherName = otherName
+}
+
+// MergeInto merges the changes into the source directory,
+// nothing is changed when working out the changes fails (eg; on a conflict with strict).
+func (m *SrcMerger) MergeInto(srcDir string) ([]*FileChange, error) {
+	changes, err := m.Merge(os.DirFS(srcDir))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for _, c := range changes {
+		filePath := filepath.Join(srcDir, filepath.FromSlash(c.Path))
+
+		if c.Action == ACTION_DELETE {
+			err := os.Remove(filePath)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			continue
+		}
+
+		err := os.MkdirAll(filepath.Dir(filePath), 0777)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		err = ioutil.WriteFile(filePath, []byte(strings.ReplaceAll(c.Content, "\n", m.lineEnding)), 0666)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if c.Action == ACTION_RENAME {
+			err := os.Remove(filepath.Join(srcDir, filepath.FromSlash(c.OldPath)))
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+	}
+
+	return changes, nil
+}
+
+// Merge works out the changes to the source directory (the root of fsys) without changing anything,
+// the conflicts are warnings (or errors with strict).
+func (m *SrcMerger) Merge(fsys fs.FS) ([]*FileChange, error) {
+	baseFS, err := m.write(m.base)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to write the base export")
+	}
+	otherFS, err := m.write(m.other)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to write the other export")
+	}
+
+	baseFiles, err := m.files(baseFS)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	otherFiles, err := m.files(otherFS)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	localFiles, err := m.files(fsys)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	res := make([]*FileChange, 0)
+	for _, key := range fileKeys(baseFiles, otherFiles) {
+		basePath, inBase := baseFiles[key]
+		otherPath, inOther := otherFiles[key]
+		localPath, inLocal := localFiles[key]
+
+		baseCode, err := readFile(baseFS, basePath, inBase)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		otherCode, err := readFile(otherFS, otherPath, inOther)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		// nothing changed
+		if inBase == inOther && baseCode == otherCode {
+			continue
+		}
+
+		if !inLocal {
+			switch {
+			case !inOther:
+				// removed from both
+			case inBase && !isEmptySlotFile(baseCode):
+				err := m.Warn(&diagnostics.Diagnostic{
+					File:    path.Join(m.name, otherPath),
+					Code:    CODE_MERGE_CONFLICT,
+					Message: fmt.Sprintf("%s is changed in %s, but it's removed from the source directory", describe(key), m.otherName),
+				})
+				if err != nil {
+					return nil, errors.WithStack(err)
+				}
+			default:
+				res = append(res, &FileChange{Action: ACTION_CREATE, Path: m.newPath(key, otherPath, localFiles), Content: otherCode})
+			}
+			continue
+		}
+
+		localCode, err := readFile(fsys, localPath, true)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if key == "metadata" {
+			merged, conflicts, err := m.mergeMetadata(localPath, baseCode, localCode, otherCode)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+
+			switch {
+			case merged == "":
+				res = append(res, &FileChange{Action: ACTION_DELETE, Path: localPath})
+			case merged != localCode:
+				res = append(res, &FileChange{Action: ACTION_UPDATE, Path: localPath, Content: merged, Conflicts: conflicts})
+			}
+			continue
+		}
+
+		merged, conflicts := scriptdiff.Merge3(path.Join(m.name, localPath), m.otherName, baseCode, localCode, otherCode)
+		for k, line := range strings.Split(merged, "\n") {
+			if strings.HasPrefix(line, scriptdiff.CONFLICT_START) {
+				err := m.Warn(&diagnostics.Diagnostic{
+					File:    path.Join(m.name, localPath),
+					Line:    k + 1,
+					Code:    CODE_MERGE_CONFLICT,
+					Message: fmt.Sprintf("%s is changed in %s and in the source directory", describe(key), m.otherName),
+				})
+				if err != nil {
+					return nil, errors.WithStack(err)
+				}
+			}
+		}
+
+		switch {
+		case !inOther && conflicts == 0 && strings.TrimSpace(merged) == "":
+			res = append(res, &FileChange{Action: ACTION_DELETE, Path: localPath})
+		case strings.HasPrefix(key, "slot:") && inBase && inOther && path.Base(basePath) != path.Base(otherPath) && path.Base(localPath) == path.Base(basePath):
+			// the slot is renamed, unless it's renamed in the source directory as well
+			res = append(res, &FileChange{Action: ACTION_RENAME, Path: path.Join(path.Dir(localPath), path.Base(otherPath)), OldPath: localPath, Content: merged, Conflicts: conflicts})
+		case strings.TrimSuffix(merged, "\n") != strings.TrimSuffix(localCode, "\n"):
+			res = append(res, &FileChange{Action: ACTION_UPDATE, Path: localPath, Content: merged, Conflicts: conflicts})
+		}
+	}
+
+	return res, nil
+}
+
+// write writes the export as source files in memory, the same way parse-to-src would
+func (m *SrcMerger) write(scriptExport *dustructs.ScriptExport) (*srcwriter.MemFS, error) {
+	w := srcwriter.NewSrcWriter(scriptExport)
+	w.SetLayout(m.slotsDir, m.libDir)
+	w.SetIndent(m.indent)
+
+	fsys := srcwriter.NewMemFS()
+	err := w.WriteToFS(fsys)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return fsys, nil
+}
+
+// files finds the files of a source directory by their key, which is what they're matched by;
+// `slot:0` for the slot file of slot 0, `lib:utils/strings` for a lib file, `dir:utils` for a lib directory
+// (only used to find where to place new lib files) and `metadata` for the metadata file.
+func (m *SrcMerger) files(fsys fs.FS) (map[string]string, error) {
+	res := make(map[string]string)
+
+	slotFiles, err := fs.ReadDir(fsys, m.slotsDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, errors.WithStack(err)
+	}
+	for _, slotFile := range slotFiles {
+		s := strings.SplitN(slotFile.Name(), ".", 2)
+		if slotFile.IsDir() || len(s) < 2 || !strings.HasSuffix(slotFile.Name(), ".lua") {
+			continue
+		}
+
+		slotKey, err := strconv.Atoi(s[0])
+		if err != nil {
+			continue
+		}
+
+		res[fmt.Sprintf("slot:%d", slotKey)] = path.Join(m.slotsDir, slotFile.Name())
+	}
+
+	err = fs.WalkDir(fsys, m.libDir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if filePath == m.libDir && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return errors.WithStack(err)
+		}
+		if filePath == m.libDir {
+			return nil
+		}
+
+		name := srcreader.LibName(strings.TrimPrefix(filePath, m.libDir+"/"))
+		if d.IsDir() {
+			res["dir:"+name] = filePath
+		} else if strings.HasSuffix(filePath, ".lua") {
+			res["lib:"+name] = filePath
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if _, err := fs.Stat(fsys, dustructs.METADATA_FILE); err == nil {
+		res["metadata"] = dustructs.METADATA_FILE
+	}
+
+	return res, nil
+}
+
+// newPath is where a file that's added goes in the source directory, new lib files go in the lib directory
+// that has the same name (when there is one) and aren't numbered.
+func (m *SrcMerger) newPath(key string, otherPath string, localFiles map[string]string) string {
+	if !strings.HasPrefix(key, "lib:") {
+		return otherPath
+	}
+
+	parts := strings.Split(strings.TrimPrefix(key, "lib:"), "/")
+	dir := m.libDir
+	for k, part := range parts[:len(parts)-1] {
+		if localDir, ok := localFiles["dir:"+strings.Join(parts[:k+1], "/")]; ok {
+			dir = localDir
+		} else {
+			dir = path.Join(dir, part)
+		}
+	}
+
+	return path.Join(dir, parts[len(parts)-1]+".lua")
+}
+
+// fileKeys are the keys of the slot, lib and metadata files of both, in order
+func fileKeys(baseFiles map[string]string, otherFiles map[string]string) []string {
+	seen := make(map[string]bool)
+	keys := make([]string, 0)
+	for _, files := range []map[string]string{baseFiles, otherFiles} {
+		for key := range files {
+			if !seen[key] && !strings.HasPrefix(key, "dir:") {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+func describe(key string) string {
+	s := strings.SplitN(key, ":", 2)
+	switch s[0] {
+	case "slot":
+		return "slot " + s[1]
+	case "lib":
+		return "lib `" + s[1] + "`"
+	default:
+		return dustructs.METADATA_FILE
+	}
+}
+
+// isEmptySlotFile is true for a slot file without any code, at most it has the class of the slot
+func isEmptySlotFile(code string) bool {
+	for _, line := range strings.Split(code, "\n") {
+		if strings.TrimSpace(line) != "" && !strings.HasPrefix(line, "-- !DU[class]:") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// readFile reads a file with `\n` line endings, it's "" when it doesn't exist
+func readFile(fsys fs.FS, filePath string, exists bool) (string, error) {
+	if !exists {
+		return "", nil
+	}
+
+	buf, err := fs.ReadFile(fsys, filePath)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return strings.ReplaceAll(string(buf), "\r\n", "\n"), nil
+}
@@ -0,0 +1,199 @@
+package srcmerge
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"testing/fstest"
+
+	"github.com/rubensayshi/dubby/src/dustructs"
+	"github.com/stretchr/testify/require"
+)
+
+func newHandler(key int, slotKey int, signature string, code string) *dustructs.Handler {
+	return &dustructs.Handler{
+		Code: code,
+		Filter: &dustructs.Filter{
+			Args:      []dustructs.Arg{},
+			Signature: signature,
+			SlotKey:   slotKey,
+		},
+		Key: key,
+	}
+}
+
+func newExport(handlers ...*dustructs.Handler) *dustructs.ScriptExport {
+	e := dustructs.NewScriptExport()
+	e.Slots[0] = dustructs.NewSlot("screen")
+	e.Handlers = handlers
+
+	return e
+}
+
+func TestSrcMerger_Merge(t *testing.T) {
+	assert := require.New(t)
+
+	base := newExport(
+		newHandler(1, -1, "start()", "-- !DU[lib]: utils\n\nx = 1\n-- !DU[lib]: old\n\no = 1\n"),
+		newHandler(2, -1, "start()", "init()\nlocal n = 1\nprint(1)\n"),
+		newHandler(3, -1, "stop()", "stop()\n"),
+		newHandler(4, 0, "mouseDown()", "click()\n"),
+	)
+	ingame := newExport(
+		newHandler(1, -1, "start()", "-- !DU[lib]: utils\n\nx = 2\n-- !DU[lib]: tools/new\n\nn = 1\n"),
+		newHandler(2, -1, "start()", "init(true)\nlocal n = 1\nprint(2)\n"),
+		newHandler(3, -1, "stop()", "stop()\n"),
+		newHandler(4, 0, "mouseUp()", "click()\n"),
+	)
+	ingame.Slots[0].Name = "display"
+
+	local := fstest.MapFS{
+		"slots/-1.unit.lua": &fstest.MapFile{Data: []byte("-- !DU[class]: ControlUnit\n" +
+			"do -- !DU: start()\n    init()\n    local n = 1\n    print(3)\nend -- !DU: end\n\n" +
+			"do -- !DU: stop()\n    stop()\nend -- !DU: end\n")},
+		"slots/0.screen.lua": &fstest.MapFile{Data: []byte("do -- !DU: mouseDown()\n    click()\nend -- !DU: end\n")},
+		"lib/utils.lua":      &fstest.MapFile{Data: []byte("x = 1\n")},
+		"lib/0.old.lua":      &fstest.MapFile{Data: []byte("o = 1\n")},
+		"lib/2.tools/a.lua":  &fstest.MapFile{Data: []byte("a = 1\n")},
+		"lib/2.tools/b.lua":  &fstest.MapFile{Data: []byte("b = 1\n")},
+		"lib/unchanged.lua":  &fstest.MapFile{Data: []byte("u = 1\n")},
+	}
+
+	m := NewSrcMerger(base, ingame)
+	m.SetName("src")
+	m.SetOtherName("ingame.json")
+	changes, err := m.Merge(local)
+	assert.NoError(err)
+
+	assert.Equal(5, len(changes))
+
+	// the removed lib is removed
+	assert.Equal(ACTION_DELETE, changes[0].Action)
+	assert.Equal("lib/0.old.lua", changes[0].Path)
+
+	// the added lib goes in the lib directory with the same name
+	assert.Equal(ACTION_CREATE, changes[1].Action)
+	assert.Equal("lib/2.tools/new.lua", changes[1].Path)
+	assert.Equal("n = 1\n", changes[1].Content)
+
+	assert.Equal(ACTION_UPDATE, changes[2].Action)
+	assert.Equal("lib/utils.lua", changes[2].Path)
+	assert.Equal("x = 2\n", changes[2].Content)
+
+	// the conflict is marked, the rest of the changes are merged
+	assert.Equal(ACTION_UPDATE, changes[3].Action)
+	assert.Equal("slots/-1.unit.lua", changes[3].Path)
+	assert.Equal(1, changes[3].Conflicts)
+	assert.Equal("-- !DU[class]: ControlUnit\n"+
+		"do -- !DU: start()\n    init(true)\n    local n = 1\n<<<<<<< src/slots/-1.unit.lua\n    print(3)\n=======\n    print(2)\n>>>>>>> ingame.json\nend -- !DU: end\n\n"+
+		"do -- !DU: stop()\n    stop()\nend -- !DU: end\n", changes[3].Content)
+
+	// the renamed slot is renamed
+	assert.Equal(ACTION_RENAME, changes[4].Action)
+	assert.Equal("slots/0.screen.lua", changes[4].OldPath)
+	assert.Equal("slots/0.display.lua", changes[4].Path)
+	assert.Equal("do -- !DU: mouseUp()\n    click()\nend -- !DU: end\n", changes[4].Content)
+
+	assert.Equal(1, len(m.Diagnostics()))
+	assert.Equal(CODE_MERGE_CONFLICT, m.Diagnostics()[0].Code)
+	assert.Equal("src/slots/-1.unit.lua", m.Diagnostics()[0].File)
+	assert.Equal(5, m.Diagnostics()[0].Line)
+}
+
+func TestSrcMerger_MergeInto(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "dubby")
+	assert.NoError(err)
+	defer os.RemoveAll(dir) // always cleanup the mess
+
+	err = os.MkdirAll(path.Join(dir, "slots"), 0777)
+	assert.NoError(err)
+	err = ioutil.WriteFile(path.Join(dir, "slots/-1.unit.lua"), []byte("do -- !DU: start()\n    local\nend -- !DU: end\n"), 0666)
+	assert.NoError(err)
+
+	base := newExport(newHandler(1, -1, "start()", "base\n"))
+	ingame := newExport(newHandler(1, -1, "start()", "ingame\n"), newHandler(2, 0, "mouseDown()", "click()\n"))
+
+	// with strict a conflict is an error and nothing is changed
+	m := NewSrcMerger(base, ingame)
+	m.SetStrict(true)
+	_, err = m.MergeInto(dir)
+	assert.Error(err)
+
+	_, err = os.Stat(path.Join(dir, "slots/0.screen.lua"))
+	assert.True(os.IsNotExist(err))
+
+	// without strict the changes are written
+	m = NewSrcMerger(base, ingame)
+	m.SetLineEnding("\r\n")
+	changes, err := m.MergeInto(dir)
+	assert.NoError(err)
+	assert.Equal(2, len(changes))
+
+	unit, err := ioutil.ReadFile(path.Join(dir, "slots/-1.unit.lua"))
+	assert.NoError(err)
+	assert.Equal("do -- !DU: start()\r\n<<<<<<< slots/-1.unit.lua\r\n    local\r\n=======\r\n    ingame\r\n>>>>>>> other\r\nend -- !DU: end\r\n", string(unit))
+
+	screen, err := ioutil.ReadFile(path.Join(dir, "slots/0.screen.lua"))
+	assert.NoError(err)
+	assert.Equal("do -- !DU: mouseDown()\r\n    click()\r\nend -- !DU: end\r\n", string(screen))
+}
+
+func TestSrcMerger_MergeMetadata(t *testing.T) {
+	assert := require.New(t)
+
+	base := newExport(newHandler(1, -1, "start()", "start()\n"))
+	base.Slots[0].Extra = dustructs.ExtraFields{{Name: "a", Value: json.RawMessage(`1`)}}
+	base.Extra = dustructs.ExtraFields{{Name: "x", Value: json.RawMessage(`1`)}}
+
+	ingame := newExport(newHandler(1, -1, "start()", "start()\n"))
+	ingame.Slots[0].Extra = dustructs.ExtraFields{{Name: "a", Value: json.RawMessage(`2`)}}
+	ingame.Extra = dustructs.ExtraFields{{Name: "x", Value: json.RawMessage(`2`)}}
+
+	local := fstest.MapFS{
+		"slots/-1.unit.lua": &fstest.MapFile{Data: []byte("do -- !DU: start()\n    start()\nend -- !DU: end\n")},
+		"metadata.json":     &fstest.MapFile{Data: []byte(`{"slots": {"0": {"name": "screen", "extra": {"a": 1}}}, "extra": {"x": 3, "y": 1}}`)},
+	}
+
+	m := NewSrcMerger(base, ingame)
+	m.SetName("src")
+	m.SetOtherName("ingame.json")
+	changes, err := m.Merge(local)
+	assert.NoError(err)
+
+	// the change to the slot is merged, the conflicting extra field keeps the value of the source directory
+	// and there are no conflict markers in the JSON
+	assert.Equal(1, len(changes))
+	assert.Equal(ACTION_UPDATE, changes[0].Action)
+	assert.Equal("metadata.json", changes[0].Path)
+	assert.Equal(1, changes[0].Conflicts)
+	assert.Equal(`{
+  "slots": {
+    "0": {
+      "name": "screen",
+      "extra": {
+        "a": 2
+      }
+    }
+  },
+  "extra": {
+    "x": 3,
+    "y": 1
+  }
+}
+`, changes[0].Content)
+
+	assert.Equal(1, len(m.Diagnostics()))
+	assert.Equal(CODE_MERGE_CONFLICT, m.Diagnostics()[0].Code)
+	assert.Equal("src/metadata.json", m.Diagnostics()[0].File)
+	assert.Contains(m.Diagnostics()[0].Message, "the extra field `x`")
+
+	// with strict the conflict is an error
+	m = NewSrcMerger(base, ingame)
+	m.SetStrict(true)
+	_, err = m.Merge(local)
+	assert.Error(err)
+}
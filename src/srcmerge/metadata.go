@@ -0,0 +1,213 @@
+package srcmerge
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rubensayshi/dubby/src/diagnostics"
+	"github.com/rubensayshi/dubby/src/dustructs"
+)
+
+// metadataEntries are the parts of a metadata file that are merged on their own (a slot, a handler, the methods, ...),
+// by their key and in the order they're in the file, the values are the JSON of the parts.
+type metadataEntries struct {
+	keys   []string
+	values map[string]string
+}
+
+// mergeMetadata merges the metadata file per slot, handler, list of methods and events, name and extra field,
+// a line based merge could put conflict markers in the JSON. When a part is changed in both the one of the
+// source directory is kept and the conflict is a warning (or an error with strict).
+func (m *SrcMerger) mergeMetadata(localPath string, baseCode string, localCode string, otherCode string) (string, int, error) {
+	base, err := parseMetadataEntries(baseCode)
+	if err != nil {
+		return "", 0, errors.Wrap(err, "failed to parse the base metadata")
+	}
+	local, err := parseMetadataEntries(localCode)
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "failed to parse %s", path.Join(m.name, localPath))
+	}
+	other, err := parseMetadataEntries(otherCode)
+	if err != nil {
+		return "", 0, errors.Wrap(err, "failed to parse the other metadata")
+	}
+
+	merged := &metadataEntries{values: make(map[string]string)}
+	conflicts := 0
+	for _, entries := range []*metadataEntries{local, other} {
+		for _, key := range entries.keys {
+			if _, ok := merged.values[key]; ok {
+				continue
+			}
+
+			baseValue, localValue, otherValue := base.values[key], local.values[key], other.values[key]
+
+			value := localValue
+			switch {
+			case otherValue == baseValue || otherValue == localValue:
+			case localValue == baseValue:
+				value = otherValue
+			default:
+				conflicts++
+				err := m.Warn(&diagnostics.Diagnostic{
+					File:    path.Join(m.name, localPath),
+					Code:    CODE_MERGE_CONFLICT,
+					Message: fmt.Sprintf("%s is changed in %s and in the source directory, the one of the source directory is kept", describeMetadataEntry(key), m.otherName),
+				})
+				if err != nil {
+					return "", 0, errors.WithStack(err)
+				}
+			}
+
+			merged.values[key] = value
+			if value != "" {
+				merged.keys = append(merged.keys, key)
+			}
+		}
+	}
+
+	res, err := merged.marshal()
+	if err != nil {
+		return "", 0, errors.WithStack(err)
+	}
+
+	// don't rewrite the file of the source directory when only its formatting differs
+	localRes, err := local.marshal()
+	if err != nil {
+		return "", 0, errors.WithStack(err)
+	}
+	if res == localRes {
+		return localCode, conflicts, nil
+	}
+
+	return res, conflicts, nil
+}
+
+// parseMetadataEntries splits a metadata file in the parts that are merged on their own, there are none when it's ""
+func parseMetadataEntries(code string) (*metadataEntries, error) {
+	res := &metadataEntries{values: make(map[string]string)}
+	if strings.TrimSpace(code) == "" {
+		return res, nil
+	}
+
+	metadata := dustructs.NewMetadata()
+	err := json.Unmarshal([]byte(code), metadata)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	add := func(key string, value interface{}) error {
+		buf, err := json.Marshal(value)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		res.keys = append(res.keys, key)
+		res.values[key] = string(buf)
+
+		return nil
+	}
+
+	slotKeys := make([]int, 0, len(metadata.Slots))
+	for slotKey := range metadata.Slots {
+		slotKeys = append(slotKeys, slotKey)
+	}
+	sort.Ints(slotKeys)
+	for _, slotKey := range slotKeys {
+		if err := add(fmt.Sprintf("slot:%d", slotKey), metadata.Slots[slotKey]); err != nil {
+			return nil, err
+		}
+	}
+	for _, handler := range metadata.Handlers {
+		if err := add(fmt.Sprintf("handler:%d:%s:%d", handler.SlotKey, handler.Filter, handler.N), handler); err != nil {
+			return nil, err
+		}
+	}
+	if len(metadata.Methods) > 0 {
+		if err := add("methods", metadata.Methods); err != nil {
+			return nil, err
+		}
+	}
+	if len(metadata.Events) > 0 {
+		if err := add("events", metadata.Events); err != nil {
+			return nil, err
+		}
+	}
+	if metadata.Name != "" {
+		if err := add("name", metadata.Name); err != nil {
+			return nil, err
+		}
+	}
+	for _, field := range metadata.Extra {
+		if err := add("extra:"+field.Name, field.Value); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// marshal puts the parts together again and writes them the same way the source writer does, it's "" when there are none
+func (e *metadataEntries) marshal() (string, error) {
+	metadata := dustructs.NewMetadata()
+	for _, key := range e.keys {
+		value := []byte(e.values[key])
+		s := strings.SplitN(key, ":", 2)
+
+		var err error
+		switch s[0] {
+		case "slot":
+			slotKey, _ := strconv.Atoi(s[1])
+			slot := &dustructs.SlotMetadata{}
+			err = json.Unmarshal(value, slot)
+			metadata.Slots[slotKey] = slot
+		case "handler":
+			handler := &dustructs.HandlerMetadata{}
+			err = json.Unmarshal(value, handler)
+			metadata.Handlers = append(metadata.Handlers, handler)
+		case "methods":
+			err = json.Unmarshal(value, &metadata.Methods)
+		case "events":
+			err = json.Unmarshal(value, &metadata.Events)
+		case "name":
+			err = json.Unmarshal(value, &metadata.Name)
+		case "extra":
+			metadata.Extra.Set(s[1], json.RawMessage(value))
+		}
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+	}
+
+	if metadata.IsEmpty() {
+		return "", nil
+	}
+
+	res, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return string(res) + "\n", nil
+}
+
+func describeMetadataEntry(key string) string {
+	s := strings.SplitN(key, ":", 2)
+	switch s[0] {
+	case "slot":
+		return fmt.Sprintf("the metadata of slot %s", s[1])
+	case "handler":
+		return fmt.Sprintf("the metadata of handler `%s`", s[1])
+	case "extra":
+		return fmt.Sprintf("the extra field `%s`", s[1])
+	case "name":
+		return "the name of the auto configure module"
+	default:
+		return "the " + s[0]
+	}
+}
@@ -0,0 +1,166 @@
+package srcreader
+
+import (
+	"io/fs"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rubensayshi/dubby/src/luaparser"
+)
+
+// modules are wrapped in a function that's registered with package.preload, so require() can load them
+const modulePreloadStart = `package.preload[%q] = function(...)`
+const modulePreloadEnd = "end"
+
+type libFile struct {
+	name        string // the path relative to the lib directory (without number prefixes and extension), eg; `utils/strings`
+	displayPath string
+	content     string
+	lines       []SourceLine
+	external    bool // from outside of the source directory
+	module      bool // when it's required it's included as a module instead of as a lib
+}
+
+// requiredModules finds the modules that are required with a string literal, eg; `require("vec")` or `require "vec"`
+func requiredModules(code string) []string {
+	// syntax errors are reported when the code is compiled
+	tokens, err := luaparser.Tokenize(code)
+	if err != nil {
+		return nil
+	}
+
+	modules := make([]string, 0)
+	for k, t := range tokens {
+		if t.Type != luaparser.TOKEN_NAME || t.Value != "require" || k+1 >= len(tokens) {
+			continue
+		}
+
+		next := tokens[k+1]
+		if next.Type == luaparser.TOKEN_SYMBOL && next.Value == "(" && k+3 < len(tokens) {
+			if tokens[k+3].Type != luaparser.TOKEN_SYMBOL || tokens[k+3].Value != ")" {
+				continue
+			}
+			next = tokens[k+2]
+		}
+
+		if name, ok := stringLiteral(next); ok {
+			modules = append(modules, name)
+		}
+	}
+
+	return modules
+}
+
+// stringLiteral gets the value of a simple string literal, strings with escapes aren't supported as module names
+func stringLiteral(t *luaparser.Token) (string, bool) {
+	if t.Type != luaparser.TOKEN_STRING {
+		return "", false
+	}
+
+	var value string
+	switch {
+	case strings.HasPrefix(t.Value, "[["):
+		value = strings.TrimSuffix(strings.TrimPrefix(t.Value, "[["), "]]")
+	case strings.HasPrefix(t.Value, `"`) || strings.HasPrefix(t.Value, "'"):
+		value = t.Value[1 : len(t.Value)-1]
+	default:
+		return "", false
+	}
+
+	if strings.ContainsAny(value, "\\\n") {
+		return "", false
+	}
+
+	return value, true
+}
+
+// bundleModules finds all modules that are required by the slots, the lib files and by the modules themselves.
+// Lib files that are required become modules, modules from the module paths are only included when they're required.
+// Modules which can't be found are left to be resolved by the game (eg; its built-in libraries).
+func (r *SrcReader) bundleModules(libFiles []*libFile) ([]*libFile, error) {
+	// the lib files of the source directory come last, so they take precedence over the shared libs
+	byName := make(map[string]*libFile, len(libFiles))
+	for _, f := range libFiles {
+		byName[moduleName(f.name)] = f
+	}
+
+	queue := make([]string, 0)
+	queue = append(queue, r.requires...)
+	for _, f := range libFiles {
+		queue = append(queue, requiredModules(f.content)...)
+	}
+
+	modules := make([]*libFile, 0)
+	seen := make(map[string]bool)
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		f := byName[name]
+		if f == nil {
+			var err error
+			f, err = r.findModule(name)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			if f == nil {
+				continue
+			}
+
+			err = r.validate(f.content, f.lines, f.displayPath)
+			if err != nil {
+				err := r.fail(err)
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			queue = append(queue, requiredModules(f.content)...)
+		}
+
+		f.module = true
+		modules = append(modules, f)
+	}
+
+	return modules, nil
+}
+
+// findModule looks for a module in the module paths, `a.b` can be either `a.b.lua`, `a/b.lua` or `a/b/init.lua`
+func (r *SrcReader) findModule(name string) (*libFile, error) {
+	nested := strings.ReplaceAll(name, ".", "/")
+	candidates := []string{name + ".lua", nested + ".lua", nested + "/init.lua"}
+
+	for _, modulePath := range r.modulePaths {
+		for _, candidate := range candidates {
+			if !fs.ValidPath(candidate) {
+				continue
+			}
+
+			info, err := fs.Stat(modulePath.fsys, candidate)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			} else if err != nil {
+				return nil, errors.WithStack(err)
+			}
+
+			if info.IsDir() {
+				continue
+			}
+
+			return r.readLibFile(modulePath, candidate, nested)
+		}
+	}
+
+	return nil, nil
+}
+
+// moduleName is the name a lib file is required with, eg; `utils.strings` for `utils/strings`
+func moduleName(name string) string {
+	return strings.ReplaceAll(name, "/", ".")
+}
@@ -0,0 +1,133 @@
+package srcreader
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/rubensayshi/dubby/src/luamin"
+	"github.com/rubensayshi/dubby/src/luaparser"
+	"github.com/rubensayshi/dubby/src/srcutils"
+)
+
+// SourceLine is where a line of compiled code came from, File is relative to the source directory.
+// Lines which are added by the compiling (such as markers) have no File.
+type SourceLine struct {
+	File   string
+	Line   int // 1-based
+	Indent int // how much indenting was trimmed off the line
+}
+
+// LuaSyntaxError is a syntax error in the lua code, pointing to the source file it's in
+type LuaSyntaxError struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *LuaSyntaxError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Message)
+}
+
+// compile validates the code of a handler and minifies it when enabled,
+// lines should hold the SourceLine for each line of the code and are used to build the source map.
+func (r *SrcReader) compile(code string, lines []SourceLine, handler string) (string, []*Mapping, error) {
+	err := r.validate(code, lines, handler)
+	if err != nil {
+		return "", nil, errors.WithStack(err)
+	}
+
+	r.report.SrcLen += len(code)
+	if !r.minify {
+		return code, lineMappings(lines), nil
+	}
+
+	minified, positions, err := luamin.LuaMinWithPositions([]byte(code))
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "failed to minify %s", handler)
+	}
+
+	code = string(minified)
+	r.report.MinifiedLen += len(code)
+
+	// the `luamin` binary doesn't tell us where the tokens came from
+	if positions == nil {
+		return code, nil, nil
+	}
+
+	return code, minifiedMappings(positions, lines), nil
+}
+
+// validate checks the code for syntax errors
+func (r *SrcReader) validate(code string, lines []SourceLine, handler string) error {
+	_, err := luaparser.Parse(code)
+	if err != nil {
+		syntaxErr, ok := err.(*luaparser.SyntaxError)
+		if !ok {
+			return errors.WithStack(err)
+		}
+
+		return errors.WithStack(r.syntaxErrorToSource(syntaxErr, lines, handler))
+	}
+
+	return nil
+}
+
+// syntaxErrorToSource maps the position of a syntax error in the compiled code back to the source file
+func (r *SrcReader) syntaxErrorToSource(err *luaparser.SyntaxError, lines []SourceLine, handler string) *LuaSyntaxError {
+	// errors at the very end (such as a missing `end`) point to the last line with code in it
+	line := err.Line
+	if line > len(lines) {
+		line = len(lines)
+	}
+
+	for ; line > 0; line-- {
+		if lines[line-1].File != "" {
+			break
+		}
+	}
+
+	if line == 0 {
+		return &LuaSyntaxError{
+			File:    handler,
+			Line:    err.Line,
+			Column:  err.Column,
+			Message: err.Message,
+		}
+	}
+
+	srcLine := lines[line-1]
+	column := err.Column + srcLine.Indent
+	if line != err.Line {
+		column = 1
+	}
+
+	return &LuaSyntaxError{
+		File:    srcLine.File,
+		Line:    srcLine.Line,
+		Column:  column,
+		Message: err.Message,
+	}
+}
+
+// trimIndenting trims off any (consistent) indenting and records how much was trimmed off in the SourceLines
+func trimIndenting(code []string, lines []SourceLine) []string {
+	orig := make([]string, len(code))
+	copy(orig, code)
+
+	code = srcutils.TrimConsistentIndenting(code)
+	for k := range code {
+		lines[k].Indent = len(orig[k]) - len(code[k])
+	}
+
+	return code
+}
+
+func sourceLines(file string, firstLine int, n int) []SourceLine {
+	lines := make([]SourceLine, n)
+	for k := range lines {
+		lines[k] = SourceLine{File: file, Line: firstLine + k}
+	}
+
+	return lines
+}
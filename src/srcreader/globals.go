@@ -0,0 +1,81 @@
+package srcreader
+
+import (
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rubensayshi/dubby/src/luaparser"
+)
+
+// GlobalDefinition is where a lib file assigns a global, the file is its path as it's shown in messages
+type GlobalDefinition struct {
+	Name   string
+	File   string
+	Line   int
+	Column int
+}
+
+// LibGlobals finds the globals that the lib files assign (including the files of the shared libs),
+// only the first assignment in each file is listed. Files with syntax errors are skipped, they're reported by Read.
+func (r *SrcReader) LibGlobals() ([]*GlobalDefinition, error) {
+	libs, err := r.libSources(".")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	res := make([]*GlobalDefinition, 0)
+	for _, lib := range libs {
+		libFiles := make([]*libFile, 0)
+		err := r.readFromLibDir(lib, lib.dir, "", &libFiles)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		for _, f := range libFiles {
+			chunk, err := luaparser.Parse(f.content)
+			if err != nil {
+				continue
+			}
+
+			seen := make(map[string]bool)
+			for _, v := range chunk.Vars {
+				if v.Local != nil || !v.Assign || seen[v.Token.Value] {
+					continue
+				}
+				seen[v.Token.Value] = true
+
+				res = append(res, &GlobalDefinition{
+					Name:   v.Token.Value,
+					File:   f.displayPath,
+					Line:   v.Token.Line,
+					Column: v.Token.Column,
+				})
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// SlotFile checks if a file (relative to the source directory) is a slot file,
+// eg; `slots/0.screen.lua` is the file of slot 0 named `screen`.
+func (r *SrcReader) SlotFile(filePath string) (int, string, bool) {
+	dir, name := path.Split(path.Clean(filePath))
+	if path.Clean(dir) != path.Clean(r.slotsDir) {
+		return 0, "", false
+	}
+
+	s := strings.SplitN(strings.TrimSuffix(name, ".lua"), ".", 2)
+	if len(s) < 2 {
+		return 0, "", false
+	}
+
+	slotKey, err := strconv.Atoi(s[0])
+	if err != nil {
+		return 0, "", false
+	}
+
+	return slotKey, s[1], true
+}
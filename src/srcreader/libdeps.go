@@ -0,0 +1,186 @@
+package srcreader
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rubensayshi/dubby/src/luaparser"
+)
+
+// libDep is a lib file that another lib file depends on, because it defines a global the other uses when it's loaded
+type libDep struct {
+	file   int
+	global string
+}
+
+// LibCycle is when lib files depend on each other, so there's no order in which they can be loaded
+type LibCycle struct {
+	Files   []string // the files in the cycle, the last one depends on the first
+	Globals []string // the global each file uses from the next
+}
+
+func (c *LibCycle) String() string {
+	parts := make([]string, len(c.Files))
+	for k, file := range c.Files {
+		parts[k] = fmt.Sprintf("%s uses `%s` from %s", file, c.Globals[k], c.Files[(k+1)%len(c.Files)])
+	}
+
+	return "dependency cycle in lib: " + strings.Join(parts, ", ")
+}
+
+// libGlobals finds the globals a lib file defines and uses when it's loaded,
+// globals used inside of functions don't matter since those are only called later.
+func libGlobals(f *libFile) (map[string]bool, []string, error) {
+	chunk, err := luaparser.Parse(f.content)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	defines := make(map[string]bool)
+	uses := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, v := range chunk.Vars {
+		if v.Local != nil || v.Depth != 0 {
+			continue
+		}
+
+		if v.Assign {
+			defines[v.Token.Value] = true
+		} else if !seen[v.Token.Value] {
+			seen[v.Token.Value] = true
+			uses = append(uses, v.Token.Value)
+		}
+	}
+
+	return defines, uses, nil
+}
+
+// orderLibFiles orders the lib files so that the globals they use when they're loaded are defined before them,
+// files which don't depend on each other keep their order (the order of the files in the lib directories).
+// A global that a file defines itself (eg; `config = config or {}`) doesn't make it depend on other files.
+// Modules aren't ordered, since they're only loaded when they're required.
+// When files depend on each other in a cycle, the files in the cycle are taken in the order of their names
+// and the cycles are returned so they can be reported.
+func orderLibFiles(libFiles []*libFile) ([]*libFile, []*LibCycle, error) {
+	definedBy := make(map[string][]int)
+	uses := make([][]string, len(libFiles))
+	defines := make([]map[string]bool, len(libFiles))
+	for k, f := range libFiles {
+		if f.module {
+			continue
+		}
+
+		d, u, err := libGlobals(f)
+		if err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+
+		defines[k] = d
+		uses[k] = u
+		for global := range d {
+			definedBy[global] = append(definedBy[global], k)
+		}
+	}
+
+	deps := make([][]libDep, len(libFiles))
+	for k := range libFiles {
+		added := make(map[int]bool)
+		for _, global := range uses[k] {
+			if defines[k][global] {
+				continue
+			}
+
+			for _, dep := range definedBy[global] {
+				if !added[dep] {
+					added[dep] = true
+					deps[k] = append(deps[k], libDep{file: dep, global: global})
+				}
+			}
+		}
+	}
+
+	// repeatedly take the first file of which all dependencies have been taken
+	done := make([]bool, len(libFiles))
+	ordered := make([]*libFile, 0, len(libFiles))
+	cycles := make([]*LibCycle, 0)
+	for len(ordered) < len(libFiles) {
+		next := -1
+		for k := range libFiles {
+			if !done[k] && depsDone(deps[k], done) {
+				next = k
+				break
+			}
+		}
+
+		if next == -1 {
+			inCycle := libCycle(deps, done)
+			cycle := &LibCycle{
+				Files:   make([]string, len(inCycle)),
+				Globals: make([]string, len(inCycle)),
+			}
+			for i, dep := range inCycle {
+				cycle.Files[i] = libFiles[dep.file].displayPath
+				cycle.Globals[i] = dep.global
+			}
+			cycles = append(cycles, cycle)
+
+			// the files in the cycle are taken in the order of their names, after that the rest can be ordered again
+			for k := range libFiles {
+				for _, dep := range inCycle {
+					if dep.file == k {
+						done[k] = true
+						ordered = append(ordered, libFiles[k])
+						break
+					}
+				}
+			}
+			continue
+		}
+
+		done[next] = true
+		ordered = append(ordered, libFiles[next])
+	}
+
+	return ordered, cycles, nil
+}
+
+func depsDone(deps []libDep, done []bool) bool {
+	for _, dep := range deps {
+		if !done[dep.file] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// libCycle finds a cycle among the files that couldn't be ordered, by following their dependencies until a file repeats,
+// it's each file in the cycle with the global it uses from the next.
+func libCycle(deps [][]libDep, done []bool) []libDep {
+	k := 0
+	for done[k] {
+		k++
+	}
+
+	visited := make(map[int]int)
+	path := make([]libDep, 0)
+	for {
+		if start, ok := visited[k]; ok {
+			path = path[start:]
+			break
+		}
+		visited[k] = len(path)
+
+		// every file that's left has at least 1 dependency that's not done
+		for _, dep := range deps[k] {
+			if !done[dep.file] {
+				path = append(path, libDep{file: k, global: dep.global})
+				k = dep.file
+				break
+			}
+		}
+	}
+
+	return path
+}
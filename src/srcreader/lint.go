@@ -0,0 +1,157 @@
+package srcreader
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rubensayshi/dubby/src/diagnostics"
+	"github.com/rubensayshi/dubby/src/dustructs"
+	"github.com/rubensayshi/dubby/src/luaparser"
+)
+
+const (
+	RULE_SYNTAX            = "syntax"
+	RULE_UNKNOWN_FILTER    = "unknown-filter"
+	RULE_INVALID_FILTER    = "invalid-filter"
+	RULE_BAD_MARKER        = "bad-marker"
+	RULE_MAIN_MARKER       = "main-marker"
+	RULE_END_WITHOUT_START = "end-without-start"
+	RULE_UNCLOSED_HANDLER  = "unclosed-handler"
+	RULE_EMPTY_HANDLER     = "empty-handler"
+	RULE_DUPLICATE_FILTER  = "duplicate-filter"
+	RULE_SLOT_FILE         = "slot-file"
+	RULE_SLOT_INDEX        = "slot-index"
+	RULE_LIB_CYCLE         = "lib-cycle"
+)
+
+func newDiagnostic(file string, line int, code string, format string, args ...interface{}) *diagnostics.Diagnostic {
+	return &diagnostics.Diagnostic{
+		Severity: diagnostics.SEVERITY_ERROR,
+		File:     file,
+		Line:     line,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+	}
+}
+
+func (e *LuaSyntaxError) Diagnostic() *diagnostics.Diagnostic {
+	d := newDiagnostic(e.File, e.Line, RULE_SYNTAX, "%s", e.Message)
+	d.Column = e.Column
+
+	return d
+}
+
+func (c *LibCycle) Diagnostic() *diagnostics.Diagnostic {
+	return newDiagnostic(c.Files[0], 0, RULE_LIB_CYCLE, "%s, they're kept in the order of their names", c.String())
+}
+
+// Lint reads the source directory the same as Read, but instead of stopping at the first error it finds all of them.
+// The error is only for problems which prevent reading the source directory at all, such as a missing slots directory.
+func (r *SrcReader) Lint() ([]*diagnostics.Diagnostic, error) {
+	r.keepGoing = true
+	err := r.Read()
+	if err != nil && len(r.errs) == 0 {
+		return nil, errors.WithStack(err)
+	}
+
+	res := make([]*diagnostics.Diagnostic, len(r.Diagnostics()))
+	copy(res, r.Diagnostics())
+	diagnostics.Sort(res)
+
+	return res, nil
+}
+
+// lint checks for problems that don't stop the source directory from being exported, they're warnings
+func (r *SrcReader) lint() error {
+	err := r.lintSlotFiles()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return r.lintHandlers()
+}
+
+// lintSlotFiles checks the names of the slot files, files of which the slot key can't be parsed are reported when they're read
+func (r *SrcReader) lintSlotFiles() error {
+	entries, err := fs.ReadDir(r.fsys, r.slotsDir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		displayPath := path.Join(r.name, r.slotsDir, entry.Name())
+		slotKey, err := strconv.Atoi(strings.Split(entry.Name(), ".")[0])
+		if err != nil {
+			continue
+		}
+
+		if path.Ext(entry.Name()) != ".lua" {
+			err := r.warn(newDiagnostic(displayPath, 0, RULE_SLOT_FILE, "not a .lua file, it's read as a slot anyway"))
+			if err != nil {
+				return err
+			}
+		}
+
+		if slotKey < dustructs.SLOT_IDX_LIBRARY || slotKey > dustructs.SLOT_IDX_MAX {
+			err := r.warn(newDiagnostic(displayPath, 0, RULE_SLOT_INDEX, "slot %d is outside of the slots the game allows (%d to %d)", slotKey, dustructs.SLOT_IDX_LIBRARY, dustructs.SLOT_IDX_MAX))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// lintHandlers checks for handlers without any code and for the same filter (with the same args) twice in a slot
+func (r *SrcReader) lintHandlers() error {
+	seen := make(map[string]SourceLine)
+
+	// in the order of the source files, the handlers can be in a different order when they got their original keys back
+	handlers := make([]*dustructs.Handler, 0, len(r.scriptExport.Handlers))
+	for _, handler := range r.scriptExport.Handlers {
+		if _, ok := r.handlerPos[handler]; ok {
+			handlers = append(handlers, handler)
+		}
+	}
+	sort.SliceStable(handlers, func(i, j int) bool {
+		a, b := r.handlerPos[handlers[i]], r.handlerPos[handlers[j]]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+
+	for _, handler := range handlers {
+		pos := r.handlerPos[handler]
+
+		tokens, err := luaparser.Tokenize(handler.Code)
+		if err == nil && len(tokens) == 1 {
+			err := r.warn(newDiagnostic(pos.File, pos.Line, RULE_EMPTY_HANDLER, "handler %s has no code", handler.Filter.Signature))
+			if err != nil {
+				return err
+			}
+		}
+
+		key := fmt.Sprintf("%d:%s", handler.Filter.SlotKey, handler.Filter.Signature)
+		if first, ok := seen[key]; ok {
+			err := r.warn(newDiagnostic(pos.File, pos.Line, RULE_DUPLICATE_FILTER, "duplicate filter %s, it's already in %s:%d", handler.Filter.Signature, first.File, first.Line))
+			if err != nil {
+				return err
+			}
+		} else {
+			seen[key] = pos
+		}
+	}
+
+	return nil
+}
@@ -1,16 +1,18 @@
 package srcreader
 
 import (
-	"io/ioutil"
+	"encoding/json"
+	"fmt"
+	"io/fs"
 	"os"
 	"path"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
-	"github.com/rubensayshi/dubby/src/luamin"
-
 	"github.com/pkg/errors"
+	"github.com/rubensayshi/dubby/src/diagnostics"
 	"github.com/rubensayshi/dubby/src/dustructs"
 	"github.com/rubensayshi/dubby/src/srcutils"
 )
@@ -18,9 +20,22 @@ import (
 var badHandlerStartRegexp = regexp.MustCompile(`^.*-- ?!DU:.*$`)
 var handlerStartRegexp = regexp.MustCompile(`^(do)? *-- ?!DU: *((?P<fn>[a-zA-Z0-9_-]+)\(\[?(?P<args>.*?)\]?\)) *$`)
 var handlerEndRegexp = regexp.MustCompile(`^(end)? *-- ?!DU: end *$`)
+var libOrderPrefixRegexp = regexp.MustCompile(`^([0-9]+)\.(.+)$`)
+var slotClassRegexp = regexp.MustCompile(`^-- ?!DU\[class]: *([a-zA-Z0-9_]+) *$`)
+
+// the element classes of the default slots
+var defaultSlotClasses = map[int]string{
+	dustructs.SLOT_IDX_UNIT:    "ControlUnit",
+	dustructs.SLOT_IDX_SYSTEM:  "System",
+	dustructs.SLOT_IDX_LIBRARY: "Library",
+}
 
 func Read(srcDir string) (*dustructs.ScriptExport, error) {
-	r := NewSrcReader(srcDir, false)
+	return ReadFS(os.DirFS(srcDir))
+}
+
+func ReadFS(fsys fs.FS) (*dustructs.ScriptExport, error) {
+	r := NewSrcReaderFS(fsys, false)
 	err := r.Read()
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -29,25 +44,132 @@ func Read(srcDir string) (*dustructs.ScriptExport, error) {
 	return r.scriptExport, nil
 }
 
+// SrcReader reads a source directory from a fs.FS, all paths are relative to its root
 type SrcReader struct {
-	srcDir       string
+	fsys         fs.FS
+	slotsDir     string
+	libDir       string
+	sharedLibs   []*libSource
+	modulePaths  []*libSource
+	requires     []string
+	slotClasses  map[int]string
+	filters      *srcutils.Filters
 	minify       bool
+	renumber     bool
 	scriptExport *dustructs.ScriptExport
 	report       *Report
+	mappings     map[*dustructs.Handler][]*Mapping
+	handlerPos   map[*dustructs.Handler]SourceLine // where the handlers of the slot files start
+	keepGoing    bool                              // keep reading after an error, to find all of them (see Lint)
+	errs         []error
+	name         string
+
+	diagnostics.Collector
+}
+
+// libSource is a directory with lib files, the name is prefixed to the paths of its files in messages
+type libSource struct {
+	name     string
+	fsys     fs.FS
+	dir      string
+	include  []string
+	external bool // from outside of the source directory
 }
 
 type Report struct {
 	SrcLen      int
 	MinifiedLen int
+	SourceMap   *SourceMap
 }
 
 func NewSrcReader(srcDir string, minify bool) *SrcReader {
+	return NewSrcReaderFS(os.DirFS(srcDir), minify)
+}
+
+func NewSrcReaderFS(fsys fs.FS, minify bool) *SrcReader {
 	return &SrcReader{
-		srcDir:       srcDir,
+		fsys:         fsys,
+		slotsDir:     "slots",
+		libDir:       "lib",
+		sharedLibs:   make([]*libSource, 0),
+		modulePaths:  make([]*libSource, 0),
+		requires:     make([]string, 0),
+		slotClasses:  make(map[int]string),
+		filters:      srcutils.NewFilters(),
 		minify:       minify,
 		scriptExport: dustructs.NewScriptExport(),
 		report:       &Report{},
+		mappings:     make(map[*dustructs.Handler][]*Mapping),
+		handlerPos:   make(map[*dustructs.Handler]SourceLine),
+		errs:         make([]error, 0),
+	}
+}
+
+// SetName sets the name that's prefixed to the paths of the files of the source directory in messages and the source map,
+// eg; the path of the source directory relative to the project.
+func (r *SrcReader) SetName(name string) {
+	r.name = name
+}
+
+// SetRenumber numbers the handlers in the order they're read, instead of giving them back the original keys from the metadata file
+func (r *SrcReader) SetRenumber(renumber bool) {
+	r.renumber = renumber
+}
+
+// SetLayout sets the names of the slots and lib directories
+func (r *SrcReader) SetLayout(slotsDir string, libDir string) {
+	r.slotsDir = slotsDir
+	r.libDir = libDir
+}
+
+// AddSharedLib adds a lib directory from outside the source directory (the root of fsys),
+// its files are included before the lib of the source directory and the name is used to refer to them in messages.
+// When include is specified only the matching files are included, see matchesInclude.
+func (r *SrcReader) AddSharedLib(name string, fsys fs.FS, include ...string) {
+	r.sharedLibs = append(r.sharedLibs, &libSource{
+		name:     name,
+		fsys:     fsys,
+		dir:      ".",
+		include:  include,
+		external: true,
+	})
+}
+
+// AddModulePath adds a directory (the root of fsys) to look for modules that are required with `require("name")`,
+// unlike the lib directories only the modules which are required are included.
+func (r *SrcReader) AddModulePath(name string, fsys fs.FS) {
+	r.modulePaths = append(r.modulePaths, &libSource{
+		name:     name,
+		fsys:     fsys,
+		dir:      ".",
+		external: true,
+	})
+}
+
+// SetFilters sets the filters that are known, eg; the catalogue with the filters of the dubby.yaml added to it
+func (r *SrcReader) SetFilters(filters *srcutils.Filters) {
+	r.filters = filters
+}
+
+// Filters are the filters that are known
+func (r *SrcReader) Filters() *srcutils.Filters {
+	return r.filters
+}
+
+// SetSlotClass sets the element class of a slot, its filters are checked against the filters of that class.
+// A `-- !DU[class]: ScreenUnit` line at the top of the slot file takes precedence.
+func (r *SrcReader) SetSlotClass(slotKey int, class string) {
+	r.slotClasses[slotKey] = class
+}
+
+// SlotClass is the element class of a slot, or "" when it's not known.
+// The class from the top of the slot file is only known once the slot file has been read.
+func (r *SrcReader) SlotClass(slotKey int) string {
+	if class, ok := r.slotClasses[slotKey]; ok {
+		return class
 	}
+
+	return defaultSlotClasses[slotKey]
 }
 
 func (r *SrcReader) ScriptExport() *dustructs.ScriptExport {
@@ -59,33 +181,99 @@ func (r *SrcReader) Report() *Report {
 }
 
 func (r *SrcReader) Read() error {
-	return r.readFromSrcDir(r.srcDir)
+	err := r.readFromSrcDir(".")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = r.lint()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if len(r.errs) > 0 {
+		return errors.WithStack(r.errs[0])
+	}
+
+	// the class of the linked slots is kept for the auto configure format, the default slots always have the same class
+	for slotKey, slot := range r.scriptExport.Slots {
+		if slotKey >= 0 {
+			slot.Class = r.SlotClass(slotKey)
+		}
+	}
+
+	// the handler keys are only final once everything has been read
+	r.report.SourceMap = NewSourceMap()
+	for _, handler := range r.scriptExport.Handlers {
+		if mappings, ok := r.mappings[handler]; ok {
+			r.report.SourceMap.Handlers = append(r.report.SourceMap.Handlers, &HandlerSourceMap{
+				Key:       handler.Key,
+				SlotKey:   handler.Filter.SlotKey,
+				Signature: handler.Filter.Signature,
+				Mappings:  mappings,
+			})
+		}
+	}
+
+	return nil
+}
+
+// fail returns err, unless we're to keep going after errors, then it's collected and reading can continue
+func (r *SrcReader) fail(err error) error {
+	if !r.keepGoing {
+		return errors.WithStack(err)
+	}
+
+	r.errs = append(r.errs, err)
+	r.Add(diagnostics.FromError(err))
+
+	return nil
+}
+
+// warn collects a problem that doesn't stop the source directory from being exported, unless strict is set
+func (r *SrcReader) warn(d *diagnostics.Diagnostic) error {
+	err := r.Warn(d)
+	if err != nil {
+		return r.fail(err)
+	}
+
+	return nil
 }
 
 func (r *SrcReader) readFromSrcDir(dir string) error {
-	slots, err := os.Stat(path.Join(dir, "slots"))
+	slots, err := fs.Stat(r.fsys, path.Join(dir, r.slotsDir))
 	if err != nil {
 		return errors.WithStack(err)
 	}
 	if !slots.IsDir() {
-		return errors.Errorf("slots is a file, expected a directory")
+		return errors.Errorf("%s is a file, expected a directory", r.slotsDir)
+	}
+
+	err = r.readFromSlotsDir(path.Join(dir, r.slotsDir))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	libs, err := r.libSources(dir)
+	if err != nil {
+		return errors.WithStack(err)
 	}
 
-	err = r.readFromSlotsDir(path.Join(dir, slots.Name()))
+	err = r.readFromLibs(libs)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	lib, err := os.Stat(path.Join(dir, "lib"))
-	if !os.IsNotExist(err) {
+	metadata, err := fs.Stat(r.fsys, path.Join(dir, dustructs.METADATA_FILE))
+	if !errors.Is(err, fs.ErrNotExist) {
 		if err != nil {
 			return errors.WithStack(err)
 		}
-		if !lib.IsDir() {
-			return errors.Errorf("lib is a file, expected a directory")
+		if metadata.IsDir() {
+			return errors.Errorf("%s is a directory, expected a file", dustructs.METADATA_FILE)
 		}
 
-		err = r.readFromLibDir(path.Join(dir, lib.Name()))
+		err = r.readFromMetadataFile(path.Join(dir, metadata.Name()))
 		if err != nil {
 			return errors.WithStack(err)
 		}
@@ -94,8 +282,57 @@ func (r *SrcReader) readFromSrcDir(dir string) error {
 	return nil
 }
 
+// libSources are the shared libs and the lib directory of the source directory (when there is one),
+// the shared libs go first so the lib of the source directory can use them.
+func (r *SrcReader) libSources(dir string) ([]*libSource, error) {
+	libs := make([]*libSource, len(r.sharedLibs))
+	copy(libs, r.sharedLibs)
+
+	lib, err := fs.Stat(r.fsys, path.Join(dir, r.libDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return libs, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if !lib.IsDir() {
+		return nil, errors.Errorf("%s is a file, expected a directory", r.libDir)
+	}
+
+	libs = append(libs, &libSource{
+		name: r.name,
+		fsys: r.fsys,
+		dir:  path.Join(dir, r.libDir),
+	})
+
+	return libs, nil
+}
+
+func (r *SrcReader) readFromMetadataFile(filePath string) error {
+	buf, err := fs.ReadFile(r.fsys, filePath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	metadata := dustructs.NewMetadata()
+	err = json.Unmarshal(buf, metadata)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse %s", filePath)
+	}
+
+	if r.renumber {
+		for _, handlerMetadata := range metadata.Handlers {
+			handlerMetadata.Key = nil
+		}
+	}
+
+	metadata.ApplyTo(r.scriptExport)
+
+	return nil
+}
+
 func (r *SrcReader) readFromSlotsDir(slotsDir string) error {
-	slotFiles, err := ioutil.ReadDir(slotsDir)
+	slotFiles, err := fs.ReadDir(r.fsys, slotsDir)
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -104,19 +341,31 @@ func (r *SrcReader) readFromSlotsDir(slotsDir string) error {
 		slotFilePath := path.Join(slotsDir, slotFile.Name())
 
 		if slotFile.IsDir() {
-			return errors.Errorf("slotFile is a directory, expected a file: %s", slotFilePath)
+			err := r.fail(newDiagnostic(path.Join(r.name, slotFilePath), 0, RULE_SLOT_FILE, "is a directory, expected a file"))
+			if err != nil {
+				return err
+			}
+			continue
 		}
 
 		s := strings.Split(slotFile.Name(), ".")
 		if len(s) < 2 {
-			return errors.Errorf("slotFile should its slot key as index [%s]", slotFile.Name())
+			err := r.fail(newDiagnostic(path.Join(r.name, slotFilePath), 0, RULE_SLOT_FILE, "slot file should start with its slot key, eg; `0.screen.lua`"))
+			if err != nil {
+				return err
+			}
+			continue
 		}
 		slotKeyStr := s[0]
-		slotName := strings.Join(s[1:], ".")
+		slotName := strings.TrimSuffix(strings.Join(s[1:], "."), ".lua")
 
 		slotKey, err := strconv.Atoi(slotKeyStr)
 		if err != nil {
-			return errors.WithStack(err)
+			err := r.fail(newDiagnostic(path.Join(r.name, slotFilePath), 0, RULE_SLOT_FILE, "slot key should be a number: %s", slotKeyStr))
+			if err != nil {
+				return err
+			}
+			continue
 		}
 
 		// init the slot
@@ -136,7 +385,7 @@ func (r *SrcReader) readFromSlotsDir(slotsDir string) error {
 func (r *SrcReader) readFromSlotFile(filePath string, slotKey int) error {
 	handlers := make([]*dustructs.Handler, 0)
 
-	buf, err := ioutil.ReadFile(filePath)
+	buf, err := fs.ReadFile(r.fsys, filePath)
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -144,31 +393,79 @@ func (r *SrcReader) readFromSlotFile(filePath string, slotKey int) error {
 	// @TODO: for now, get rid of windows line endings, should be configurable ...
 	content = strings.ReplaceAll(content, "\r\n", "\n")
 
+	// the path of the file as it's shown in messages
+	displayPath := path.Join(r.name, filePath)
+
 	lines := strings.Split(content, "\n")
 	if len(lines) > 0 {
 		var handler *dustructs.Handler
+		handlerStart := 0
+		invalidHandler := false // when the start marker has a problem, the handler is checked but not added
 
 		mainCode := make([]string, 0)
+		mainLines := make([]SourceLine, 0)
 		handlerCode := make([]string, 0)
+		handlerLines := make([]SourceLine, 0)
 
-		for k, line := range lines {
-			if handlerStartRegexp.MatchString(line) {
-				header, err := extractHeaderFromLine(line)
+		closeHandler := func() error {
+			// trim off any (consistent) indenting
+			handlerCode = trimIndenting(handlerCode, handlerLines)
+
+			r.requires = append(r.requires, requiredModules(strings.Join(handlerCode, "\n"))...)
+
+			code, mappings, err := r.compile(strings.Join(handlerCode, "\n"), handlerLines, fmt.Sprintf("%s:%s", displayPath, handler.Filter.Signature))
+			if err != nil {
+				err := r.fail(err)
 				if err != nil {
-					return errors.WithStack(err)
+					return err
+				}
+				invalidHandler = true
+			}
+
+			// flush handler
+			if !invalidHandler {
+				handler.Code = code
+				r.mappings[handler] = mappings
+				r.handlerPos[handler] = SourceLine{File: displayPath, Line: handlerStart}
+				handlers = append(handlers, handler)
+			}
+
+			// reset state
+			handler = nil
+			handlerCode = []string{}
+			handlerLines = []SourceLine{}
+
+			return nil
+		}
+
+		for k, line := range lines {
+			if m := slotClassRegexp.FindStringSubmatch(line); k == 0 && m != nil {
+				r.slotClasses[slotKey] = m[1]
+			} else if handlerStartRegexp.MatchString(line) {
+				// the handler before it is closed, as if its end marker was there
+				if handler != nil {
+					err := r.warn(newDiagnostic(displayPath, handlerStart, RULE_UNCLOSED_HANDLER, "handler %s isn't closed before the next one starts", handler.Filter.Signature))
+					if err != nil {
+						return err
+					}
+
+					err = closeHandler()
+					if err != nil {
+						return err
+					}
 				}
 
-				fnname, args, err := srcutils.ParseHeader(header)
+				header, err := r.readHandlerStart(displayPath, k+1, line, slotKey)
 				if err != nil {
-					return errors.WithStack(err)
+					return err
 				}
 
-				if srcutils.FilterSignatures[fnname] == "" {
-					return errors.Errorf("unknown filter signature: [%d][%s]", k, line)
+				invalidHandler = header == ""
+				if invalidHandler {
+					header = strings.TrimSpace(strings.SplitN(line, "!DU:", 2)[1])
 				}
 
-				signature := srcutils.FilterSignatures[fnname]
-				header, _ = srcutils.MakeHeader(signature, args)
+				_, args, _ := srcutils.ParseHeader(header)
 
 				handler = &dustructs.Handler{
 					Filter: &dustructs.Filter{
@@ -177,51 +474,56 @@ func (r *SrcReader) readFromSlotFile(filePath string, slotKey int) error {
 						SlotKey:   slotKey,
 					},
 				}
+				handlerStart = k + 1
 			} else if handlerEndRegexp.MatchString(line) {
+				// there's no telling where the handler was meant to start, so it's an error
 				if handler == nil {
-					// @TODO: could be warning?
-					return errors.Errorf("end marker without start: [%d][%s]", k, line)
-				}
-
-				// trim off any (consistent) indenting
-				handlerCode = srcutils.TrimConsistentIndenting(handlerCode)
-
-				code := strings.Join(handlerCode, "\n")
-				r.report.SrcLen += len(code)
-				if r.minify {
-					minified, err := luamin.LuaMin([]byte(code))
+					err := r.fail(newDiagnostic(displayPath, k+1, RULE_END_WITHOUT_START, "end marker without start"))
 					if err != nil {
-						return errors.WithStack(err)
+						return err
 					}
-
-					code = string(minified)
-					r.report.MinifiedLen += len(code)
+					continue
 				}
 
-				// flush handler
-				handler.Code = code
-				handlers = append(handlers, handler)
-
-				// reset state
-				handler = nil
-				handlerCode = []string{}
-
+				err := closeHandler()
+				if err != nil {
+					return err
+				}
+			} else if strings.TrimSpace(line) == "-- !DU: main" {
+				// the line is ignored, which is fine since it's only a comment
+				err := r.warn(newDiagnostic(displayPath, k+1, RULE_MAIN_MARKER, "leftover main marker, the code outside of the handlers is the main code already"))
+				if err != nil {
+					return err
+				}
 			} else if badHandlerStartRegexp.MatchString(line) {
-				// @TODO: could be warning?
-				return errors.Errorf("bad marker: [%d][%s]", k, line)
+				// the line can have code in it, or be meant as the start of a handler, so it's an error
+				err := r.fail(newDiagnostic(displayPath, k+1, RULE_BAD_MARKER, "bad marker: %s", strings.TrimSpace(line)))
+				if err != nil {
+					return err
+				}
 			} else {
 				// append code to handler or to main block
 				if handler != nil {
 					handlerCode = append(handlerCode, line)
+					handlerLines = append(handlerLines, SourceLine{File: displayPath, Line: k + 1})
 				} else {
 					mainCode = append(mainCode, line)
+					mainLines = append(mainLines, SourceLine{File: displayPath, Line: k + 1})
 				}
 			}
 		}
 
-		if handler != nil || len(handlerCode) > 0 {
-			// @TODO: could be warning?
-			return errors.Errorf("unclosed state")
+		// the handler is closed at the end of the file, as if its end marker was there
+		if handler != nil {
+			err := r.warn(newDiagnostic(displayPath, handlerStart, RULE_UNCLOSED_HANDLER, "handler %s isn't closed with an end marker", handler.Filter.Signature))
+			if err != nil {
+				return err
+			}
+
+			err = closeHandler()
+			if err != nil {
+				return err
+			}
 		}
 
 		// if we have a main block then we need to add a handler for it
@@ -237,36 +539,38 @@ func (r *SrcReader) readFromSlotFile(filePath string, slotKey int) error {
 			if !justWhitelines {
 				// main block needs marker
 				mainCode = append([]string{"-- !DU: main"}, mainCode...)
+				mainLines = append([]SourceLine{{}}, mainLines...)
 
 				// trim of 2 trailing lines, these keep being added
 				if mainCode[len(mainCode)-1] == "" {
 					mainCode = mainCode[:len(mainCode)-1]
+					mainLines = mainLines[:len(mainLines)-1]
 				}
 				if mainCode[len(mainCode)-1] == "" {
 					mainCode = mainCode[:len(mainCode)-1]
+					mainLines = mainLines[:len(mainLines)-1]
 				}
 
-				code := strings.Join(mainCode, "\n")
-				r.report.SrcLen += len(code)
-				if r.minify {
-					minified, err := luamin.LuaMin([]byte(code))
+				r.requires = append(r.requires, requiredModules(strings.Join(mainCode, "\n"))...)
+
+				code, mappings, err := r.compile(strings.Join(mainCode, "\n"), mainLines, fmt.Sprintf("%s:main", displayPath))
+				if err != nil {
+					err := r.fail(err)
 					if err != nil {
-						return errors.WithStack(err)
+						return err
 					}
-
-					code = string(minified)
-					r.report.MinifiedLen += len(code)
-				}
-
-				mainHandler := &dustructs.Handler{
-					Code: code,
-					Filter: &dustructs.Filter{
-						Signature: "start()",
-						Args:      []dustructs.Arg{},
-						SlotKey:   slotKey,
-					},
+				} else {
+					mainHandler := &dustructs.Handler{
+						Code: code,
+						Filter: &dustructs.Filter{
+							Signature: "start()",
+							Args:      []dustructs.Arg{},
+							SlotKey:   slotKey,
+						},
+					}
+					r.mappings[mainHandler] = mappings
+					handlers = append([]*dustructs.Handler{mainHandler}, handlers...)
 				}
-				handlers = append([]*dustructs.Handler{mainHandler}, handlers...)
 			}
 		}
 	}
@@ -284,53 +588,112 @@ func (r *SrcReader) readFromSlotFile(filePath string, slotKey int) error {
 	return nil
 }
 
-func (r *SrcReader) readFromLibDir(libDir string) error {
-	files, err := ioutil.ReadDir(libDir)
+// readHandlerStart reads the start marker of a handler and returns its header, eg; `tick([Live])`,
+// the header is "" when there's a problem with the marker and we keep going.
+func (r *SrcReader) readHandlerStart(displayPath string, lineNr int, line string, slotKey int) (string, error) {
+	header, err := extractHeaderFromLine(line)
 	if err != nil {
-		return errors.WithStack(err)
+		return "", r.fail(newDiagnostic(displayPath, lineNr, RULE_BAD_MARKER, "%s", err.Error()))
 	}
 
-	if len(files) == 0 {
-		return nil
+	fnname, args, err := srcutils.ParseHeader(header)
+	if err != nil {
+		return "", r.fail(newDiagnostic(displayPath, lineNr, RULE_BAD_MARKER, "%s", err.Error()))
 	}
 
-	libContent := make([]string, 0)
+	if _, ok := r.filters.Signature(fnname); !ok {
+		return "", r.fail(newDiagnostic(displayPath, lineNr, RULE_UNKNOWN_FILTER, "unknown filter signature: %s", header))
+	}
 
-	for _, file := range files {
-		filePath := path.Join(libDir, file.Name())
+	header, err = r.filters.MakeClassHeader(r.SlotClass(slotKey), fnname, args)
+	if err != nil {
+		return "", r.fail(newDiagnostic(displayPath, lineNr, RULE_INVALID_FILTER, "%s", errors.Cause(err).Error()))
+	}
 
-		if file.IsDir() {
-			return errors.Errorf("file is a directory, expected a file: %s", filePath)
-		}
+	return header, nil
+}
 
-		buf, err := ioutil.ReadFile(filePath)
+func (r *SrcReader) readFromLibs(libs []*libSource) error {
+	libFiles := make([]*libFile, 0)
+	for _, lib := range libs {
+		files := make([]*libFile, 0)
+		err := r.readFromLibDir(lib, lib.dir, "", &files)
 		if err != nil {
 			return errors.WithStack(err)
 		}
-		content := string(buf)
-		content = strings.ReplaceAll(content, "\r\n", "\n")
 
-		handlerName := strings.TrimSuffix(file.Name(), ".lua")
-		libName := handlerName
+		// validate each file by itself first, so errors such as a missing `end` point to the right file
+		for _, f := range files {
+			err = r.validate(f.content, f.lines, f.displayPath)
+			if err != nil {
+				err := r.fail(err)
+				if err != nil {
+					return err
+				}
+				continue
+			}
 
-		libContent = append(libContent, "-- !DU[lib]: "+libName+"\n\n"+content)
+			libFiles = append(libFiles, f)
+		}
 	}
 
-	// shift all handlers 1 slot forward
-	for key, handler := range r.scriptExport.Handlers {
-		r.scriptExport.Handlers[key].Key = handler.Key + 1
+	modules, err := r.bundleModules(libFiles)
+	if err != nil {
+		return errors.WithStack(err)
 	}
 
-	code := strings.Join(libContent, "\n")
-	r.report.SrcLen += len(code)
-	if r.minify {
-		minified, err := luamin.LuaMin([]byte(code))
+	ordered, cycles, err := orderLibFiles(libFiles)
+	if err != nil {
+		// when we keep going the lib files stay in the order of their names
+		err := r.fail(err)
 		if err != nil {
-			return errors.WithStack(err)
+			return err
+		}
+	} else {
+		libFiles = ordered
+	}
+	for _, cycle := range cycles {
+		err := r.warn(cycle.Diagnostic())
+		if err != nil {
+			return err
+		}
+	}
+
+	libContent := make([]string, 0)
+	libLines := make([]SourceLine, 0)
+
+	// the modules go first, so they're available to everything that's loaded after
+	for _, f := range modules {
+		libContent = append(libContent, libMarker("module", f)+moduleName(f.name)+"\n"+fmt.Sprintf(modulePreloadStart, moduleName(f.name))+"\n"+f.content+modulePreloadEnd+"\n")
+		// the marker and the start of the function are followed by the lines of the file and the end of the function
+		libLines = append(libLines, SourceLine{}, SourceLine{})
+		libLines = append(libLines, f.lines...)
+		libLines = append(libLines, SourceLine{})
+	}
+
+	for _, f := range libFiles {
+		if f.module {
+			continue
 		}
 
-		code = string(minified)
-		r.report.MinifiedLen += len(code)
+		libContent = append(libContent, libMarker("lib", f)+f.name+"\n\n"+f.content)
+		// the marker and blank line are followed by the lines of the file
+		libLines = append(libLines, SourceLine{}, SourceLine{})
+		libLines = append(libLines, f.lines...)
+	}
+
+	if len(libContent) == 0 {
+		return nil
+	}
+
+	code, mappings, err := r.compile(strings.Join(libContent, ""), libLines, "lib")
+	if err != nil {
+		return r.fail(err)
+	}
+
+	// shift all handlers 1 slot forward
+	for key, handler := range r.scriptExport.Handlers {
+		r.scriptExport.Handlers[key].Key = handler.Key + 1
 	}
 
 	handler := &dustructs.Handler{
@@ -342,12 +705,144 @@ func (r *SrcReader) readFromLibDir(libDir string) error {
 		},
 		Key: 1, // @TODO: maybe we can do 0?
 	}
+	r.mappings[handler] = mappings
 
 	r.scriptExport.Handlers = append([]*dustructs.Handler{handler}, r.scriptExport.Handlers...)
 
 	return nil
 }
 
+// readFromLibDir reads the lib files in dir and its subdirectories, depth first and in the order of sortLibEntries,
+// the name of a file is its path relative to the lib directory without the number prefixes, eg; `utils/strings`.
+func (r *SrcReader) readFromLibDir(lib *libSource, dir string, prefix string, libFiles *[]*libFile) error {
+	entries, err := fs.ReadDir(lib.fsys, dir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	sortLibEntries(entries)
+
+	for _, entry := range entries {
+		filePath := path.Join(dir, entry.Name())
+
+		name := path.Join(prefix, libEntryName(entry.Name()))
+
+		if entry.IsDir() {
+			err := r.readFromLibDir(lib, filePath, name, libFiles)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			continue
+		}
+
+		if !matchesInclude(lib.include, name) {
+			continue
+		}
+
+		f, err := r.readLibFile(lib, filePath, name)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		*libFiles = append(*libFiles, f)
+	}
+
+	return nil
+}
+
+// LibName is the name of a lib file by its path relative to the lib directory, eg; `utils/strings` for `1.utils/0.strings.lua`
+func LibName(filePath string) string {
+	parts := strings.Split(path.Clean(filePath), "/")
+	for k, part := range parts {
+		parts[k] = libEntryName(part)
+	}
+
+	return strings.Join(parts, "/")
+}
+
+// libEntryName strips off the number prefix, which is only there for ordering, and the extension of a lib file or directory
+func libEntryName(entryName string) string {
+	name := strings.TrimSuffix(entryName, ".lua")
+	if m := libOrderPrefixRegexp.FindStringSubmatch(name); m != nil {
+		name = m[2]
+	}
+
+	return name
+}
+
+// matchesInclude checks if a lib file should be included, a pattern matches the name of a file (eg; `utils/strings`),
+// the module name (eg; `utils.strings`), all files in a directory (eg; `utils`) or it can be a glob (eg; `utils/*`).
+// Everything is included when there are no patterns.
+func matchesInclude(include []string, name string) bool {
+	if len(include) == 0 {
+		return true
+	}
+
+	for _, pattern := range include {
+		pattern = strings.TrimSuffix(pattern, ".lua")
+		if pattern == name || pattern == moduleName(name) || strings.HasPrefix(name, pattern+"/") {
+			return true
+		}
+
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// libMarker is the start of the marker for a lib or module, `ext` is prefixed when it's from outside of the source directory,
+// so that parse-to-src knows not to place them in the lib directory.
+func libMarker(kind string, f *libFile) string {
+	if f.external {
+		kind = "ext" + kind
+	}
+
+	return "-- !DU[" + kind + "]: "
+}
+
+// sortLibEntries sorts by the number prefix first (so `2.a` comes before `10.b`) and then by name
+func sortLibEntries(entries []fs.DirEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a := libOrderPrefixRegexp.FindStringSubmatch(entries[i].Name())
+		b := libOrderPrefixRegexp.FindStringSubmatch(entries[j].Name())
+		if a != nil && b != nil {
+			an, _ := strconv.Atoi(a[1])
+			bn, _ := strconv.Atoi(b[1])
+			if an != bn {
+				return an < bn
+			}
+		}
+
+		return entries[i].Name() < entries[j].Name()
+	})
+}
+
+func (r *SrcReader) readLibFile(lib *libSource, filePath string, name string) (*libFile, error) {
+	buf, err := fs.ReadFile(lib.fsys, filePath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	content := string(buf)
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	// make sure the next lib marker ends up on its own line
+	if !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+
+	// the path of the file as it's shown in messages
+	displayPath := path.Join(lib.name, filePath)
+
+	return &libFile{
+		name:        name,
+		external:    lib.external,
+		displayPath: displayPath,
+		content:     content,
+		lines:       sourceLines(displayPath, 1, strings.Count(content, "\n")),
+	}, nil
+}
+
 func extractHeaderFromLine(line string) (string, error) {
 	res := handlerStartRegexp.FindStringSubmatch(line)
 	if res == nil || len(res) < 4 {
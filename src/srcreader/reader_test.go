@@ -2,13 +2,21 @@ package srcreader
 
 import (
 	"encoding/json"
+	"fmt"
+	"io/fs"
 	"io/ioutil"
+	"os"
 	"path"
 	"testing"
+	"testing/fstest"
 
+	"github.com/rubensayshi/dubby/src/diagnostics"
 	"github.com/rubensayshi/dubby/src/dustructs"
+	"github.com/rubensayshi/dubby/src/srcutils"
+	"github.com/rubensayshi/dubby/src/srcwriter"
 	"github.com/rubensayshi/dubby/src/utils"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
 )
 
 func TestSrcReader_Read1(t *testing.T) {
@@ -26,3 +34,756 @@ func TestSrcReader_Read1(t *testing.T) {
 
 	assert.Equal(expected, actual)
 }
+
+func TestSrcReader_LibNames(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "dubby")
+	assert.NoError(err)
+	defer os.RemoveAll(dir) // always cleanup the mess
+
+	assert.NoError(os.MkdirAll(path.Join(dir, "slots"), 0777))
+	assert.NoError(os.MkdirAll(path.Join(dir, "lib"), 0777))
+	assert.NoError(ioutil.WriteFile(path.Join(dir, "lib/0.utils.lua"), []byte("u = 1\n"), 0666))
+	assert.NoError(ioutil.WriteFile(path.Join(dir, "lib/1.more.utils.lua"), []byte("m = 1\n"), 0666))
+	assert.NoError(ioutil.WriteFile(path.Join(dir, "lib/other.lua"), []byte("o = 1\n"), 0666))
+
+	actual, err := Read(dir)
+	assert.NoError(err)
+
+	// the number prefix is only there for the order of the files, it's not part of the name
+	assert.Contains(actual.Handlers[0].Code, "-- !DU[lib]: utils\n")
+	assert.Contains(actual.Handlers[0].Code, "-- !DU[lib]: more.utils\n")
+	assert.Contains(actual.Handlers[0].Code, "-- !DU[lib]: other\n")
+}
+
+func TestSrcReader_LibMarkers(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "dubby")
+	assert.NoError(err)
+	defer os.RemoveAll(dir) // always cleanup the mess
+
+	assert.NoError(os.MkdirAll(path.Join(dir, "slots"), 0777))
+	assert.NoError(os.MkdirAll(path.Join(dir, "lib"), 0777))
+	assert.NoError(ioutil.WriteFile(path.Join(dir, "lib/0.a.lua"), []byte("a = 1"), 0666))
+	assert.NoError(ioutil.WriteFile(path.Join(dir, "lib/1.b.lua"), []byte("b = 1\n"), 0666))
+
+	actual, err := Read(dir)
+	assert.NoError(err)
+
+	// every lib marker is on its own line, without blank lines being added between the files
+	assert.Equal("-- !DU[lib]: a\n\na = 1\n-- !DU[lib]: b\n\nb = 1\n", actual.Handlers[0].Code)
+}
+
+func TestSrcReader_Read2(t *testing.T) {
+	assert := require.New(t)
+
+	f, err := ioutil.ReadFile(path.Join(utils.ROOT, "testvectors/testvector2", "input.json"))
+	assert.NoError(err)
+
+	expected := &dustructs.ScriptExport{}
+	err = json.Unmarshal(f, expected)
+	assert.NoError(err)
+
+	actual, err := Read(path.Join(utils.ROOT, "testvectors/testvector2", "output"))
+	assert.NoError(err)
+
+	assert.Equal(expected, actual)
+}
+
+func TestSrcReader_Read3(t *testing.T) {
+	assert := require.New(t)
+
+	f, err := ioutil.ReadFile(path.Join(utils.ROOT, "testvectors/testvector3", "input.json"))
+	assert.NoError(err)
+
+	expected := &dustructs.ScriptExport{}
+	err = json.Unmarshal(f, expected)
+	assert.NoError(err)
+
+	actual, err := Read(path.Join(utils.ROOT, "testvectors/testvector3", "output"))
+	assert.NoError(err)
+
+	assert.Equal(expected, actual)
+}
+
+func TestSrcReader_SyntaxErrors(t *testing.T) {
+	assert := require.New(t)
+
+	for _, minify := range []bool{false, true} {
+		for files, expected := range map[[2]string]string{
+			{"slots/-1.unit.lua", "local a = 1\n\ndo -- !DU: tick([Live])\n    if a then\nend -- !DU: end\n"}: "slots/-1.unit.lua:4:14: 'end' expected near '<eof>'",
+			{"slots/-1.unit.lua", "local a = 1\nlocal = 2\n"}:                                                 "slots/-1.unit.lua:2:7: <name> expected near '='",
+			{"slots/-1.unit.lua", "do -- !DU: tick([Live])\n    print(\n        a b)\nend -- !DU: end\n"}:     "slots/-1.unit.lua:3:11: ')' expected (to close '(' at line 1) near 'b'",
+			{"lib/0.broken.lua", "function broken()\n  return 1\n"}:                                           "lib/0.broken.lua:2:1: 'end' expected (to close 'function' at line 1) near '<eof>'",
+		} {
+			dir, err := ioutil.TempDir("", "dubby")
+			assert.NoError(err)
+			defer os.RemoveAll(dir) // always cleanup the mess
+
+			assert.NoError(os.MkdirAll(path.Join(dir, "slots"), 0777))
+			assert.NoError(os.MkdirAll(path.Join(dir, "lib"), 0777))
+			assert.NoError(ioutil.WriteFile(path.Join(dir, files[0]), []byte(files[1]), 0666))
+
+			r := NewSrcReader(dir, minify)
+			err = r.Read()
+			assert.Error(err)
+			assert.Equal(expected, err.Error())
+		}
+	}
+}
+
+func TestSrcReader_StringContinuation(t *testing.T) {
+	assert := require.New(t)
+
+	// `\z` and escaped newlines continue a string on the next line, which is valid lua
+	fsys := fstest.MapFS{
+		"slots/-1.unit.lua": {Data: []byte("do -- !DU: start()\n    print('a\\z\n        b')\nend -- !DU: end\n")},
+		"lib/0.strings.lua": {Data: []byte("s = \"a\\\nb\"\n")},
+	}
+
+	for _, minify := range []bool{false, true} {
+		r := NewSrcReaderFS(fsys, minify)
+		assert.NoError(r.Read(), "minify=%v", minify)
+		assert.Equal(2, len(r.ScriptExport().Handlers))
+	}
+}
+
+func TestSrcReader_SourceMap(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "dubby")
+	assert.NoError(err)
+	defer os.RemoveAll(dir) // always cleanup the mess
+
+	assert.NoError(os.MkdirAll(path.Join(dir, "slots"), 0777))
+	assert.NoError(os.MkdirAll(path.Join(dir, "lib"), 0777))
+	assert.NoError(ioutil.WriteFile(path.Join(dir, "lib/0.a.lua"), []byte("a = 1\n"), 0666))
+	assert.NoError(ioutil.WriteFile(path.Join(dir, "lib/1.b.lua"), []byte("b = 2\nc = 3\n"), 0666))
+	assert.NoError(ioutil.WriteFile(path.Join(dir, "slots/-1.unit.lua"), []byte(
+		"local x = 1\n"+
+			"\n"+
+			"do -- !DU: tick([Live])\n"+
+			"    print(x)\n"+
+			"    error(\"boom\")\n"+
+			"end -- !DU: end\n"), 0666))
+
+	// without minifying each line is mapped
+	r := NewSrcReader(dir, false)
+	assert.NoError(r.Read())
+	sourceMap := r.Report().SourceMap
+
+	assert.Equal(3, len(sourceMap.Handlers))
+	assert.Equal(1, sourceMap.Handlers[0].Key)
+	assert.Equal("start()", sourceMap.Handlers[0].Signature)
+	assert.Equal(3, sourceMap.Handlers[2].Key)
+	assert.Equal("tick([Live])", sourceMap.Handlers[2].Signature)
+
+	// the lib markers aren't mapped to anything
+	_, ok := sourceMap.Lookup(1, 1, 0)
+	assert.False(ok)
+
+	mapping, ok := sourceMap.Lookup(1, 7, 0)
+	assert.True(ok)
+	assert.Equal("lib/1.b.lua", mapping.File)
+	assert.Equal(2, mapping.SrcLine)
+
+	mapping, ok = sourceMap.Lookup(3, 2, 3)
+	assert.True(ok)
+	assert.Equal(&Mapping{Line: 2, Column: 3, File: "slots/-1.unit.lua", SrcLine: 5, SrcColumn: 7}, mapping)
+
+	// with minifying each token is mapped
+	r = NewSrcReader(dir, true)
+	assert.NoError(r.Read())
+	sourceMap = r.Report().SourceMap
+
+	assert.Equal("print(x)error(\"boom\")\n", r.ScriptExport().Handlers[2].Code)
+
+	mapping, ok = sourceMap.Lookup(3, 1, 0)
+	assert.True(ok)
+	assert.Equal(&Mapping{Line: 1, Column: 1, File: "slots/-1.unit.lua", SrcLine: 4, SrcColumn: 5}, mapping)
+
+	mapping, ok = sourceMap.Lookup(3, 1, 9)
+	assert.True(ok)
+	assert.Equal(&Mapping{Line: 1, Column: 9, File: "slots/-1.unit.lua", SrcLine: 5, SrcColumn: 5}, mapping)
+
+	// the lines after a long string with newlines in it are mapped to the right line
+	r = NewSrcReaderFS(fstest.MapFS{
+		"slots/-1.unit.lua": {Data: []byte("do -- !DU: tick([Live])\n    local s = [[a\nb]]\n    error(s)\nend -- !DU: end\n")},
+	}, true)
+	assert.NoError(r.Read())
+
+	assert.Equal("local a=[[a\nb]]error(a)\n", r.ScriptExport().Handlers[0].Code)
+
+	mapping, ok = r.Report().SourceMap.Lookup(1, 2, 3)
+	assert.True(ok)
+	assert.Equal(&Mapping{Line: 2, Column: 4, File: "slots/-1.unit.lua", SrcLine: 4, SrcColumn: 5}, mapping)
+}
+
+func TestSrcReader_ReadFS(t *testing.T) {
+	assert := require.New(t)
+
+	actual, err := ReadFS(fstest.MapFS{
+		"lib/0.a.lua":       {Data: []byte("a = 1\n")},
+		"slots/-1.unit.lua": {Data: []byte("do -- !DU: tick([Live])\n    print(a)\nend -- !DU: end\n")},
+	})
+	assert.NoError(err)
+
+	assert.Equal(2, len(actual.Handlers))
+	assert.Equal("-- !DU[lib]: a\n\na = 1\n", actual.Handlers[0].Code)
+	assert.Equal(1, actual.Handlers[0].Key)
+	assert.Equal("print(a)", actual.Handlers[1].Code)
+	assert.Equal("tick([Live])", actual.Handlers[1].Filter.Signature)
+	assert.Equal(2, actual.Handlers[1].Key)
+
+	// the slots dir is required
+	_, err = ReadFS(fstest.MapFS{
+		"lib/0.a.lua": {Data: []byte("a = 1\n")},
+	})
+	assert.Error(err)
+}
+
+func TestSrcReader_ElementFilters(t *testing.T) {
+	assert := require.New(t)
+
+	actual, err := ReadFS(fstest.MapFS{
+		"slots/0.screen.lua": {Data: []byte("do -- !DU: mouseDown([x, y])\n    print(x)\nend -- !DU: end\n")},
+		"slots/1.radar.lua":  {Data: []byte("do -- !DU: enter([id])\n    print(id)\nend -- !DU: end\n")},
+	})
+	assert.NoError(err)
+	assert.Equal("mouseDown([x, y])", actual.Handlers[0].Filter.Signature)
+	assert.Equal("enter([id])", actual.Handlers[1].Filter.Signature)
+
+	// the number of args has to match the signature
+	_, err = ReadFS(fstest.MapFS{
+		"slots/0.screen.lua": {Data: []byte("do -- !DU: mouseDown([x])\n    print(x)\nend -- !DU: end\n")},
+	})
+	assert.Error(err)
+
+	_, err = ReadFS(fstest.MapFS{
+		"slots/0.screen.lua": {Data: []byte("do -- !DU: unknown()\nend -- !DU: end\n")},
+	})
+	assert.Error(err)
+}
+
+func TestSrcReader_SlotClasses(t *testing.T) {
+	assert := require.New(t)
+
+	fsys := fstest.MapFS{
+		"slots/0.db.lua": {Data: []byte("do -- !DU: mouseDown([x, y])\n    print(x)\nend -- !DU: end\n")},
+	}
+
+	// without a class it's not checked
+	_, err := ReadFS(fsys)
+	assert.NoError(err)
+
+	r := NewSrcReaderFS(fsys, false)
+	r.SetSlotClass(0, "DatabankUnit")
+	err = r.Read()
+	assert.Error(err)
+	assert.Contains(err.Error(), "Filter mouseDown doesn't exist for DatabankUnit")
+
+	// the class in the slot file takes precedence
+	fsys["slots/0.db.lua"] = &fstest.MapFile{Data: []byte("-- !DU[class]: ScreenUnit\nlocal a = 1\ndo -- !DU: mouseDown([x, y])\n    print(x)\nend -- !DU: end\n")}
+	r = NewSrcReaderFS(fsys, false)
+	r.SetSlotClass(0, "DatabankUnit")
+	assert.NoError(r.Read())
+	assert.Equal("-- !DU: main\nlocal a = 1", r.ScriptExport().Handlers[0].Code)
+
+	// and a databank from the slot file is checked the same way
+	_, err = ReadFS(fstest.MapFS{
+		"slots/0.db.lua": {Data: []byte("-- !DU[class]: DatabankUnit\ndo -- !DU: mouseDown([x, y])\n    print(x)\nend -- !DU: end\n")},
+	})
+	assert.Error(err)
+	assert.Contains(err.Error(), "Filter mouseDown doesn't exist for DatabankUnit")
+
+	// the default slots have their own class
+	_, err = ReadFS(fstest.MapFS{
+		"slots/-2.system.lua": {Data: []byte("do -- !DU: tick([Live])\nend -- !DU: end\n")},
+	})
+	assert.Error(err)
+	assert.Contains(err.Error(), "Filter tick doesn't exist for System")
+}
+
+func TestSrcReader_Filters(t *testing.T) {
+	assert := require.New(t)
+
+	fsys := fstest.MapFS{
+		"slots/0.db.lua": {Data: []byte("-- !DU[class]: DatabankUnit\ndo -- !DU: changed([key])\n    print(key)\nend -- !DU: end\n")},
+	}
+
+	filters := srcutils.NewFilters()
+	assert.NoError(filters.Add("DatabankUnit", "changed(key)"))
+
+	r := NewSrcReaderFS(fsys, false)
+	r.SetFilters(filters)
+	assert.NoError(r.Read())
+	assert.Equal("changed([key])", r.ScriptExport().Handlers[0].Filter.Signature)
+
+	// the filters are only added for the reader that got them
+	_, err := ReadFS(fsys)
+	assert.Error(err)
+	assert.Contains(err.Error(), "changed")
+}
+
+func TestSrcReader_SharedLibs(t *testing.T) {
+	assert := require.New(t)
+
+	r := NewSrcReaderFS(fstest.MapFS{
+		"lib/0.own.lua":     {Data: []byte("own = shared\n")},
+		"slots/-1.unit.lua": {Data: []byte("do -- !DU: tick([Live])\n    print(own)\nend -- !DU: end\n")},
+	}, false)
+	r.AddSharedLib("../shared/lib", fstest.MapFS{
+		"0.shared.lua": {Data: []byte("shared = 1\n")},
+	})
+	r.AddSharedLib("../shared/broken", fstest.MapFS{})
+
+	assert.NoError(r.Read())
+
+	handlers := r.ScriptExport().Handlers
+	assert.Equal(2, len(handlers))
+	assert.Equal("-- !DU[extlib]: shared\n\nshared = 1\n-- !DU[lib]: own\n\nown = shared\n", handlers[0].Code)
+
+	mapping, ok := r.Report().SourceMap.Lookup(1, 3, 0)
+	assert.True(ok)
+	assert.Equal("../shared/lib/0.shared.lua", mapping.File)
+
+	// errors in shared libs refer to the file by the name of the lib
+	r = NewSrcReaderFS(fstest.MapFS{
+		"slots/-1.unit.lua": {Data: []byte("")},
+	}, false)
+	r.AddSharedLib("../shared/lib", fstest.MapFS{
+		"0.shared.lua": {Data: []byte("shared = \n")},
+	})
+
+	err := r.Read()
+	assert.Error(err)
+	assert.Equal("../shared/lib/0.shared.lua:1:1: unexpected <eof>", err.Error())
+}
+
+func TestSrcReader_Modules(t *testing.T) {
+	assert := require.New(t)
+
+	r := NewSrcReaderFS(fstest.MapFS{
+		"lib/0.vec.lua":     {Data: []byte("local strings = require('utils.strings')\nreturn {}\n")},
+		"lib/1.globals.lua": {Data: []byte("globals = true\n")},
+		"slots/-1.unit.lua": {Data: []byte("do -- !DU: tick([Live])\n    local vec = require(\"vec\")\n    local vec3 = require(\"cpml.vec3\")\nend -- !DU: end\n")},
+	}, false)
+	r.AddModulePath("modules", fstest.MapFS{
+		"utils/strings.lua": {Data: []byte("return { split = function() end }\n")},
+		"unused.lua":        {Data: []byte("return {}\n")},
+	})
+
+	assert.NoError(r.Read())
+
+	handlers := r.ScriptExport().Handlers
+	assert.Equal(2, len(handlers))
+	assert.Equal("-- !DU[module]: vec\n"+
+		"package.preload[\"vec\"] = function(...)\n"+
+		"local strings = require('utils.strings')\n"+
+		"return {}\n"+
+		"end\n"+
+		"-- !DU[extmodule]: utils.strings\n"+
+		"package.preload[\"utils.strings\"] = function(...)\n"+
+		"return { split = function() end }\n"+
+		"end\n"+
+		"-- !DU[lib]: globals\n"+
+		"\n"+
+		"globals = true\n", handlers[0].Code)
+
+	// the lines of the modules are mapped to their files
+	mapping, ok := r.Report().SourceMap.Lookup(1, 8, 0)
+	assert.True(ok)
+	assert.Equal("modules/utils/strings.lua", mapping.File)
+	assert.Equal(1, mapping.SrcLine)
+
+	// syntax errors in modules are reported too
+	r = NewSrcReaderFS(fstest.MapFS{
+		"slots/-1.unit.lua": {Data: []byte("local vec = require 'vec'\n")},
+	}, false)
+	r.AddModulePath("modules", fstest.MapFS{
+		"vec.lua": {Data: []byte("return {\n")},
+	})
+
+	err := r.Read()
+	assert.Error(err)
+	assert.Equal("modules/vec.lua:1:1: unexpected <eof>", err.Error())
+}
+
+func TestRequiredModules(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal([]string{"a", "b", "c", "d.e"}, requiredModules(`
+local a = require("a")
+local b = require 'b'
+local c = require [[c]]
+local de = require("d.e")
+local f = require("f" .. suffix)
+local g = require(name)
+local h = notrequire("h")
+`))
+}
+
+func TestSrcReader_NestedLib(t *testing.T) {
+	assert := require.New(t)
+
+	actual, err := ReadFS(fstest.MapFS{
+		"lib/a.lua":                 {Data: []byte("a = 1\n")},
+		"lib/10.c.lua":              {Data: []byte("c = 1\n")},
+		"lib/2.b.lua":               {Data: []byte("b = 1\n")},
+		"lib/1.utils/1.tables.lua":  {Data: []byte("tables = 1\n")},
+		"lib/1.utils/0.strings.lua": {Data: []byte("return {}\n")},
+		"slots/-1.unit.lua":         {Data: []byte("local strings = require('utils.strings')\n")},
+	})
+	assert.NoError(err)
+
+	assert.Equal("-- !DU[module]: utils.strings\n"+
+		"package.preload[\"utils.strings\"] = function(...)\n"+
+		"return {}\n"+
+		"end\n"+
+		"-- !DU[lib]: utils/tables\n\ntables = 1\n"+
+		"-- !DU[lib]: b\n\nb = 1\n"+
+		"-- !DU[lib]: c\n\nc = 1\n"+
+		"-- !DU[lib]: a\n\na = 1\n", actual.Handlers[0].Code)
+}
+
+func TestSrcReader_SharedLibInclude(t *testing.T) {
+	assert := require.New(t)
+
+	shared := fstest.MapFS{
+		"0.vec.lua":             {Data: []byte("vec = 1\n")},
+		"1.utils/0.strings.lua": {Data: []byte("strings = 1\n")},
+		"1.utils/1.tables.lua":  {Data: []byte("tables = 1\n")},
+		"2.doors/0.hangar.lua":  {Data: []byte("hangar = 1\n")},
+		"2.doors/1.main.lua":    {Data: []byte("main = 1\n")},
+		"3.unused.lua":          {Data: []byte("unused = 1\n")},
+	}
+
+	for _, tc := range []struct {
+		include  []string
+		expected []string
+	}{
+		{nil, []string{"vec", "utils/strings", "utils/tables", "doors/hangar", "doors/main", "unused"}},
+		{[]string{"vec"}, []string{"vec"}},
+		{[]string{"vec.lua"}, []string{"vec"}},
+		{[]string{"utils"}, []string{"utils/strings", "utils/tables"}},
+		{[]string{"utils.strings"}, []string{"utils/strings"}},
+		{[]string{"doors/*", "vec"}, []string{"vec", "doors/hangar", "doors/main"}},
+	} {
+		r := NewSrcReaderFS(fstest.MapFS{
+			"slots/-1.unit.lua": {Data: []byte("")},
+		}, false)
+		r.AddSharedLib("shared", shared, tc.include...)
+		assert.NoError(r.Read())
+
+		expected := ""
+		for _, name := range tc.expected {
+			expected += "-- !DU[extlib]: " + name + "\n\n" + path.Base(name) + " = 1\n"
+		}
+		assert.Equal(expected, r.ScriptExport().Handlers[0].Code, tc.include)
+	}
+}
+
+func TestSrcReader_LibOrder(t *testing.T) {
+	assert := require.New(t)
+
+	actual, err := ReadFS(fstest.MapFS{
+		"lib/0.methods.lua": {Data: []byte("function Vec:len() return math.sqrt(self.x ^ 2) end\n")},
+		"lib/1.init.lua":    {Data: []byte("config = config or {}\norigin = Vec.new(config)\n")},
+		"lib/2.vec.lua":     {Data: []byte("Vec = {}\nfunction Vec.new() return setmetatable({}, Vec) end\n")},
+		"lib/3.config.lua":  {Data: []byte("config = {}\n")},
+		// only used inside of a function, so it's not needed when it's loaded
+		"lib/4.later.lua":   {Data: []byte("function later() return origin end\n")},
+		"slots/-1.unit.lua": {Data: []byte("")},
+	})
+	assert.NoError(err)
+
+	assert.Equal("-- !DU[lib]: vec\n\nVec = {}\nfunction Vec.new() return setmetatable({}, Vec) end\n"+
+		"-- !DU[lib]: methods\n\nfunction Vec:len() return math.sqrt(self.x ^ 2) end\n"+
+		"-- !DU[lib]: init\n\nconfig = config or {}\norigin = Vec.new(config)\n"+
+		"-- !DU[lib]: config\n\nconfig = {}\n"+
+		"-- !DU[lib]: later\n\nfunction later() return origin end\n", actual.Handlers[0].Code)
+
+	// a cycle can't be ordered, it's a warning and the files in it keep the order of their names
+	cycle := fstest.MapFS{
+		"lib/a.lua":         {Data: []byte("a = 1\nprint(c)\n")},
+		"lib/b.lua":         {Data: []byte("b = a\n")},
+		"lib/c.lua":         {Data: []byte("c = b\n")},
+		"lib/d.lua":         {Data: []byte("d = c\n")},
+		"lib/0.first.lua":   {Data: []byte("print(d)\n")},
+		"slots/-1.unit.lua": {Data: []byte("")},
+	}
+	r := NewSrcReaderFS(cycle, false)
+	assert.NoError(r.Read())
+	assert.Equal("-- !DU[lib]: a\n\na = 1\nprint(c)\n"+
+		"-- !DU[lib]: b\n\nb = a\n"+
+		"-- !DU[lib]: c\n\nc = b\n"+
+		"-- !DU[lib]: d\n\nd = c\n"+
+		"-- !DU[lib]: first\n\nprint(d)\n", r.ScriptExport().Handlers[0].Code)
+
+	assert.Equal(1, len(r.Diagnostics()))
+	assert.Equal(diagnostics.SEVERITY_WARNING, r.Diagnostics()[0].Severity)
+	assert.Equal(RULE_LIB_CYCLE, r.Diagnostics()[0].Code)
+	assert.Equal("lib/c.lua", r.Diagnostics()[0].File)
+	assert.Equal("dependency cycle in lib: lib/c.lua uses `b` from lib/b.lua, "+
+		"lib/b.lua uses `a` from lib/a.lua, lib/a.lua uses `c` from lib/c.lua, they're kept in the order of their names", r.Diagnostics()[0].Message)
+
+	// and with strict it's an error
+	r = NewSrcReaderFS(cycle, false)
+	r.SetStrict(true)
+	err = r.Read()
+	assert.Error(err)
+	assert.Contains(err.Error(), "dependency cycle in lib")
+}
+
+func TestSrcReader_Lint(t *testing.T) {
+	assert := require.New(t)
+
+	r := NewSrcReaderFS(fstest.MapFS{
+		"slots/-1.unit.lua": {Data: []byte("" +
+			"-- !DU: main\n" + // 1
+			"local a = 1\n" +
+			"do -- !DU: tick([Live])\n" + // 3
+			"    print(a)\n" +
+			"end -- !DU: end\n" +
+			"do -- !DU: tick([Live])\n" + // 6
+			"    print(b)\n" +
+			"end -- !DU: end\n" +
+			"do -- !DU: stop()\n" + // 9
+			"    -- nothing\n" +
+			"end -- !DU: end\n" +
+			"do -- !DU: nope()\n" + // 12
+			"    print(a)\n" +
+			"end -- !DU: end\n" +
+			"end -- !DU: end\n" + // 15
+			"-- !DU: tick[Live]\n" +
+			"do -- !DU: tick([a, b])\n" + // 17
+			"    print(\n" +
+			"end -- !DU: end\n" +
+			"do -- !DU: start()\n" + // 20
+			"    print(1)\n")},
+		"slots/0.screen.txt": {Data: []byte("print(1)\n")},
+		"slots/12.far.lua":   {Data: []byte("print(1)\n")},
+		"slots/x.bad.lua":    {Data: []byte("print(1)\n")},
+		"lib/a.lua":          {Data: []byte("a = \n")},
+		"lib/b.lua":          {Data: []byte("b = 1\n")},
+	}, false)
+
+	problems, err := r.Lint()
+	assert.NoError(err)
+
+	type p struct {
+		file string
+		line int
+		rule string
+	}
+
+	actual := make([]p, len(problems))
+	for k, problem := range problems {
+		actual[k] = p{problem.File, problem.Line, problem.Code}
+	}
+
+	assert.Equal([]p{
+		{"lib/a.lua", 1, RULE_SYNTAX},
+		{"slots/-1.unit.lua", 1, RULE_MAIN_MARKER},
+		{"slots/-1.unit.lua", 6, RULE_DUPLICATE_FILTER},
+		{"slots/-1.unit.lua", 9, RULE_EMPTY_HANDLER},
+		{"slots/-1.unit.lua", 12, RULE_UNKNOWN_FILTER},
+		{"slots/-1.unit.lua", 15, RULE_END_WITHOUT_START},
+		{"slots/-1.unit.lua", 16, RULE_BAD_MARKER},
+		{"slots/-1.unit.lua", 17, RULE_INVALID_FILTER},
+		{"slots/-1.unit.lua", 18, RULE_SYNTAX},
+		{"slots/-1.unit.lua", 20, RULE_UNCLOSED_HANDLER},
+		{"slots/0.screen.txt", 0, RULE_SLOT_FILE},
+		{"slots/12.far.lua", 0, RULE_SLOT_INDEX},
+		{"slots/x.bad.lua", 0, RULE_SLOT_FILE},
+	}, actual)
+
+	assert.Equal("slots/-1.unit.lua:6: duplicate filter tick([Live]), it's already in slots/-1.unit.lua:3", problems[2].Error())
+
+	assert.Equal(diagnostics.SEVERITY_ERROR, problems[0].Severity)
+	assert.Equal(diagnostics.SEVERITY_WARNING, problems[1].Severity)
+
+	// Read stops at the first error, but not at warnings
+	fsys := fstest.MapFS{
+		"slots/-1.unit.lua": {Data: []byte("-- !DU: main\ndo -- !DU: tick([Live])\n    print(1)\n")},
+	}
+	r = NewSrcReaderFS(fsys, false)
+	assert.NoError(r.Read())
+	assert.Equal(2, len(r.Diagnostics()))
+	assert.Equal("slots/-1.unit.lua:1: leftover main marker, the code outside of the handlers is the main code already", r.Diagnostics()[0].Error())
+	// the unclosed handler is closed at the end of the file
+	assert.Equal("print(1)\n", r.ScriptExport().Handlers[0].Code)
+
+	// with strict the warnings are errors
+	r = NewSrcReaderFS(fsys, false)
+	r.SetStrict(true)
+	err = r.Read()
+	assert.Error(err)
+	assert.Equal("slots/-1.unit.lua:1: leftover main marker, the code outside of the handlers is the main code already", err.Error())
+
+	// markers that aren't right are errors, since code would be lost or end up in the wrong handler otherwise
+	for _, src := range []string{
+		"local x = 1 -- !DU: todo\nprint(x)\n",
+		"do -- !DU: tick(Live\n    print(1)\nend -- !DU: end\n",
+		"end -- !DU: end\ndo -- !DU: tick([Live])\n    print(1)\nend -- !DU: end\n",
+	} {
+		_, err = ReadFS(fstest.MapFS{
+			"slots/-1.unit.lua": {Data: []byte(src)},
+		})
+		assert.Error(err, src)
+	}
+
+	_, err = ReadFS(fstest.MapFS{
+		"slots/-1.unit.lua": {Data: []byte("local x = 1 -- !DU: todo\nprint(x)\n")},
+	})
+	assert.Equal("slots/-1.unit.lua:1: bad marker: local x = 1 -- !DU: todo", err.Error())
+
+	_, err = ReadFS(fstest.MapFS{
+		"slots/-1.unit.lua": {Data: []byte("do -- !DU: nope()\nend -- !DU: end\n")},
+	})
+	assert.Error(err)
+	assert.Equal("slots/-1.unit.lua:1: unknown filter signature: nope()", err.Error())
+
+	// the name is prefixed to the paths
+	r = NewSrcReaderFS(fsys, false)
+	r.SetName("units/door")
+	assert.NoError(r.Read())
+	assert.Equal("units/door/slots/-1.unit.lua", r.Diagnostics()[0].File)
+}
+
+func TestSrcReader_LibGlobals(t *testing.T) {
+	assert := require.New(t)
+
+	r := NewSrcReaderFS(fstest.MapFS{
+		"lib/vec.lua":       {Data: []byte("local sqrt = math.sqrt\nVec = {}\nfunction Vec.len(v) return sqrt(v.x) end\nVec = setmetatable(Vec, {})\n")},
+		"lib/utils/x.lua":   {Data: []byte("function greet()\n    greeted = true\nend\n")},
+		"lib/broken.lua":    {Data: []byte("broken = (\n")},
+		"slots/-1.unit.lua": {Data: []byte("")},
+	}, false)
+	r.SetName("src")
+
+	globals, err := r.LibGlobals()
+	assert.NoError(err)
+	assert.Equal([]*GlobalDefinition{
+		{Name: "greet", File: "src/lib/utils/x.lua", Line: 1, Column: 10},
+		{Name: "greeted", File: "src/lib/utils/x.lua", Line: 2, Column: 5},
+		{Name: "Vec", File: "src/lib/vec.lua", Line: 2, Column: 1},
+	}, globals)
+
+	slotKey, name, ok := r.SlotFile("slots/0.screen.lua")
+	assert.True(ok)
+	assert.Equal(0, slotKey)
+	assert.Equal("screen", name)
+
+	slotKey, _, ok = r.SlotFile("slots/-1.unit.lua")
+	assert.True(ok)
+	assert.Equal(-1, slotKey)
+
+	_, _, ok = r.SlotFile("lib/0.screen.lua")
+	assert.False(ok)
+	_, _, ok = r.SlotFile("slots/screen.lua")
+	assert.False(ok)
+}
+
+func TestSrcReader_HandlerKeys(t *testing.T) {
+	assert := require.New(t)
+
+	newHandler := func(key int, slotKey int, signature string, code string) *dustructs.Handler {
+		return &dustructs.Handler{
+			Code:   code,
+			Filter: &dustructs.Filter{Args: []dustructs.Arg{}, Signature: signature, SlotKey: slotKey},
+			Key:    key,
+		}
+	}
+
+	export := dustructs.NewScriptExport()
+	export.Handlers = []*dustructs.Handler{
+		newHandler(0, dustructs.SLOT_IDX_SYSTEM, "update()", "update()"),
+		newHandler(4, dustructs.SLOT_IDX_UNIT, "start()", "first()"),
+		newHandler(5, dustructs.SLOT_IDX_UNIT, "start()", "second()"),
+		newHandler(9, dustructs.SLOT_IDX_UNIT, "stop()", "stop()"),
+	}
+
+	fsys := srcwriter.NewMemFS()
+	err := srcwriter.NewSrcWriter(export).WriteToFS(fsys)
+	assert.NoError(err)
+
+	keys := func(e *dustructs.ScriptExport) []string {
+		res := make([]string, len(e.Handlers))
+		for k, handler := range e.Handlers {
+			res[k] = fmt.Sprintf("%d %s", handler.Key, handler.Code)
+		}
+		return res
+	}
+
+	// the handlers get their original keys back, in that order
+	actual, err := ReadFS(fsys)
+	assert.NoError(err)
+	assert.Equal([]string{"0 update()", "4 first()", "5 second()", "9 stop()"}, keys(actual))
+
+	// unless they're renumbered
+	r := NewSrcReaderFS(fsys, false)
+	r.SetRenumber(true)
+	err = r.Read()
+	assert.NoError(err)
+	assert.Equal([]string{"1 first()", "2 second()", "3 stop()", "4 update()"}, keys(r.ScriptExport()))
+
+	// a handler that's added gets a new key when its key is taken by a handler that got its original key back
+	unit, err := fs.ReadFile(fsys, "slots/-1.unit.lua")
+	assert.NoError(err)
+	assert.NoError(fsys.WriteFile("slots/-1.unit.lua", append(unit, []byte("do -- !DU: tick([Live])\n    tick()\nend -- !DU: end\n")...), 0666))
+	actual, err = ReadFS(fsys)
+	assert.NoError(err)
+	assert.Equal([]string{"0 update()", "4 first()", "5 second()", "9 stop()", "10 tick()"}, keys(actual))
+}
+
+func TestSrcReader_AutoConfRoundTrip(t *testing.T) {
+	assert := require.New(t)
+
+	input := `name: doors
+slots:
+  screen:
+    class: ScreenUnit
+    select: all
+  door:
+    class: DoorUnit
+  switch:
+    select: manual
+handlers:
+  screen:
+    mouseDown:
+      args: ['*', '*']
+      lua: render()
+`
+
+	autoConf := &dustructs.AutoConf{}
+	err := yaml.Unmarshal([]byte(input), autoConf)
+	assert.NoError(err)
+
+	export, err := autoConf.ToScriptExport()
+	assert.NoError(err)
+
+	fsys := srcwriter.NewMemFS()
+	err = srcwriter.NewSrcWriter(export).WriteToFS(fsys)
+	assert.NoError(err)
+
+	// the class goes in the slot file, also for slots without handlers
+	screen, err := fs.ReadFile(fsys, "slots/0.screen.lua")
+	assert.NoError(err)
+	assert.Equal("-- !DU[class]: ScreenUnit\ndo -- !DU: mouseDown([*, *])\n    render()\nend -- !DU: end\n", string(screen))
+	door, err := fs.ReadFile(fsys, "slots/1.door.lua")
+	assert.NoError(err)
+	assert.Equal("-- !DU[class]: DoorUnit\n", string(door))
+
+	actual, err := ReadFS(fsys)
+	assert.NoError(err)
+
+	res, err := dustructs.NewAutoConfFromScriptExport(actual.Name, actual)
+	assert.NoError(err)
+
+	output, err := yaml.Marshal(res)
+	assert.NoError(err)
+	assert.Equal(input, string(output))
+}
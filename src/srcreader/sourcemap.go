@@ -0,0 +1,127 @@
+package srcreader
+
+import (
+	"github.com/rubensayshi/dubby/src/luamin"
+)
+
+// SourceMap maps the code of the compiled handlers back to the source files,
+// so that errors in the game (eg; `[string "..."]:37`) can be traced back to where they came from.
+type SourceMap struct {
+	Handlers []*HandlerSourceMap `json:"handlers"`
+}
+
+type HandlerSourceMap struct {
+	Key       int        `json:"key"`
+	SlotKey   int        `json:"slotKey"`
+	Signature string     `json:"signature"`
+	Mappings  []*Mapping `json:"mappings"`
+}
+
+// Mapping maps a position (1-based) in the compiled code of a handler to a position in a source file,
+// without minifying there's a mapping for each line, with minifying there's a mapping for each token.
+type Mapping struct {
+	Line      int    `json:"line"`
+	Column    int    `json:"column"`
+	File      string `json:"file"`
+	SrcLine   int    `json:"srcLine"`
+	SrcColumn int    `json:"srcColumn"`
+}
+
+func NewSourceMap() *SourceMap {
+	return &SourceMap{
+		Handlers: make([]*HandlerSourceMap, 0),
+	}
+}
+
+// Handler returns the source map of the handler with the specified key
+func (m *SourceMap) Handler(key int) *HandlerSourceMap {
+	for _, handler := range m.Handlers {
+		if handler.Key == key {
+			return handler
+		}
+	}
+
+	return nil
+}
+
+// Lookup finds where a line and column in the compiled code of the handler came from,
+// the column is optional (0) since errors in the game only mention the line.
+func (m *SourceMap) Lookup(key int, line int, column int) (*Mapping, bool) {
+	handler := m.Handler(key)
+	if handler == nil {
+		return nil, false
+	}
+
+	return handler.Lookup(line, column)
+}
+
+func (m *HandlerSourceMap) Lookup(line int, column int) (*Mapping, bool) {
+	var found *Mapping
+	for _, mapping := range m.Mappings {
+		if mapping.Line != line {
+			continue
+		}
+
+		// take the last mapping before the column, or the first one on the line if there's none
+		if found == nil || mapping.Column <= column {
+			found = mapping
+		}
+	}
+
+	if found == nil {
+		return nil, false
+	}
+
+	res := *found
+	if column > found.Column {
+		res.SrcColumn += column - found.Column
+		res.Column = column
+	}
+
+	return &res, true
+}
+
+// lineMappings maps each line of the compiled code to its SourceLine
+func lineMappings(lines []SourceLine) []*Mapping {
+	mappings := make([]*Mapping, 0, len(lines))
+	for k, line := range lines {
+		if line.File == "" {
+			continue
+		}
+
+		mappings = append(mappings, &Mapping{
+			Line:      k + 1,
+			Column:    1,
+			File:      line.File,
+			SrcLine:   line.Line,
+			SrcColumn: 1 + line.Indent,
+		})
+	}
+
+	return mappings
+}
+
+// minifiedMappings maps each token of the minified code to its SourceLine
+func minifiedMappings(positions []luamin.Position, lines []SourceLine) []*Mapping {
+	mappings := make([]*Mapping, 0, len(positions))
+	for _, position := range positions {
+		if position.OrigLine < 1 || position.OrigLine > len(lines) {
+			continue
+		}
+
+		line := lines[position.OrigLine-1]
+		if line.File == "" {
+			continue
+		}
+
+		mappings = append(mappings, &Mapping{
+			Line:      position.Line,
+			Column:    position.Column,
+			File:      line.File,
+			SrcLine:   line.Line,
+			SrcColumn: position.OrigColumn + line.Indent,
+		})
+	}
+
+	return mappings
+}
@@ -0,0 +1,237 @@
+package srcutils
+
+import (
+	_ "embed"
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/rubensayshi/dubby/src/diagnostics"
+	"github.com/rubensayshi/dubby/src/dustructs"
+	"gopkg.in/yaml.v2"
+)
+
+//go:embed filters.yaml
+var filtersYaml []byte
+
+// ClassFilterSignatures are the filter signatures of each element class in the catalogue, by the name of the filter
+var ClassFilterSignatures map[string]map[string]string
+
+// FilterSignatures are the filter signatures of all element classes in the catalogue, by the name of the filter
+var FilterSignatures map[string]string
+
+func init() {
+	var err error
+	ClassFilterSignatures, FilterSignatures, err = loadFilters(filtersYaml)
+	if err != nil {
+		panic(fmt.Sprintf("invalid filters.yaml: %s", err))
+	}
+}
+
+// loadFilters loads the catalogue of filters, when classes have a filter with the same name the first class wins.
+// Classes without any filters are kept as well, no filter is valid for them.
+func loadFilters(data []byte) (map[string]map[string]string, map[string]string, error) {
+	var classes yaml.MapSlice
+	err := yaml.Unmarshal(data, &classes)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	classFilters := make(map[string]map[string]string, len(classes))
+	filters := make(map[string]string)
+	for _, class := range classes {
+		className, ok := class.Key.(string)
+		if !ok {
+			return nil, nil, errors.Errorf("invalid class: %v", class.Key)
+		}
+
+		signatures, ok := class.Value.([]interface{})
+		if !ok && class.Value != nil {
+			return nil, nil, errors.Errorf("invalid filters for class [%s]", className)
+		}
+		classFilters[className] = make(map[string]string)
+
+		for _, signature := range signatures {
+			signature, ok := signature.(string)
+			if !ok {
+				return nil, nil, errors.Errorf("invalid filter for class [%s]: %v", className, signature)
+			}
+
+			res := sigRegex.FindStringSubmatch(signature)
+			if res == nil {
+				return nil, nil, errors.Errorf("invalid filter for class [%s]: %s", className, signature)
+			}
+
+			classFilters[className][res[1]] = signature
+			if _, ok := filters[res[1]]; !ok {
+				filters[res[1]] = signature
+			}
+		}
+	}
+
+	return classFilters, filters, nil
+}
+
+// Filters is the catalogue of filters together with the filters that are added to it (eg; from the dubby.yaml),
+// the catalogue itself isn't changed so each run (or reader) can have its own filters.
+type Filters struct {
+	signatures      map[string]string            // of all classes, by the name of the filter
+	classSignatures map[string]map[string]string // of each class, only of the classes that got a filter added
+	anyClass        map[string]string            // added without a class, they're allowed for every class
+}
+
+func NewFilters() *Filters {
+	return &Filters{
+		signatures:      make(map[string]string),
+		classSignatures: make(map[string]map[string]string),
+		anyClass:        make(map[string]string),
+	}
+}
+
+// Add adds a filter signature (eg; `opened(id)`).
+// Without a class it's allowed for every class and it replaces a filter with the same name,
+// with a class it replaces the filter of that class only.
+// A class that isn't in the catalogue starts out with the filters of all classes, same as when it's not checked.
+// Adding the same filters again doesn't change anything, so it doesn't matter how often it's called.
+func (f *Filters) Add(class string, signature string) error {
+	res := sigRegex.FindStringSubmatch(signature)
+	if res == nil {
+		return errors.Errorf("Signature does not match expected pattern: %s", signature)
+	}
+
+	if class == "" {
+		f.signatures[res[1]] = signature
+		f.anyClass[res[1]] = signature
+		return nil
+	}
+
+	if _, ok := f.Signature(res[1]); !ok {
+		f.signatures[res[1]] = signature
+	}
+
+	if f.classSignatures[class] == nil {
+		catalogue, ok := ClassFilterSignatures[class]
+		if !ok {
+			catalogue = FilterSignatures
+		}
+
+		f.classSignatures[class] = make(map[string]string, len(catalogue)+1)
+		for fn, signature := range catalogue {
+			f.classSignatures[class][fn] = signature
+		}
+	}
+	f.classSignatures[class][res[1]] = signature
+
+	return nil
+}
+
+// Signature looks up the signature of a filter of any class, ok is false when the filter doesn't exist at all
+func (f *Filters) Signature(fn string) (string, bool) {
+	if signature, ok := f.signatures[fn]; ok {
+		return signature, true
+	}
+
+	signature, ok := FilterSignatures[fn]
+	return signature, ok
+}
+
+// Names are the names of the filters of all classes
+func (f *Filters) Names() []string {
+	res := make([]string, 0, len(FilterSignatures)+len(f.signatures))
+	for fn := range FilterSignatures {
+		res = append(res, fn)
+	}
+	for fn := range f.signatures {
+		if _, ok := FilterSignatures[fn]; !ok {
+			res = append(res, fn)
+		}
+	}
+
+	sort.Strings(res)
+
+	return res
+}
+
+// ClassSignatures are the filter signatures of an element class by their name, ok is false when the class isn't known
+func (f *Filters) ClassSignatures(class string) (map[string]string, bool) {
+	filters, ok := f.classSignatures[class]
+	if !ok {
+		filters, ok = ClassFilterSignatures[class]
+	}
+	if !ok {
+		return nil, false
+	}
+
+	res := make(map[string]string, len(filters)+len(f.anyClass))
+	for fn, signature := range filters {
+		res[fn] = signature
+	}
+	for fn, signature := range f.anyClass {
+		res[fn] = signature
+	}
+
+	return res, true
+}
+
+// ClassSignature looks up the signature of a filter for an element class,
+// ok is false when the filter doesn't exist for the class.
+// Classes which aren't known can't be checked, then any filter is ok.
+func (f *Filters) ClassSignature(class string, fn string) (string, bool) {
+	if signature, ok := f.anyClass[fn]; ok {
+		return signature, true
+	}
+
+	filters, ok := f.classSignatures[class]
+	if !ok {
+		filters, ok = ClassFilterSignatures[class]
+	}
+	if !ok {
+		return f.Signature(fn)
+	}
+
+	signature, ok := filters[fn]
+	return signature, ok
+}
+
+// MakeClassHeader is the same as MakeHeader, but the filter has to exist for the element class
+// and the number of args is checked against its signature for that class.
+func (f *Filters) MakeClassHeader(class string, fn string, args []dustructs.Arg) (string, error) {
+	signature, ok := f.ClassSignature(class, fn)
+	if !ok {
+		if _, known := f.ClassSignatures(class); known {
+			return "", errors.Errorf("Filter %s doesn't exist for %s", fn, class)
+		}
+
+		return "", errors.Errorf("Unknown filter: %s", fn)
+	}
+
+	return MakeHeader(signature, args)
+}
+
+// CODE_UNKNOWN_FILTER is the code of the warning for handlers with a filter that doesn't exist
+const CODE_UNKNOWN_FILTER = "unknown-filter"
+
+// Check warns about handlers with a filter that doesn't exist, for the importers of the export (from file),
+// since writing them to a source directory and exporting that again would fail.
+func (f *Filters) Check(c *diagnostics.Collector, file string, export *dustructs.ScriptExport) error {
+	for _, handler := range export.Handlers {
+		fn, _, err := ParseHeader(handler.Filter.Signature)
+		if err != nil {
+			continue
+		}
+		if _, ok := f.Signature(fn); ok {
+			continue
+		}
+
+		err = c.Warn(&diagnostics.Diagnostic{
+			File:    file,
+			Code:    CODE_UNKNOWN_FILTER,
+			Message: fmt.Sprintf("handler [%d] has an unknown filter %s, add it with `filters` in dubby.yaml or with --filter", handler.Key, handler.Filter.Signature),
+		})
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
@@ -9,34 +9,23 @@ import (
 	"github.com/rubensayshi/dubby/src/dustructs"
 )
 
-var FilterSignatures = map[string]string{
-	"start":       "start()",
-	"stop":        "stop()",
-	"flush":       "flush()",
-	"update":      "update()",
-	"tick":        "tick(timerId)",
-	"actionStart": "actionStart(action)",
-	"actionStop":  "actionStop(action)",
-	"actionLoop":  "actionLoop(action)",
-}
-
 var sigRegex = regexp.MustCompile(`^ *(?P<fn>[a-zA-Z0-9_-]+)\(\[?(?P<args>.*?)\]?\) *$`)
 
+// MakeHeader makes the header for a filter, eg; `tick([Live])` for `tick(timerId)` with `Live` as arg.
+// Instead of the signature it can be given just the name of a filter, then the signature from the catalogue is used.
 func MakeHeader(signature string, args []dustructs.Arg) (string, error) {
+	if known, ok := FilterSignatures[signature]; ok {
+		signature = known
+	}
+
 	res := sigRegex.FindStringSubmatch(signature)
+	if res == nil {
+		return "", errors.Errorf("Signature does not match expected pattern: %s", signature)
+	}
 
 	// parse the args from the signature
 	fn := res[1]
-	argstr := res[2]
-	var fnargs []string
-	if strings.TrimSpace(argstr) == "" {
-		fnargs = []string{}
-	} else {
-		fnargs = strings.Split(res[2], ",")
-		for k, v := range fnargs {
-			fnargs[k] = strings.TrimSpace(v)
-		}
-	}
+	fnargs := splitArgs(res[2])
 
 	if len(fnargs) != len(args) {
 		return "", errors.Errorf("Wrong number of args, expected %d: %s", len(fnargs), signature)
@@ -62,16 +51,7 @@ func ParseHeader(header string) (string, []dustructs.Arg, error) {
 
 	// parse the args from the signature
 	fn := res[1]
-	argstr := res[2]
-	var fnargs []string
-	if strings.TrimSpace(argstr) == "" {
-		fnargs = []string{}
-	} else {
-		fnargs = strings.Split(res[2], ",")
-		for k, v := range fnargs {
-			fnargs[k] = strings.TrimSpace(v)
-		}
-	}
+	fnargs := splitArgs(res[2])
 
 	args := make([]dustructs.Arg, len(fnargs))
 
@@ -85,3 +65,16 @@ func ParseHeader(header string) (string, []dustructs.Arg, error) {
 
 	return fn, args, nil
 }
+
+func splitArgs(argstr string) []string {
+	if strings.TrimSpace(argstr) == "" {
+		return []string{}
+	}
+
+	args := strings.Split(argstr, ",")
+	for k, v := range args {
+		args[k] = strings.TrimSpace(v)
+	}
+
+	return args
+}
@@ -11,13 +11,13 @@ func TestMakeHeader(t *testing.T) {
 	assert := require.New(t)
 
 	{
-		res, err := MakeHeader("tick(timerId)", []dustructs.Arg{{"Live"}})
+		res, err := MakeHeader("tick(timerId)", []dustructs.Arg{{Value: "Live"}})
 		assert.NoError(err)
 		assert.Equal("tick([Live])", res)
 	}
 
 	{
-		res, err := MakeHeader("tick(timerId, cookie)", []dustructs.Arg{{"Live"}, {"and Let Die"}})
+		res, err := MakeHeader("tick(timerId, cookie)", []dustructs.Arg{{Value: "Live"}, {Value: "and Let Die"}})
 		assert.NoError(err)
 		assert.Equal("tick([Live, and Let Die])", res) // @TODO: how is this sane?
 	}
@@ -70,3 +70,124 @@ func TestParseHeader(t *testing.T) {
 		//assert.Equal("and Let Die", args[1].Value)
 	}
 }
+
+func TestFilterCatalogue(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal("tick(timerId)", FilterSignatures["tick"])
+	assert.Equal("mouseDown(x,y)", FilterSignatures["mouseDown"])
+	assert.Equal("receive(channel,message)", ClassFilterSignatures["ReceiverUnit"]["receive"])
+	assert.Equal("enter(id)", ClassFilterSignatures["RadarPVPUnit"]["enter"])
+	assert.NotNil(ClassFilterSignatures["DatabankUnit"])
+	assert.Equal(0, len(ClassFilterSignatures["DatabankUnit"]))
+	assert.Equal("toggled(active)", ClassFilterSignatures["TransponderUnit"]["toggled"])
+	assert.Equal("", ClassFilterSignatures["ScreenUnit"]["tick"])
+
+	// the signature is looked up in the catalogue when only the name is given
+	res, err := MakeHeader("mouseDown", []dustructs.Arg{{Value: "x"}, {Value: "y"}})
+	assert.NoError(err)
+	assert.Equal("mouseDown([x, y])", res)
+
+	_, err = MakeHeader("mouseDown", []dustructs.Arg{{Value: "x"}})
+	assert.Error(err)
+
+	for _, data := range []string{
+		"ScreenUnit: mouseDown(x,y)",
+		"ScreenUnit: [mouseDown]",
+		"ScreenUnit: [[mouseDown(x,y)]]",
+	} {
+		_, _, err := loadFilters([]byte(data))
+		assert.Error(err, data)
+	}
+}
+
+func TestFiltersAdd(t *testing.T) {
+	assert := require.New(t)
+
+	filters := NewFilters()
+	assert.NoError(filters.Add("", "onTest(a)"))
+	assert.NoError(filters.Add("TestUnit", "opened(id,who)"))
+	assert.Error(filters.Add("", "onTest"))
+
+	signature, ok := filters.Signature("onTest")
+	assert.True(ok)
+	assert.Equal("onTest(a)", signature)
+	signature, ok = filters.ClassSignature("TestUnit", "opened")
+	assert.True(ok)
+	assert.Equal("opened(id,who)", signature)
+	assert.Contains(filters.Names(), "opened")
+
+	res, err := filters.MakeClassHeader("", "opened", []dustructs.Arg{{Value: "1"}, {Value: "bob"}})
+	assert.NoError(err)
+	assert.Equal("opened([1, bob])", res)
+
+	// a class that's new to the catalogue still has the other filters
+	res, err = filters.MakeClassHeader("TestUnit", "mouseDown", []dustructs.Arg{{Value: "x"}, {Value: "y"}})
+	assert.NoError(err)
+	assert.Equal("mouseDown([x, y])", res)
+
+	// a filter for a class doesn't change the filter for the other classes
+	assert.NoError(filters.Add("OtherTestUnit", "tick(timerId,delay)"))
+	signature, _ = filters.Signature("tick")
+	assert.Equal("tick(timerId)", signature)
+	_, err = filters.MakeClassHeader("ControlUnit", "tick", []dustructs.Arg{{Value: "Live"}})
+	assert.NoError(err)
+	_, err = filters.MakeClassHeader("OtherTestUnit", "tick", []dustructs.Arg{{Value: "Live"}, {Value: "1"}})
+	assert.NoError(err)
+
+	// and adding the same filters again doesn't change anything either
+	assert.NoError(filters.Add("TestUnit", "opened(id,who)"))
+	assert.NoError(filters.Add("OtherTestUnit", "tick(timerId,delay)"))
+	signature, _ = filters.Signature("tick")
+	assert.Equal("tick(timerId)", signature)
+	signature, _ = filters.ClassSignature("TestUnit", "opened")
+	assert.Equal("opened(id,who)", signature)
+
+	// the catalogue and the other filter sets don't get the added filters
+	_, ok = FilterSignatures["onTest"]
+	assert.False(ok)
+	_, ok = ClassFilterSignatures["TestUnit"]
+	assert.False(ok)
+	_, ok = NewFilters().Signature("opened")
+	assert.False(ok)
+}
+
+func TestFiltersMakeClassHeader(t *testing.T) {
+	assert := require.New(t)
+
+	filters := NewFilters()
+
+	res, err := filters.MakeClassHeader("ScreenUnit", "mouseDown", []dustructs.Arg{{Value: "x"}, {Value: "y"}})
+	assert.NoError(err)
+	assert.Equal("mouseDown([x, y])", res)
+
+	// the args have to match the signature of the class
+	_, err = filters.MakeClassHeader("ScreenUnit", "mouseDown", []dustructs.Arg{{Value: "x"}})
+	assert.Error(err)
+
+	// the filter has to exist for the class
+	_, err = filters.MakeClassHeader("RadarUnit", "mouseDown", []dustructs.Arg{{Value: "x"}, {Value: "y"}})
+	assert.Error(err)
+	assert.Equal("Filter mouseDown doesn't exist for RadarUnit", err.Error())
+
+	// classes which aren't in the catalogue can't be checked
+	res, err = filters.MakeClassHeader("SomeNewUnit", "enter", []dustructs.Arg{{Value: "1"}})
+	assert.NoError(err)
+	assert.Equal("enter([1])", res)
+
+	// classes which are listed without any filters have no valid filters at all
+	_, err = filters.MakeClassHeader("DatabankUnit", "mouseDown", []dustructs.Arg{{Value: "x"}, {Value: "y"}})
+	assert.Error(err)
+	assert.Equal("Filter mouseDown doesn't exist for DatabankUnit", err.Error())
+
+	_, err = filters.MakeClassHeader("SomeNewUnit", "unknown", []dustructs.Arg{})
+	assert.Error(err)
+
+	// filters added without a class are ok for every class
+	assert.NoError(filters.Add("", "onAnything()"))
+	_, err = filters.MakeClassHeader("RadarUnit", "onAnything", []dustructs.Arg{})
+	assert.NoError(err)
+	signatures, ok := filters.ClassSignatures("RadarUnit")
+	assert.True(ok)
+	assert.Equal("onAnything()", signatures["onAnything"])
+}
@@ -0,0 +1,158 @@
+package srcwriter
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// WriteFS is a filesystem the source files can be written to, paths are slash separated and relative to its root
+type WriteFS interface {
+	MkdirAll(name string, perm fs.FileMode) error
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+}
+
+// DirFS writes to a directory on disk
+type DirFS string
+
+func (d DirFS) MkdirAll(name string, perm fs.FileMode) error {
+	return os.MkdirAll(filepath.Join(string(d), filepath.FromSlash(name)), perm)
+}
+
+func (d DirFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return ioutil.WriteFile(filepath.Join(string(d), filepath.FromSlash(name)), data, perm)
+}
+
+// MemFS is an in-memory filesystem, which can also be read from (eg; by the srcreader) since it's a fs.FS.
+// The parent directories of a file exist without creating them, the same as with MkdirAll.
+type MemFS struct {
+	files map[string]*memFileInfo
+}
+
+func NewMemFS() *MemFS {
+	return &MemFS{
+		files: make(map[string]*memFileInfo),
+	}
+}
+
+func (m *MemFS) MkdirAll(name string, perm fs.FileMode) error {
+	name = path.Clean(name)
+	if name != "." {
+		m.files[name] = &memFileInfo{name: path.Base(name), mode: fs.ModeDir | perm}
+	}
+	return nil
+}
+
+func (m *MemFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	name = path.Clean(name)
+	m.files[name] = &memFileInfo{name: path.Base(name), data: data, mode: perm}
+	return nil
+}
+
+func (m *MemFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if info, ok := m.files[name]; ok && !info.IsDir() {
+		return &memFile{info: info, r: bytes.NewReader(info.data)}, nil
+	}
+
+	// a directory is there when it's created, or when there's anything in it
+	prefix := name + "/"
+	if name == "." {
+		prefix = ""
+	}
+
+	info, ok := m.files[name]
+	if !ok {
+		info = &memFileInfo{name: path.Base(name), mode: fs.ModeDir | 0777}
+	}
+
+	children := make(map[string]*memFileInfo)
+	for filePath, fileInfo := range m.files {
+		if !strings.HasPrefix(filePath, prefix) {
+			continue
+		}
+
+		ok = true
+		childName := strings.SplitN(strings.TrimPrefix(filePath, prefix), "/", 2)[0]
+		if childName == strings.TrimPrefix(filePath, prefix) {
+			children[childName] = fileInfo
+		} else if _, exists := children[childName]; !exists {
+			children[childName] = &memFileInfo{name: childName, mode: fs.ModeDir | 0777}
+		}
+	}
+	if !ok && name != "." {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	entries := make([]fs.DirEntry, 0, len(children))
+	for _, child := range children {
+		entries = append(entries, child)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	return &memDir{info: info, entries: entries}, nil
+}
+
+// memFileInfo is both the fs.FileInfo and the fs.DirEntry of a file or directory of a MemFS
+type memFileInfo struct {
+	name string
+	data []byte
+	mode fs.FileMode
+}
+
+func (i *memFileInfo) Name() string               { return i.name }
+func (i *memFileInfo) Size() int64                { return int64(len(i.data)) }
+func (i *memFileInfo) Mode() fs.FileMode          { return i.mode }
+func (i *memFileInfo) ModTime() time.Time         { return time.Time{} }
+func (i *memFileInfo) IsDir() bool                { return i.mode.IsDir() }
+func (i *memFileInfo) Sys() interface{}           { return nil }
+func (i *memFileInfo) Type() fs.FileMode          { return i.mode.Type() }
+func (i *memFileInfo) Info() (fs.FileInfo, error) { return i, nil }
+
+type memFile struct {
+	info *memFileInfo
+	r    *bytes.Reader
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memFile) Read(b []byte) (int, error) { return f.r.Read(b) }
+func (f *memFile) Close() error               { return nil }
+
+type memDir struct {
+	info    *memFileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *memDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *memDir) Close() error               { return nil }
+
+func (d *memDir) Read(b []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+func (d *memDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n > 0 && len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && n < len(rest) {
+		rest = rest[:n]
+	}
+
+	d.offset += len(rest)
+
+	return rest, nil
+}
@@ -0,0 +1,40 @@
+package srcwriter
+
+import (
+	"io/fs"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemFS(t *testing.T) {
+	assert := require.New(t)
+
+	fsys := NewMemFS()
+	assert.NoError(fsys.MkdirAll("lib/empty", 0777))
+	assert.NoError(fsys.WriteFile("slots/-1.unit.lua", []byte("print(1)\n"), 0666))
+	assert.NoError(fsys.WriteFile("lib/utils/strings.lua", []byte("s = 1\n"), 0666))
+	assert.NoError(fsys.WriteFile("./metadata.json", []byte("{}\n"), 0666))
+
+	// it behaves the same as any other fs.FS
+	assert.NoError(fstest.TestFS(fsys, "slots/-1.unit.lua", "lib/utils/strings.lua", "lib/empty", "metadata.json"))
+
+	// the parent directories of a file are there without creating them
+	entries, err := fs.ReadDir(fsys, "lib")
+	assert.NoError(err)
+	assert.Equal(2, len(entries))
+	assert.Equal("empty", entries[0].Name())
+	assert.Equal("utils", entries[1].Name())
+	assert.True(entries[1].IsDir())
+
+	// writing a file again replaces it
+	assert.NoError(fsys.WriteFile("slots/-1.unit.lua", []byte("print(2)\n"), 0666))
+	buf, err := fs.ReadFile(fsys, "slots/-1.unit.lua")
+	assert.NoError(err)
+	assert.Equal("print(2)\n", string(buf))
+
+	_, err = fs.Stat(fsys, "slots/0.screen.lua")
+	assert.True(os.IsNotExist(err))
+}
@@ -1,33 +1,78 @@
 package srcwriter
 
 import (
+	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/pkg/errors"
+	"github.com/rubensayshi/dubby/src/diagnostics"
 	"github.com/rubensayshi/dubby/src/dustructs"
 	"github.com/rubensayshi/dubby/src/srcutils"
 )
 
-var libHeaderRegex = regexp.MustCompile(`-- !DU\[lib]: (.*?)\n\n?`)
+var libHeaderRegex = regexp.MustCompile(`-- !DU\[(ext)?(lib|module)]: (.*?)\n\n?`)
+var libHandlerRegex = regexp.MustCompile(`^-- !DU\[(ext)?(lib|module)]: `)
+var markerRegex = regexp.MustCompile(`(?m)^\s*(do|end)?\s*-- ?!DU:`)
+var modulePreloadRegex = regexp.MustCompile(`^package\.preload\[".*?"] = function\(\.\.\.\)\n((?s).*)end\n$`)
+
+const (
+	CODE_UNKNOWN_SLOT   = "unknown-slot"
+	CODE_MARKER_IN_CODE = "marker-in-code"
+)
 
 type SrcWriter struct {
 	scriptExport dustructs.ScriptExport
+	slotsDir     string
+	libDir       string
+	indent       string
+	lineEnding   string
+	projectFiles []string
+
+	diagnostics.Collector
 }
 
 func NewSrcWriter(scriptExport *dustructs.ScriptExport) *SrcWriter {
 	return &SrcWriter{
 		scriptExport: *scriptExport,
+		slotsDir:     "slots",
+		libDir:       "lib",
+		indent:       "    ",
+		lineEnding:   "\n",
 	}
 }
 
+// SetLayout sets the names of the slots and lib directories
+func (i *SrcWriter) SetLayout(slotsDir string, libDir string) {
+	i.slotsDir = slotsDir
+	i.libDir = libDir
+}
+
+// SetIndent sets the indenting used for the code inside handlers
+func (i *SrcWriter) SetIndent(indent string) {
+	i.indent = indent
+}
+
+// SetLineEnding sets the line ending used in the lua files, `\n` or `\r\n`
+func (i *SrcWriter) SetLineEnding(lineEnding string) {
+	i.lineEnding = lineEnding
+}
+
+// SetProjectFiles sets the files (eg; dubby.yaml or .git) that are only found in the root of a project,
+// WriteTo refuses to write to a directory with one of them in it, since that's never meant to be a source directory.
+func (i *SrcWriter) SetProjectFiles(projectFiles []string) {
+	i.projectFiles = projectFiles
+}
+
 type SlotSrc struct {
 	key      int
 	name     string
+	class    string
 	mainCode []string
 	handlers []*SlotSrcHandler
 }
@@ -37,28 +82,54 @@ type SlotSrcHandler struct {
 	sig  string
 }
 
+// WriteTo writes the source files to outputDir, the slots and lib directories and metadata file that are already
+// in outputDir are removed, anything else is left alone.
+// It refuses to write to the root of a project, see SetProjectFiles.
 func (i *SrcWriter) WriteTo(outputDir string) error {
-	err := os.RemoveAll(outputDir)
-	if err != nil {
-		return errors.WithStack(err)
+	for _, projectFile := range i.projectFiles {
+		_, err := os.Stat(filepath.Join(outputDir, projectFile))
+		if err == nil {
+			return errors.Errorf("refusing to write to %s, it has a %s so it's not a source directory", outputDir, projectFile)
+		} else if !os.IsNotExist(err) {
+			return errors.WithStack(err)
+		}
+	}
+
+	for _, dir := range []string{i.slotsDir, i.libDir} {
+		dir = path.Clean(dir)
+		if dir == "." || dir == ".." || strings.HasPrefix(dir, "../") || path.IsAbs(dir) {
+			return errors.Errorf("refusing to write to %s, the %s directory isn't inside of it", outputDir, dir)
+		}
+	}
+
+	for _, owned := range []string{i.slotsDir, i.libDir, dustructs.METADATA_FILE} {
+		err := os.RemoveAll(filepath.Join(outputDir, filepath.FromSlash(owned)))
+		if err != nil {
+			return errors.WithStack(err)
+		}
 	}
 
-	err = os.MkdirAll(outputDir, 0777)
+	err := os.MkdirAll(outputDir, 0777)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	err = os.MkdirAll(path.Join(outputDir, "slots"), 0777)
+	return i.WriteToFS(DirFS(outputDir))
+}
+
+// WriteToFS writes the source files to the root of fsys
+func (i *SrcWriter) WriteToFS(fsys WriteFS) error {
+	err := fsys.MkdirAll(i.slotsDir, 0777)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	err = os.MkdirAll(path.Join(outputDir, "lib"), 0777)
+	err = fsys.MkdirAll(i.libDir, 0777)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	libKey := 0
+	tree := newLibTree(i.libDir)
 
 	// create intermediate struct to hold data per slot, because we'll write the aggregate in 1 file
 	slots := make(map[int]*SlotSrc, len(i.scriptExport.Slots))
@@ -66,6 +137,7 @@ func (i *SrcWriter) WriteTo(outputDir string) error {
 		slots[i] = &SlotSrc{
 			key:      i,
 			name:     slot.Name,
+			class:    slot.Class,
 			mainCode: []string{},
 			handlers: []*SlotSrcHandler{},
 		}
@@ -74,8 +146,8 @@ func (i *SrcWriter) WriteTo(outputDir string) error {
 	for _, handler := range i.scriptExport.Handlers {
 		code := handler.Code
 
-		// if marked as lib then we place it in the libs folder
-		if strings.HasPrefix(code, "-- !DU[lib]: ") {
+		// if marked as lib (or module) then we place it in the libs folder
+		if libHandlerRegex.MatchString(code) {
 			libHeaders := libHeaderRegex.FindAllString(code, -1)
 			libs := libHeaderRegex.Split(code, -1)[1:]
 
@@ -87,12 +159,32 @@ func (i *SrcWriter) WriteTo(outputDir string) error {
 				libHeader := libHeaders[k]
 
 				libHeaderMatch := libHeaderRegex.FindStringSubmatch(libHeader)
-				libName := libHeaderMatch[1]
+				libName := libHeaderMatch[3]
 
-				libPath := path.Join(outputDir, "lib", fmt.Sprintf("%d.%s.lua", libKey, libName))
-				libKey += 1
+				// libs from outside of the source directory are included again when exporting
+				if libHeaderMatch[1] == "ext" {
+					continue
+				}
+
+				// modules are unwrapped, they're wrapped again when they're required
+				if libHeaderMatch[2] == "module" {
+					libName = strings.ReplaceAll(libName, ".", "/")
+					if m := modulePreloadRegex.FindStringSubmatch(libCode); m != nil {
+						libCode = m[1]
+					}
+				}
+
+				libPath, err := tree.path(libName)
+				if err != nil {
+					return errors.WithStack(err)
+				}
 
-				err = ioutil.WriteFile(libPath, []byte(libCode), 0666)
+				err = fsys.MkdirAll(path.Dir(libPath), 0777)
+				if err != nil {
+					return errors.WithStack(err)
+				}
+
+				err = fsys.WriteFile(libPath, []byte(i.withLineEndings(libCode)), 0666)
 				if err != nil {
 					return errors.WithStack(err)
 				}
@@ -100,6 +192,13 @@ func (i *SrcWriter) WriteTo(outputDir string) error {
 
 		} else {
 			slotSrc := slots[handler.Filter.SlotKey]
+			if slotSrc == nil {
+				return errors.WithStack(&diagnostics.Diagnostic{
+					Severity: diagnostics.SEVERITY_ERROR,
+					Code:     CODE_UNKNOWN_SLOT,
+					Message:  fmt.Sprintf("handler [%d] is for slot [%d], which doesn't exist", handler.Key, handler.Filter.SlotKey),
+				})
+			}
 
 			sig, err := srcutils.MakeHeader(handler.Filter.Signature, handler.Filter.Args)
 			if err != nil {
@@ -110,13 +209,29 @@ func (i *SrcWriter) WriteTo(outputDir string) error {
 			lines := strings.Split(strings.TrimSuffix(code, "\n"), "\n")
 
 			// main code block in start() filter is special
-			if sig == "start()" && lines[0] == "-- !DU: main" {
+			isMain := sig == "start()" && lines[0] == "-- !DU: main"
+			if isMain {
 				// trim off the marker
 				lines = lines[1:]
 				// trim off 1 blank line
-				if lines[0] == "" {
+				if len(lines) > 0 && lines[0] == "" {
 					lines = lines[1:]
 				}
+			}
+
+			// markers in the code would be read as markers when exporting again
+			if markerRegex.MatchString(strings.Join(lines, "\n")) {
+				err := i.Warn(&diagnostics.Diagnostic{
+					File:    path.Join(i.slotsDir, fmt.Sprintf("%d.%s.lua", slotSrc.key, slotSrc.name)),
+					Code:    CODE_MARKER_IN_CODE,
+					Message: fmt.Sprintf("the code of handler [%d] %s contains a `-- !DU:` marker, which is read as a marker when it's exported again", handler.Key, sig),
+				})
+				if err != nil {
+					return errors.WithStack(err)
+				}
+			}
+
+			if isMain {
 				slotSrc.mainCode = append(slotSrc.mainCode, lines...)
 			} else {
 				slotSrc.handlers = append(slotSrc.handlers, &SlotSrcHandler{
@@ -128,28 +243,41 @@ func (i *SrcWriter) WriteTo(outputDir string) error {
 	}
 
 	for _, slotSrc := range slots {
-		if len(slotSrc.handlers) == 0 {
+		// slots without any code are only kept when they're linked to an element,
+		// the slots the game lists without an element are named after their slot (eg; `slot1`)
+		if len(slotSrc.handlers) == 0 && len(slotSrc.mainCode) == 0 &&
+			(slotSrc.key < 0 || (slotSrc.class == "" && slotSrc.name == fmt.Sprintf("slot%d", slotSrc.key+1))) {
 			continue
 		}
 
-		slotPath := path.Join(outputDir, "slots", fmt.Sprintf("%d.%s.lua", slotSrc.key, slotSrc.name))
+		slotPath := path.Join(i.slotsDir, fmt.Sprintf("%d.%s.lua", slotSrc.key, slotSrc.name))
 
 		out := make([]string, 0)
 
+		// the class of the slot goes on the first line
+		if slotSrc.class != "" {
+			out = append(out, fmt.Sprintf("-- !DU[class]: %s", slotSrc.class))
+			if len(slotSrc.handlers) == 0 && len(slotSrc.mainCode) == 0 {
+				out = append(out, "")
+			}
+		}
+
 		// add main code block first
-		out = append(out, slotSrc.mainCode...)
-		out = append(out, "")
+		if len(slotSrc.mainCode) > 0 {
+			out = append(out, slotSrc.mainCode...)
+			out = append(out, "")
+		}
 
 		// then add the handlers
 		for _, handler := range slotSrc.handlers {
 			// open our code block with `do` and its marker
 			out = append(out, fmt.Sprintf("do -- !DU: %s", handler.sig))
 
-			// indent the code @TODO: some crazy people like 2 spaces or tabs ...
+			// indent the code
 			indented := make([]string, len(handler.code))
 			for k, l := range handler.code {
 				if l != "" {
-					indented[k] = "    " + handler.code[k]
+					indented[k] = i.indent + handler.code[k]
 				}
 			}
 
@@ -159,7 +287,29 @@ func (i *SrcWriter) WriteTo(outputDir string) error {
 			out = append(out, fmt.Sprintf("end -- !DU: end"), "")
 		}
 
-		err = ioutil.WriteFile(slotPath, []byte(strings.Join(out, "\n")), 0666)
+		err = fsys.WriteFile(slotPath, []byte(strings.Join(out, i.lineEnding)), 0666)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	// anything that doesn't fit in the lua files goes in the metadata file, including the keys of the handlers
+	// when they're not the keys they get when they're read again
+	ordered := i.scriptExport
+	readKeys := make(map[*dustructs.Handler]int)
+	ordered.Handlers = i.readOrder()
+	for k, handler := range ordered.Handlers {
+		readKeys[handler] = k + 1
+	}
+
+	metadata := dustructs.NewMetadataFromScriptExport(&ordered, readKeys)
+	if !metadata.IsEmpty() {
+		res, err := json.MarshalIndent(metadata, "", "  ")
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		err = fsys.WriteFile(dustructs.METADATA_FILE, append(res, '\n'), 0666)
 		if err != nil {
 			return errors.WithStack(err)
 		}
@@ -167,3 +317,112 @@ func (i *SrcWriter) WriteTo(outputDir string) error {
 
 	return nil
 }
+
+// readOrder returns the handlers in the order they're read from the source files again; the libs first,
+// then the slot files in the order of their names with the main code block first.
+// The libs and the main code blocks of a slot are read as 1 handler, so only the first of those is included.
+func (i *SrcWriter) readOrder() []*dustructs.Handler {
+	var libHandler *dustructs.Handler
+	mainHandlers := make(map[int]*dustructs.Handler)
+	slotHandlers := make(map[int][]*dustructs.Handler)
+	slotFiles := make(map[string]int)
+
+	for _, handler := range i.scriptExport.Handlers {
+		slotKey := handler.Filter.SlotKey
+		slot := i.scriptExport.Slots[slotKey]
+
+		switch {
+		case libHandlerRegex.MatchString(handler.Code):
+			if libHandler == nil {
+				libHandler = handler
+			}
+			continue
+		case slot == nil:
+			continue
+		case handler.Filter.Signature == "start()" && strings.HasPrefix(handler.Code, "-- !DU: main"):
+			// a main code block that's blank isn't read as a handler
+			if mainHandlers[slotKey] == nil && strings.TrimSpace(strings.TrimPrefix(handler.Code, "-- !DU: main")) != "" {
+				mainHandlers[slotKey] = handler
+			}
+		default:
+			slotHandlers[slotKey] = append(slotHandlers[slotKey], handler)
+		}
+
+		slotFiles[fmt.Sprintf("%d.%s.lua", slotKey, slot.Name)] = slotKey
+	}
+
+	names := make([]string, 0, len(slotFiles))
+	for name := range slotFiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	res := make([]*dustructs.Handler, 0, len(i.scriptExport.Handlers))
+	if libHandler != nil {
+		res = append(res, libHandler)
+	}
+	for _, name := range names {
+		slotKey := slotFiles[name]
+		if mainHandlers[slotKey] != nil {
+			res = append(res, mainHandlers[slotKey])
+		}
+		res = append(res, slotHandlers[slotKey]...)
+	}
+
+	return res
+}
+
+func (i *SrcWriter) withLineEndings(code string) string {
+	if i.lineEnding == "\n" {
+		return code
+	}
+
+	return strings.ReplaceAll(code, "\n", i.lineEnding)
+}
+
+// libTree gives the lib files (and their directories) a number prefix, so they're read in the same order again.
+// They're numbered per directory, in the order they're added.
+type libTree struct {
+	root     string
+	counters map[string]int
+	dirs     map[string]string
+}
+
+func newLibTree(root string) *libTree {
+	return &libTree{
+		root:     root,
+		counters: make(map[string]int),
+		dirs:     make(map[string]string),
+	}
+}
+
+// path returns the path for a lib, eg; `lib/1.utils/0.strings.lua` for `utils/strings`
+func (t *libTree) path(name string) (string, error) {
+	parts := strings.Split(name, "/")
+	for _, part := range parts {
+		// don't let a lib marker write outside of the lib directory
+		if part == "" || part == "." || part == ".." {
+			return "", errors.Errorf("invalid lib name: %s", name)
+		}
+	}
+
+	dir := t.root
+	for k, part := range parts[:len(parts)-1] {
+		key := strings.Join(parts[:k+1], "/")
+		numbered, ok := t.dirs[key]
+		if !ok {
+			numbered = path.Join(dir, fmt.Sprintf("%d.%s", t.next(dir), part))
+			t.dirs[key] = numbered
+		}
+		dir = numbered
+	}
+
+	return path.Join(dir, fmt.Sprintf("%d.%s.lua", t.next(dir), parts[len(parts)-1])), nil
+}
+
+func (t *libTree) next(dir string) int {
+	n := t.counters[dir]
+	t.counters[dir]++
+
+	return n
+}
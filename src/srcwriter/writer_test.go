@@ -2,6 +2,7 @@ package srcwriter
 
 import (
 	"encoding/json"
+	"io/fs"
 	"io/ioutil"
 	"os"
 	"path"
@@ -22,6 +23,9 @@ func TestSrcWriter_WriteTo1(t *testing.T) {
 	err = json.Unmarshal(f, export)
 	assert.NoError(err)
 
+	err = os.MkdirAll(path.Join(utils.ROOT, "tmp"), 0777)
+	assert.NoError(err)
+
 	dir, err := ioutil.TempDir(path.Join(utils.ROOT, "tmp"), "test")
 	assert.NoError(err)
 	defer os.RemoveAll(dir) // always cleanup the mess
@@ -37,6 +41,62 @@ func TestSrcWriter_WriteTo1(t *testing.T) {
 	checkExpectedDir(assert, actualDir, expectedDir)
 }
 
+func TestSrcWriter_WriteTo2(t *testing.T) {
+	assert := require.New(t)
+
+	f, err := ioutil.ReadFile(path.Join(utils.ROOT, "testvectors/testvector2", "input.json"))
+	assert.NoError(err)
+
+	export := &dustructs.ScriptExport{}
+	err = json.Unmarshal(f, export)
+	assert.NoError(err)
+
+	err = os.MkdirAll(path.Join(utils.ROOT, "tmp"), 0777)
+	assert.NoError(err)
+
+	dir, err := ioutil.TempDir(path.Join(utils.ROOT, "tmp"), "test")
+	assert.NoError(err)
+	defer os.RemoveAll(dir) // always cleanup the mess
+
+	w := NewSrcWriter(export)
+	err = w.WriteTo(dir)
+	assert.NoError(err)
+
+	actualDir := dir
+	expectedDir := path.Join(utils.ROOT, "testvectors/testvector2", "output")
+
+	checkActualDir(assert, actualDir, expectedDir)
+	checkExpectedDir(assert, actualDir, expectedDir)
+}
+
+func TestSrcWriter_WriteTo3(t *testing.T) {
+	assert := require.New(t)
+
+	f, err := ioutil.ReadFile(path.Join(utils.ROOT, "testvectors/testvector3", "input.json"))
+	assert.NoError(err)
+
+	export := &dustructs.ScriptExport{}
+	err = json.Unmarshal(f, export)
+	assert.NoError(err)
+
+	err = os.MkdirAll(path.Join(utils.ROOT, "tmp"), 0777)
+	assert.NoError(err)
+
+	dir, err := ioutil.TempDir(path.Join(utils.ROOT, "tmp"), "test")
+	assert.NoError(err)
+	defer os.RemoveAll(dir) // always cleanup the mess
+
+	w := NewSrcWriter(export)
+	err = w.WriteTo(dir)
+	assert.NoError(err)
+
+	actualDir := dir
+	expectedDir := path.Join(utils.ROOT, "testvectors/testvector3", "output")
+
+	checkActualDir(assert, actualDir, expectedDir)
+	checkExpectedDir(assert, actualDir, expectedDir)
+}
+
 func checkActualDir(assert *require.Assertions, actualDir string, expectedDir string) {
 	actualFiles, err := ioutil.ReadDir(actualDir)
 	assert.NoError(err)
@@ -93,3 +153,355 @@ func fileExists(filename string) bool {
 	_, err := os.Stat(filename)
 	return !os.IsNotExist(err)
 }
+
+func TestSrcWriter_WriteToFS(t *testing.T) {
+	assert := require.New(t)
+
+	f, err := ioutil.ReadFile(path.Join(utils.ROOT, "testvectors/testvector2", "input.json"))
+	assert.NoError(err)
+
+	export := &dustructs.ScriptExport{}
+	err = json.Unmarshal(f, export)
+	assert.NoError(err)
+
+	fsys := NewMemFS()
+
+	w := NewSrcWriter(export)
+	err = w.WriteToFS(fsys)
+	assert.NoError(err)
+
+	expectedFS := os.DirFS(path.Join(utils.ROOT, "testvectors/testvector2", "output"))
+
+	actualFiles := make(map[string]string)
+	err = fs.WalkDir(fsys, ".", func(filePath string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			buf, err := fs.ReadFile(fsys, filePath)
+			actualFiles[filePath] = string(buf)
+			return err
+		}
+		return err
+	})
+	assert.NoError(err)
+
+	expectedFiles := make(map[string]string)
+	err = fs.WalkDir(expectedFS, ".", func(filePath string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			buf, err := fs.ReadFile(expectedFS, filePath)
+			expectedFiles[filePath] = string(buf)
+			return err
+		}
+		return err
+	})
+	assert.NoError(err)
+
+	assert.Equal(expectedFiles, actualFiles)
+}
+
+func TestSrcWriter_Settings(t *testing.T) {
+	assert := require.New(t)
+
+	export := dustructs.NewScriptExport()
+	export.Handlers = append(export.Handlers, &dustructs.Handler{
+		Code: "-- !DU[lib]: a\n\na = 1\nb = 2\n",
+		Filter: &dustructs.Filter{
+			Args:      []dustructs.Arg{},
+			Signature: "start()",
+			SlotKey:   dustructs.SLOT_IDX_UNIT,
+		},
+		Key: 1,
+	}, &dustructs.Handler{
+		Code: "if a then\n    print(b)\nend",
+		Filter: &dustructs.Filter{
+			Args:      []dustructs.Arg{{Value: "Live"}},
+			Signature: "tick(timerId)",
+			SlotKey:   dustructs.SLOT_IDX_UNIT,
+		},
+		Key: 2,
+	})
+
+	fsys := NewMemFS()
+
+	w := NewSrcWriter(export)
+	w.SetLayout("handlers", "libs")
+	w.SetIndent("\t")
+	w.SetLineEnding("\r\n")
+	err := w.WriteToFS(fsys)
+	assert.NoError(err)
+
+	lib, err := fs.ReadFile(fsys, "libs/0.a.lua")
+	assert.NoError(err)
+	assert.Equal("a = 1\r\nb = 2\r\n", string(lib))
+
+	slot, err := fs.ReadFile(fsys, "handlers/-1.unit.lua")
+	assert.NoError(err)
+	assert.Equal("do -- !DU: tick([Live])\r\n\tif a then\r\n\t    print(b)\r\n\tend\r\nend -- !DU: end\r\n", string(slot))
+}
+
+func TestSrcWriter_Modules(t *testing.T) {
+	assert := require.New(t)
+
+	export := dustructs.NewScriptExport()
+	export.Handlers = append(export.Handlers, &dustructs.Handler{
+		Code: "-- !DU[module]: vec\n" +
+			"package.preload[\"vec\"] = function(...)\n" +
+			"return {}\n" +
+			"end\n" +
+			"-- !DU[lib]: globals\n" +
+			"\n" +
+			"globals = true\n",
+		Filter: &dustructs.Filter{
+			Args:      []dustructs.Arg{},
+			Signature: "start()",
+			SlotKey:   dustructs.SLOT_IDX_UNIT,
+		},
+		Key: 1,
+	})
+
+	fsys := NewMemFS()
+
+	w := NewSrcWriter(export)
+	err := w.WriteToFS(fsys)
+	assert.NoError(err)
+
+	vec, err := fs.ReadFile(fsys, "lib/0.vec.lua")
+	assert.NoError(err)
+	assert.Equal("return {}\n", string(vec))
+
+	globals, err := fs.ReadFile(fsys, "lib/1.globals.lua")
+	assert.NoError(err)
+	assert.Equal("globals = true\n", string(globals))
+}
+
+func TestSrcWriter_NestedLib(t *testing.T) {
+	assert := require.New(t)
+
+	export := dustructs.NewScriptExport()
+	export.Handlers = append(export.Handlers, &dustructs.Handler{
+		Code: "-- !DU[module]: utils.strings\n" +
+			"package.preload[\"utils.strings\"] = function(...)\n" +
+			"return {}\n" +
+			"end\n" +
+			"-- !DU[lib]: utils/tables\n\ntables = 1\n" +
+			"-- !DU[lib]: b\n\nb = 1\n" +
+			"-- !DU[lib]: utils/deep/c\n\nc = 1\n",
+		Filter: &dustructs.Filter{
+			Args:      []dustructs.Arg{},
+			Signature: "start()",
+			SlotKey:   dustructs.SLOT_IDX_UNIT,
+		},
+		Key: 1,
+	})
+
+	fsys := NewMemFS()
+
+	w := NewSrcWriter(export)
+	err := w.WriteToFS(fsys)
+	assert.NoError(err)
+
+	for filePath, expected := range map[string]string{
+		"lib/0.utils/0.strings.lua":  "return {}\n",
+		"lib/0.utils/1.tables.lua":   "tables = 1\n",
+		"lib/1.b.lua":                "b = 1\n",
+		"lib/0.utils/2.deep/0.c.lua": "c = 1\n",
+	} {
+		actual, err := fs.ReadFile(fsys, filePath)
+		assert.NoError(err, filePath)
+		assert.Equal(expected, string(actual), filePath)
+	}
+
+	// lib markers can't be used to write outside of the lib directory
+	export.Handlers[0].Code = "-- !DU[lib]: ../../etc/passwd\n\nroot = 1\n"
+	err = NewSrcWriter(export).WriteToFS(NewMemFS())
+	assert.Error(err)
+}
+
+func TestSrcWriter_ExternalLibs(t *testing.T) {
+	assert := require.New(t)
+
+	export := dustructs.NewScriptExport()
+	export.Handlers = append(export.Handlers, &dustructs.Handler{
+		Code: "-- !DU[extmodule]: utils.strings\n" +
+			"package.preload[\"utils.strings\"] = function(...)\n" +
+			"return {}\n" +
+			"end\n" +
+			"-- !DU[extlib]: shared\n\nshared = 1\n" +
+			"-- !DU[lib]: own\n\nown = 1\n",
+		Filter: &dustructs.Filter{
+			Args:      []dustructs.Arg{},
+			Signature: "start()",
+			SlotKey:   dustructs.SLOT_IDX_UNIT,
+		},
+		Key: 1,
+	})
+
+	fsys := NewMemFS()
+
+	w := NewSrcWriter(export)
+	err := w.WriteToFS(fsys)
+	assert.NoError(err)
+
+	// only the own lib is written, the external libs are included again when exporting
+	files, err := fs.ReadDir(fsys, "lib")
+	assert.NoError(err)
+	assert.Equal(1, len(files))
+
+	own, err := fs.ReadFile(fsys, "lib/0.own.lua")
+	assert.NoError(err)
+	assert.Equal("own = 1\n", string(own))
+}
+
+func TestSrcWriter_Diagnostics(t *testing.T) {
+	assert := require.New(t)
+
+	export := dustructs.NewScriptExport()
+	export.Handlers = append(export.Handlers, &dustructs.Handler{
+		Code: "print(1)\n-- !DU: end\n",
+		Filter: &dustructs.Filter{
+			Args:      []dustructs.Arg{{Value: "Live"}},
+			Signature: "tick(timerId)",
+			SlotKey:   dustructs.SLOT_IDX_UNIT,
+		},
+		Key: 1,
+	})
+
+	// a marker in the code is a warning
+	w := NewSrcWriter(export)
+	assert.NoError(w.WriteToFS(NewMemFS()))
+	assert.Equal(1, len(w.Diagnostics()))
+	assert.Equal(CODE_MARKER_IN_CODE, w.Diagnostics()[0].Code)
+	assert.Equal("slots/-1.unit.lua", w.Diagnostics()[0].File)
+
+	// which is an error with strict
+	w = NewSrcWriter(export)
+	w.SetStrict(true)
+	assert.Error(w.WriteToFS(NewMemFS()))
+
+	// a handler for a slot that doesn't exist is an error
+	export.Handlers[0].Filter.SlotKey = 5
+	err := NewSrcWriter(export).WriteToFS(NewMemFS())
+	assert.Error(err)
+	assert.Equal("handler [1] is for slot [5], which doesn't exist", err.Error())
+}
+
+func TestSrcWriter_HandlerKeys(t *testing.T) {
+	assert := require.New(t)
+
+	newHandler := func(key int, slotKey int, signature string, code string) *dustructs.Handler {
+		return &dustructs.Handler{
+			Code:   code,
+			Filter: &dustructs.Filter{Args: []dustructs.Arg{}, Signature: signature, SlotKey: slotKey},
+			Key:    key,
+		}
+	}
+
+	// keys in the order they're read again aren't recorded
+	export := dustructs.NewScriptExport()
+	export.Handlers = []*dustructs.Handler{
+		newHandler(1, dustructs.SLOT_IDX_UNIT, "start()", "-- !DU[lib]: a\n\na = 1\n"),
+		newHandler(2, dustructs.SLOT_IDX_UNIT, "start()", "init()"),
+		newHandler(3, dustructs.SLOT_IDX_SYSTEM, "update()", "update()"),
+	}
+
+	fsys := NewMemFS()
+	err := NewSrcWriter(export).WriteToFS(fsys)
+	assert.NoError(err)
+	_, err = fs.Stat(fsys, dustructs.METADATA_FILE)
+	assert.True(os.IsNotExist(err))
+
+	// the others are, with the how many'th handler with the filter it is in the order they're read
+	export.Handlers = []*dustructs.Handler{
+		newHandler(0, dustructs.SLOT_IDX_SYSTEM, "update()", "update()"),
+		newHandler(5, dustructs.SLOT_IDX_UNIT, "start()", "second()"),
+		newHandler(4, dustructs.SLOT_IDX_UNIT, "start()", "first()"),
+	}
+
+	fsys = NewMemFS()
+	err = NewSrcWriter(export).WriteToFS(fsys)
+	assert.NoError(err)
+
+	buf, err := fs.ReadFile(fsys, dustructs.METADATA_FILE)
+	assert.NoError(err)
+
+	metadata := dustructs.NewMetadata()
+	err = json.Unmarshal(buf, metadata)
+	assert.NoError(err)
+
+	assert.Equal(3, len(metadata.Handlers))
+	for k, expected := range []struct {
+		slotKey int
+		n       int
+		key     int
+	}{{dustructs.SLOT_IDX_UNIT, 0, 5}, {dustructs.SLOT_IDX_UNIT, 1, 4}, {dustructs.SLOT_IDX_SYSTEM, 0, 0}} {
+		assert.Equal(expected.slotKey, metadata.Handlers[k].SlotKey)
+		assert.Equal(expected.n, metadata.Handlers[k].N)
+		assert.NotNil(metadata.Handlers[k].Key)
+		assert.Equal(expected.key, *metadata.Handlers[k].Key)
+	}
+}
+
+func TestSrcWriter_WriteToKeepsOtherFiles(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "dubby")
+	assert.NoError(err)
+	defer os.RemoveAll(dir) // always cleanup the mess
+
+	assert.NoError(os.MkdirAll(path.Join(dir, "slots"), 0777))
+	assert.NoError(os.MkdirAll(path.Join(dir, "lib"), 0777))
+	assert.NoError(ioutil.WriteFile(path.Join(dir, "slots/0.old.lua"), []byte("old()\n"), 0666))
+	assert.NoError(ioutil.WriteFile(path.Join(dir, "lib/0.old.lua"), []byte("old = 1\n"), 0666))
+	assert.NoError(ioutil.WriteFile(path.Join(dir, dustructs.METADATA_FILE), []byte("{}\n"), 0666))
+	assert.NoError(ioutil.WriteFile(path.Join(dir, "README.md"), []byte("# my script\n"), 0666))
+
+	export := dustructs.NewScriptExport()
+	export.Handlers = []*dustructs.Handler{{
+		Code:   "init()",
+		Filter: &dustructs.Filter{Args: []dustructs.Arg{}, Signature: "start()", SlotKey: dustructs.SLOT_IDX_UNIT},
+		Key:    1,
+	}}
+
+	err = NewSrcWriter(export).WriteTo(dir)
+	assert.NoError(err)
+
+	// the files dubby owns are replaced, anything else is left alone
+	_, err = os.Stat(path.Join(dir, "slots/0.old.lua"))
+	assert.True(os.IsNotExist(err))
+	_, err = os.Stat(path.Join(dir, "lib/0.old.lua"))
+	assert.True(os.IsNotExist(err))
+	_, err = os.Stat(path.Join(dir, dustructs.METADATA_FILE))
+	assert.True(os.IsNotExist(err))
+	_, err = os.Stat(path.Join(dir, "slots/-1.unit.lua"))
+	assert.NoError(err)
+
+	readme, err := ioutil.ReadFile(path.Join(dir, "README.md"))
+	assert.NoError(err)
+	assert.Equal("# my script\n", string(readme))
+
+	// the root of a project is never cleared
+	for _, projectFile := range []string{"dubby.yaml", ".git"} {
+		projectDir, err := ioutil.TempDir("", "dubby")
+		assert.NoError(err)
+		defer os.RemoveAll(projectDir) // always cleanup the mess
+
+		assert.NoError(os.MkdirAll(path.Join(projectDir, "slots"), 0777))
+		assert.NoError(ioutil.WriteFile(path.Join(projectDir, "slots/0.screen.lua"), []byte("render()\n"), 0666))
+		assert.NoError(ioutil.WriteFile(path.Join(projectDir, projectFile), []byte(""), 0666))
+
+		w := NewSrcWriter(export)
+		w.SetProjectFiles([]string{"dubby.yaml", ".git"})
+		err = w.WriteTo(projectDir)
+		assert.Error(err)
+		assert.Contains(err.Error(), "refusing to write to")
+
+		_, err = os.Stat(path.Join(projectDir, "slots/0.screen.lua"))
+		assert.NoError(err)
+	}
+
+	// and neither is the output dir itself when it's used as the slots or lib directory
+	w := NewSrcWriter(export)
+	w.SetLayout(".", "lib")
+	assert.Error(w.WriteTo(dir))
+
+	_, err = os.Stat(path.Join(dir, "README.md"))
+	assert.NoError(err)
+}
@@ -0,0 +1,120 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Watcher polls a set of files and directories for changes,
+// polling is used instead of filesystem events because editors save files in all kinds of (atomic) ways
+// and it's cheap enough for the size of a source directory.
+type Watcher struct {
+	paths    []string
+	interval time.Duration
+	debounce time.Duration
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+func NewWatcher(paths []string, interval time.Duration, debounce time.Duration) *Watcher {
+	return &Watcher{
+		paths:    paths,
+		interval: interval,
+		debounce: debounce,
+	}
+}
+
+// AddPath adds a file or directory to watch, must be called before Watch
+func (w *Watcher) AddPath(p string) {
+	w.paths = append(w.paths, p)
+}
+
+// Watch calls onChange when any of the files changes, is added or is removed,
+// a burst of changes only results in a single call once nothing has changed for the debounce duration.
+// Blocks until stop is closed.
+func (w *Watcher) Watch(stop <-chan struct{}, onChange func()) error {
+	prev, err := w.snapshot()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	var lastChange time.Time
+	pending := false
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		case now := <-ticker.C:
+			next, err := w.snapshot()
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			if changed(prev, next) {
+				pending = true
+				lastChange = now
+			}
+			prev = next
+
+			if pending && now.Sub(lastChange) >= w.debounce {
+				pending = false
+				onChange()
+			}
+		}
+	}
+}
+
+func (w *Watcher) snapshot() (map[string]fileState, error) {
+	snapshot := make(map[string]fileState)
+
+	for _, p := range w.paths {
+		err := filepath.Walk(p, func(filePath string, info os.FileInfo, err error) error {
+			if err != nil {
+				// files can disappear while we're walking, which is just another change
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return errors.WithStack(err)
+			}
+
+			if !info.IsDir() {
+				snapshot[filePath] = fileState{
+					modTime: info.ModTime(),
+					size:    info.Size(),
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	return snapshot, nil
+}
+
+func changed(prev map[string]fileState, next map[string]fileState) bool {
+	if len(prev) != len(next) {
+		return true
+	}
+
+	for filePath, state := range next {
+		prevState, ok := prev[filePath]
+		if !ok || !prevState.modTime.Equal(state.modTime) || prevState.size != state.size {
+			return true
+		}
+	}
+
+	return false
+}
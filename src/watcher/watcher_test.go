@@ -0,0 +1,72 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatcher_Debounce(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "dubby")
+	assert.NoError(err)
+	defer os.RemoveAll(dir) // always cleanup the mess
+
+	w := NewWatcher([]string{dir, path.Join(dir, "missing")}, 5*time.Millisecond, 100*time.Millisecond)
+
+	changes := make(chan struct{}, 10)
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- w.Watch(stop, func() {
+			changes <- struct{}{}
+		})
+	}()
+
+	// give the watcher time to take its first snapshot
+	time.Sleep(20 * time.Millisecond)
+
+	// a burst of saves
+	for i := 0; i < 3; i++ {
+		assert.NoError(ioutil.WriteFile(path.Join(dir, "a.lua"), []byte(strings.Repeat("x", i+1)), 0666))
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	select {
+	case <-changes:
+	case <-time.After(2 * time.Second):
+		assert.Fail("expected a change")
+	}
+
+	// removing a file is a change too
+	assert.NoError(os.Remove(path.Join(dir, "a.lua")))
+
+	select {
+	case <-changes:
+	case <-time.After(2 * time.Second):
+		assert.Fail("expected a change")
+	}
+
+	close(stop)
+	assert.NoError(<-done)
+	assert.Equal(0, len(changes))
+}
+
+func TestChanged(t *testing.T) {
+	assert := require.New(t)
+
+	now := time.Now()
+	prev := map[string]fileState{"a": {now, 1}}
+
+	assert.False(changed(prev, map[string]fileState{"a": {now, 1}}))
+	assert.True(changed(prev, map[string]fileState{"a": {now, 2}}))
+	assert.True(changed(prev, map[string]fileState{"a": {now.Add(time.Second), 1}}))
+	assert.True(changed(prev, map[string]fileState{"b": {now, 1}}))
+	assert.True(changed(prev, map[string]fileState{}))
+}
@@ -0,0 +1,90 @@
+package yamlimporter
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/rubensayshi/dubby/src/diagnostics"
+	"github.com/rubensayshi/dubby/src/dustructs"
+	"github.com/rubensayshi/dubby/src/srcutils"
+	"gopkg.in/yaml.v2"
+)
+
+func Import(inputFile string) (*dustructs.ScriptExport, error) {
+	i := NewImporter()
+
+	scriptExport, err := i.ReadFrom(inputFile)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return scriptExport, nil
+}
+
+func ImportReader(r io.Reader) (*dustructs.ScriptExport, error) {
+	i := NewImporter()
+
+	scriptExport, err := i.ReadFromReader(r)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return scriptExport, nil
+}
+
+type Importer struct {
+	diagnostics.Collector
+
+	inputFile string
+	filters   *srcutils.Filters
+}
+
+func NewImporter() *Importer {
+	return &Importer{
+		filters: srcutils.NewFilters(),
+	}
+}
+
+// SetFilters sets the filters that are known, handlers with any other filter are warned about
+func (i *Importer) SetFilters(filters *srcutils.Filters) {
+	i.filters = filters
+}
+
+func (i *Importer) ReadFrom(inputFile string) (*dustructs.ScriptExport, error) {
+	f, err := os.Open(inputFile)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+
+	i.inputFile = inputFile
+
+	return i.ReadFromReader(f)
+}
+
+func (i *Importer) ReadFromReader(r io.Reader) (*dustructs.ScriptExport, error) {
+	f, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	autoConf := &dustructs.AutoConf{}
+	err = yaml.Unmarshal(f, autoConf)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	export, err := autoConf.ToScriptExport()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	err = i.filters.Check(&i.Collector, i.inputFile, export)
+	if err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
@@ -0,0 +1,137 @@
+package yamlimporter
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/rubensayshi/dubby/src/dustructs"
+	"github.com/rubensayshi/dubby/src/srcutils"
+	"github.com/rubensayshi/dubby/src/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImport1(t *testing.T) {
+	assert := require.New(t)
+
+	f, err := ioutil.ReadFile(path.Join(utils.ROOT, "testvectors/testvector1", "input.json"))
+	assert.NoError(err)
+
+	expected := &dustructs.ScriptExport{}
+	err = json.Unmarshal(f, expected)
+	assert.NoError(err)
+
+	// the name of the auto configure module isn't in the JSON format
+	expected.Name = "testvector1"
+
+	actual, err := Import(path.Join(utils.ROOT, "testvectors/testvector1", "autoconf.yaml"))
+	assert.NoError(err)
+
+	assert.Equal(expected, actual)
+}
+
+func TestImportDuplicateFilters(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "dubby")
+	assert.NoError(err)
+	defer os.RemoveAll(dir) // always cleanup the mess
+
+	inputFile := path.Join(dir, "autoconf.conf")
+	err = ioutil.WriteFile(inputFile, []byte(`name: test
+slots:
+  core:
+    class: CoreUnit
+  screen:
+    class: ScreenUnit
+    select: manual
+handlers:
+  system:
+    actionStart:
+      args: [gear]
+      lua: toggleGear()
+    actionStart:
+      args: ["light"]
+      lua: toggleLight()
+  screen:
+    mouseDown:
+      args: ["*", "*"]
+      lua: |
+        click()
+`), 0666)
+	assert.NoError(err)
+
+	actual, err := Import(inputFile)
+	assert.NoError(err)
+
+	assert.Equal(5, len(actual.Slots))
+	assert.Equal("core", actual.Slots[0].Name)
+	assert.Equal("screen", actual.Slots[1].Name)
+
+	assert.Equal(3, len(actual.Handlers))
+	assert.Equal("actionStart([gear])", actual.Handlers[0].Filter.Signature)
+	assert.Equal(dustructs.SLOT_IDX_SYSTEM, actual.Handlers[0].Filter.SlotKey)
+	assert.Equal("actionStart([light])", actual.Handlers[1].Filter.Signature)
+	assert.Equal("toggleLight()", actual.Handlers[1].Code)
+	assert.Equal("mouseDown([*, *])", actual.Handlers[2].Filter.Signature)
+	assert.Equal(1, actual.Handlers[2].Filter.SlotKey)
+	assert.Equal("click()\n", actual.Handlers[2].Code)
+	assert.Equal(3, actual.Handlers[2].Key)
+}
+
+func TestImportReader(t *testing.T) {
+	assert := require.New(t)
+
+	expected, err := Import(path.Join(utils.ROOT, "testvectors/testvector1", "autoconf.yaml"))
+	assert.NoError(err)
+
+	f, err := os.Open(path.Join(utils.ROOT, "testvectors/testvector1", "autoconf.yaml"))
+	assert.NoError(err)
+	defer f.Close()
+
+	actual, err := ImportReader(f)
+	assert.NoError(err)
+
+	assert.Equal(expected, actual)
+}
+
+func TestImportUnknownFilter(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "dubby")
+	assert.NoError(err)
+	defer os.RemoveAll(dir) // always cleanup the mess
+
+	inputFile := path.Join(dir, "autoconf.conf")
+	err = ioutil.WriteFile(inputFile, []byte(`name: test
+slots:
+  door:
+    class: DoorUnit
+handlers:
+  door:
+    opened:
+      args: [1]
+      lua: open()
+  unit:
+    start:
+      lua: start()
+`), 0666)
+	assert.NoError(err)
+
+	// an unknown filter is a warning
+	i := NewImporter()
+	actual, err := i.ReadFrom(inputFile)
+	assert.NoError(err)
+	assert.Equal(2, len(actual.Handlers))
+	assert.Equal(1, len(i.Diagnostics()))
+	assert.Equal(srcutils.CODE_UNKNOWN_FILTER, i.Diagnostics()[0].Code)
+	assert.Equal(inputFile, i.Diagnostics()[0].File)
+
+	// which is an error with strict
+	i = NewImporter()
+	i.SetStrict(true)
+	_, err = i.ReadFrom(inputFile)
+	assert.Error(err)
+}
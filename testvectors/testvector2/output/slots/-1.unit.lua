@@ -0,0 +1,5 @@
+function draw() end
+
+do -- !DU: tick([redraw])
+    draw()
+end -- !DU: end
@@ -0,0 +1,5 @@
+function yeeehaaaa() end
+
+do -- !DU: tick([Live])
+    yeeehaaaa("tick")
+end -- !DU: end